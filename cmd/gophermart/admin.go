@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	userStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/sessions"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/config"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/database"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/policy"
+)
+
+const grantAdminUsage = "usage: gophermart grant-admin [-d dsn] login"
+
+// grantAdminRole of the grant-admin command. The first admin is granted by the operator once, after the account is
+// registered, so there is someone to grant roles to others. Nobody becomes admin just by registering a known login
+func grantAdminRole(args []string) error {
+	conf, args, err := config.ResolveForCommand("grant-admin", args)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+
+	if len(args) != 1 || args[0] == "" {
+		return errors.New(grantAdminUsage)
+	}
+
+	login := args[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.DatabaseTimeout)
+	defer cancel()
+
+	db, err := database.InitDB(ctx, conf.DatabaseDSN)
+	if err != nil {
+		return fmt.Errorf("init db failed: %w", err)
+	}
+
+	defer func() {
+		_ = db.Close()
+	}()
+
+	credentialsPolicy, err := policy.NewPolicy(&policy.Options{FoldLoginCase: true})
+	if err != nil {
+		return fmt.Errorf("failed to create credentials policy: %w", err)
+	}
+
+	users := userStorage.NewDatabaseRepository(db, conf.DatabaseTimeout)
+
+	// logins are stored normalized, users registered before normalization are found by the login as is
+	id, _, found, err := users.Find(ctx, credentialsPolicy.NormalizeLogin(login))
+	if err == nil && !found {
+		id, _, found, err = users.Find(ctx, login)
+	}
+
+	if err != nil {
+		return fmt.Errorf("find user failed: %w", err)
+	}
+
+	if !found {
+		return fmt.Errorf("user %q is not registered", login)
+	}
+
+	_, err = users.SetRole(ctx, id, user.RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("set role failed: %w", err)
+	}
+
+	// the role is in the tokens, so the user has to log in again
+	err = sessions.NewDatabaseRepository(db, conf.DatabaseTimeout).RevokeAll(ctx, id)
+	if err != nil {
+		return fmt.Errorf("revoke sessions failed: %w", err)
+	}
+
+	log.Logger().Infow("admin role granted", "login", login, "userID", id)
+
+	return nil
+}
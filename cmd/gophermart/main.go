@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	stdLog "log"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
@@ -14,22 +15,37 @@ import (
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order/accrual"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/webhook"
 	balanceStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/adjustments"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/idempotency"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/ledger"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/lots"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/pending"
 	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/withdrawals"
 	orderStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/order/jobs"
+	throttleStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/throttle"
 	userStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/keys"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/mfa"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/resets"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/sessions"
+	webhookStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/webhook"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/config"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/database"
-	"github.com/kuvalkin/gophermart-loyalty/internal/support/event"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/notifier"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/password"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/policy"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport"
 )
 
 func main() {
-	defer event.Release()
-
 	err := log.InitLogger()
 	if err != nil {
 		stdLog.Fatal(fmt.Errorf("failed to initialize logger: %w", err))
@@ -42,6 +58,26 @@ func main() {
 		}
 	}()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrations(os.Args[2:])
+		if err != nil {
+			log.Logger().Fatalw("failed to run migrations", "error", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "grant-admin" {
+		err = grantAdminRole(os.Args[2:])
+		if err != nil {
+			log.Logger().Fatalw("failed to grant admin role", "error", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	conf, err := config.Resolve()
 	if err != nil {
 		log.Logger().Fatalw("failed to resolve config", "error", err)
@@ -69,34 +105,56 @@ func main() {
 		os.Exit(1)
 	}
 
-	orderService, poller, err := initOrderService(conf, db)
+	keyRotator := user.NewKeyRotator(userService, conf.SigningKeyReloadInterval, conf.DatabaseTimeout)
+	defer func() {
+		err := keyRotator.Close()
+		if err != nil {
+			log.Logger().Fatalw("failed to close key rotator", "error", err)
+		}
+	}()
+
+	balanceService, err := initBalanceService(conf, db)
 	if err != nil {
-		log.Logger().Fatalw("failed to initialize order service", "error", err)
+		log.Logger().Fatalw("failed to initialize balance service", "error", err)
 		os.Exit(1)
 	}
+
+	reconciler := balance.NewReconciler(balanceService, conf.ReconciliationInterval, conf.DatabaseTimeout)
 	defer func() {
-		err := poller.Close()
+		err := reconciler.Close()
 		if err != nil {
-			log.Logger().Fatalw("failed to close poller", "error", err)
+			log.Logger().Fatalw("failed to close balance reconciler", "error", err)
 		}
 	}()
 
-	balanceService, err := initBalanceService(conf, db)
+	if conf.PointsTTL > 0 {
+		expirer := balance.NewExpirer(balanceService, conf.PointsExpirationInterval, conf.DatabaseTimeout)
+		defer func() {
+			err := expirer.Close()
+			if err != nil {
+				log.Logger().Fatalw("failed to close points expirer", "error", err)
+			}
+		}()
+	}
+
+	orderService, orderWorkers, err := initOrderService(conf, db, balanceService)
 	if err != nil {
-		log.Logger().Fatalw("failed to initialize balance service", "error", err)
+		log.Logger().Fatalw("failed to initialize order service", "error", err)
 		os.Exit(1)
 	}
 	defer func() {
-		err := balanceService.Close()
+		err := orderWorkers.Close()
 		if err != nil {
-			log.Logger().Fatalw("failed to close balance service", "error", err)
+			log.Logger().Fatalw("failed to close order workers", "error", err)
 		}
 	}()
 
 	serv := transport.NewServer(conf, &transport.Services{
-		User:    userService,
-		Order:   orderService,
-		Balance: balanceService,
+		User:     userService,
+		Order:    orderService,
+		Balance:  balanceService,
+		Throttle: initThrottleService(conf, db),
+		Webhook:  initWebhookService(conf, db),
 	})
 
 	go listenAndServe(serv)
@@ -116,12 +174,22 @@ func initDB(cnf *config.Config) (*sql.DB, error) {
 		return nil, fmt.Errorf("init db failed: %w", err)
 	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), cnf.DatabaseTimeout)
-	defer cancel()
+	if cnf.MigrateOnStart {
+		ctx, cancel = context.WithTimeout(context.Background(), cnf.MigrationTimeout)
+		defer cancel()
 
-	err = database.Migrate(ctx, db)
-	if err != nil {
-		return nil, fmt.Errorf("migrate failed: %w", err)
+		err = database.Migrate(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("migrate failed: %w", err)
+		}
+	} else {
+		ctx, cancel = context.WithTimeout(context.Background(), cnf.DatabaseTimeout)
+		defer cancel()
+
+		err = database.CheckMigrations(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("database is not migrated, run the migrate command: %w", err)
+		}
 	}
 
 	return db, nil
@@ -131,85 +199,195 @@ func initUserService(conf *config.Config, db *sql.DB) (user.Service, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), conf.DatabaseTimeout)
 	defer cancel()
 
-	tokenSecret, passwordHash, err := userStorage.GetSecretsFromDB(ctx, db)
+	legacySalt, err := userStorage.GetLegacyPasswordSaltFromDB(ctx, db)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secrets from db: %w", err)
+		return nil, fmt.Errorf("failed to read legacy password salt from db: %w", err)
 	}
 
-	if len(tokenSecret) == 0 {
-		tokenSecret, err = user.GenerateTokenSecret()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate token secret: %w", err)
-		}
-
-		err = userStorage.WriteTokenSecretToDB(ctx, db, tokenSecret)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save jwt secret to db: %w", err)
-		}
+	hasher, err := password.NewHasher(&password.Options{
+		Algorithm:  password.Algorithm(conf.PasswordHashAlgorithm),
+		LegacySalt: legacySalt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create password hasher: %w", err)
 	}
 
-	if passwordHash == "" {
-		salt, err := user.GeneratePasswordSalt()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate password salt: %w", err)
-		}
-
-		err = userStorage.WritePasswordSaltToDB(ctx, db, salt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save password salt to db: %w", err)
-		}
+	credentialsPolicy, err := policy.NewPolicy(&policy.Options{
+		MinLoginLength:        conf.MinLoginLength,
+		MaxLoginLength:        conf.MaxLoginLength,
+		FoldLoginCase:         conf.FoldLoginCase,
+		MinPasswordLength:     conf.MinPasswordLength,
+		MaxPasswordLength:     conf.MaxPasswordLength,
+		MinCharacterClasses:   conf.PasswordMinCharacterClasses,
+		BreachedPasswordsFile: conf.BreachedPasswordsFile,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credentials policy: %w", err)
 	}
 
 	return user.NewService(
 		userStorage.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		sessions.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		keys.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		resets.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		mfa.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		initNotifier(conf),
 		&user.Options{
-			TokenSecret:           tokenSecret,
-			PasswordSalt:          passwordHash,
-			MinPasswordLength:     conf.MinPasswordLength,
-			TokenExpirationPeriod: conf.TokenExpirationPeriod,
+			SigningAlgorithm:             user.SigningAlgorithm(conf.TokenSigningAlgorithm),
+			KeyRotationPeriod:            conf.SigningKeyRotationPeriod,
+			KeyReloadInterval:            conf.SigningKeyReloadInterval,
+			PasswordHasher:               hasher,
+			Policy:                       credentialsPolicy,
+			TokenExpirationPeriod:        conf.TokenExpirationPeriod,
+			RefreshTokenExpirationPeriod: conf.RefreshTokenExpirationPeriod,
+			ResetTokenExpirationPeriod:   conf.PasswordResetTokenExpirationPeriod,
+			MFAIssuer:                    conf.MFAIssuer,
+			MFAChallengeExpirationPeriod: conf.MFAChallengeExpirationPeriod,
 		},
 	)
 }
 
-func initOrderService(conf *config.Config, db *sql.DB) (order.Service, io.Closer, error) {
-	poller, err := accrual.NewPoller(conf.AccrualSystemAddress, conf.AccrualTimeout, conf.AccrualMaxRetries, conf.AccrualMaxRetryPeriod)
+func initNotifier(conf *config.Config) user.Notifier {
+	if conf.NotificationsFile != "" {
+		return notifier.NewFileNotifier(conf.NotificationsFile)
+	}
+
+	return notifier.NewLogNotifier()
+}
+
+func initOrderService(conf *config.Config, db *sql.DB, balanceService balance.Service) (order.Service, io.Closer, error) {
+	client, err := initAccrualClient(conf)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to initialize poller for order service: %w", err)
+		return nil, nil, fmt.Errorf("failed to initialize accrual client: %w", err)
 	}
 
-	service := order.NewService(
-		orderStorage.NewDatabaseRepository(db, conf.DatabaseTimeout),
-		poller,
+	// unprocessed orders are picked up from the persistent job queue, so nothing has to be re-enqueued on start
+	poller, err := accrual.NewPoller(
+		jobs.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		client,
+		&accrual.Options{
+			Timeout:          conf.AccrualTimeout,
+			MaxRetries:       conf.AccrualMaxRetries,
+			MaxRetryWaitTime: conf.AccrualMaxRetryPeriod,
+			PollInterval:     conf.AccrualPollInterval,
+		},
 	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize poller for order service: %w", err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), conf.DatabaseTimeout)
-	defer cancel()
-	unprocessed, err := orderStorage.GetUnprocessedOrders(ctx, db)
+	repo := orderStorage.NewDatabaseRepository(db, conf.DatabaseTimeout)
+	txProvider := transaction.NewDatabaseTransactionProvider(db)
+
+	service := order.NewService(repo, poller, balanceService, txProvider)
+
+	if conf.AccrualVerificationInterval <= 0 {
+		// client is used by the poller, so it is closed after it
+		return service, closers{poller, client}, nil
+	}
+
+	verifier := order.NewVerifier(repo, poller, balanceService, txProvider, &order.VerifierOptions{
+		Interval:  conf.AccrualVerificationInterval,
+		Window:    conf.AccrualVerificationWindow,
+		Period:    conf.AccrualVerificationPeriod,
+		BatchSize: 100,
+		Timeout:   conf.AccrualTimeout,
+	})
+
+	// verifier uses the poller, so it is closed first
+	return service, closers{verifier, poller, client}, nil
+}
+
+func initAccrualClient(conf *config.Config) (order.AccrualClient, error) {
+	u, err := url.Parse(conf.AccrualSystemAddress)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to retrieve unprocessed orders: %w", err)
+		return nil, fmt.Errorf("invalid accrual system address: %w", err)
 	}
 
-	for _, uo := range unprocessed {
-		err = service.AddToProcessQueue(uo.Number, uo.UserID, uo.CurrentStatus)
+	if u.Scheme == "grpc" {
+		return accrual.NewGRPCClient(u.Host)
+	}
+
+	return accrual.NewHTTPClient(conf.AccrualSystemAddress), nil
+}
+
+// closers are closed one by one in order
+type closers []io.Closer
+
+func (c closers) Close() error {
+	for _, closer := range c {
+		err := closer.Close()
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to add unprocessed order to queue: %w", err)
+			return err
 		}
 	}
 
-	return service, poller, nil
+	return nil
 }
 
 func initBalanceService(conf *config.Config, db *sql.DB) (balance.Service, error) {
-	service, err := balance.NewService(
+	programs, err := loyaltyPrograms(conf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid loyalty programs: %w", err)
+	}
+
+	return balance.NewService(
 		balanceStorage.NewDatabaseRepository(db, conf.DatabaseTimeout),
 		withdrawals.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		ledger.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		idempotency.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		lots.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		pending.NewDatabaseRepository(db, conf.DatabaseTimeout),
+		adjustments.NewDatabaseRepository(db, conf.DatabaseTimeout),
 		transaction.NewDatabaseTransactionProvider(db),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize balance service: %w", err)
+		&balance.Options{
+			PointsTTL:          conf.PointsTTL,
+			ExpiringSoonWindow: conf.PointsExpiringSoonWindow,
+			Programs:           programs,
+		},
+	), nil
+}
+
+func loyaltyPrograms(conf *config.Config) ([]*balance.Program, error) {
+	programs := make([]*balance.Program, 0, len(conf.LoyaltyPrograms))
+
+	for id, value := range conf.LoyaltyPrograms {
+		if id == "" || id == balance.DefaultProgram {
+			return nil, fmt.Errorf("program id %q is reserved", id)
+		}
+
+		rate, err := money.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("program %q: %w", id, err)
+		}
+
+		if rate <= 0 {
+			return nil, fmt.Errorf("program %q: point value must be positive", id)
+		}
+
+		programs = append(programs, &balance.Program{ID: id, Rate: int64(rate)})
 	}
 
-	return service, nil
+	return programs, nil
+}
+
+func initThrottleService(conf *config.Config, db *sql.DB) throttle.Service {
+	options := throttle.DefaultOptions
+	options.Login.LockoutThreshold = conf.LoginLockoutThreshold
+	options.Login.LockoutDuration = conf.LoginLockoutDuration
+
+	return throttle.NewService(throttleStorage.NewDatabaseRepository(db, conf.DatabaseTimeout), &options)
+}
+
+func initWebhookService(conf *config.Config, db *sql.DB) webhook.Service {
+	if conf.AccrualWebhookSecret == "" {
+		return nil
+	}
+
+	return webhook.NewService(webhookStorage.NewDatabaseRepository(db, conf.DatabaseTimeout), &webhook.Options{
+		Secret:    []byte(conf.AccrualWebhookSecret),
+		Tolerance: conf.AccrualWebhookTolerance,
+	})
 }
 
 func listenAndServe(serv *transport.Server) {
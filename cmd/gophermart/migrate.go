@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/config"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/database"
+)
+
+const migrateUsage = "usage: gophermart migrate [-d dsn] up | down [steps] | status"
+
+// runMigrations of the migrate command, so schema changes can be rolled out separately from the server
+func runMigrations(args []string) error {
+	conf, args, err := config.ResolveForCommand("migrate", args)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+
+	if len(args) == 0 || !slices.Contains([]string{"up", "down", "status"}, args[0]) {
+		return errors.New(migrateUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.DatabaseTimeout)
+	defer cancel()
+
+	db, err := database.InitDB(ctx, conf.DatabaseDSN)
+	if err != nil {
+		return fmt.Errorf("init db failed: %w", err)
+	}
+
+	defer func() {
+		_ = db.Close()
+	}()
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		printMigrations("applied", applied)
+
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q: %s", args[1], migrateUsage)
+			}
+		}
+
+		rolledBack, err := migrator.Down(ctx, steps)
+		printMigrations("rolled back", rolledBack)
+
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		printStatus(statuses)
+
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+func printMigrations(action string, migrations []*database.Migration) {
+	if len(migrations) == 0 {
+		fmt.Printf("nothing is %s\n", action)
+	}
+
+	for _, m := range migrations {
+		fmt.Printf("%s %04d_%s\n", action, m.Version, m.Name)
+	}
+}
+
+func printStatus(statuses []*database.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, s := range statuses {
+		status := "pending"
+		switch {
+		case s.Unknown:
+			status = "unknown, applied at " + s.AppliedAt.Format(time.RFC3339)
+		case s.Modified:
+			status = "modified, applied at " + s.AppliedAt.Format(time.RFC3339)
+		case !s.AppliedAt.IsZero():
+			status = "applied at " + s.AppliedAt.Format(time.RFC3339)
+		}
+
+		_, _ = fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, status)
+	}
+
+	_ = w.Flush()
+}
@@ -0,0 +1,157 @@
+package balance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func (s *service) Adjust(ctx context.Context, userID string, program string, operatorID string, amount int64, reason string) (*Adjustment, error) {
+	localLogger := s.logger.WithLazy(
+		"userID", userID,
+		"program", program,
+		"operatorID", operatorID,
+		"amount", amount,
+		"reason", reason,
+	)
+
+	if !s.HasProgram(program) {
+		localLogger.Debugw("unknown program")
+
+		return nil, ErrUnknownProgram
+	}
+
+	if amount == 0 {
+		localLogger.Debugw("zero amount")
+
+		return nil, ErrInvalidAdjustmentAmount
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		localLogger.Debugw("empty reason")
+
+		return nil, ErrEmptyAdjustmentReason
+	}
+
+	tx, err := s.txProvider.StartTransaction(ctx)
+	if err != nil {
+		localLogger.Errorw("error starting transaction", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	defer func() {
+		err := tx.Rollback()
+		if err != nil {
+			localLogger.Errorw("error rolling back transaction", "error", err)
+		}
+	}()
+
+	// balance is locked before lots, in the same order as in Withdraw
+	b, found, err := s.repo.Get(ctx, userID, program, tx)
+	if err != nil {
+		localLogger.Errorw("error getting balance", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	var current int64
+	if found {
+		current = b.Current
+	}
+
+	if current+amount < 0 {
+		localLogger.Debugw("balance is insufficient", "current", current)
+
+		return nil, ErrNotEnoughBalance
+	}
+
+	if amount < 0 {
+		// points of expired lots are still in the balance until they are swept
+		spendable, err := s.lots.Spendable(ctx, userID, program, tx)
+		if err != nil {
+			localLogger.Errorw("error getting spendable points", "error", err)
+
+			return nil, ErrInternal
+		}
+
+		if spendable+amount < 0 {
+			localLogger.Debugw("not enough spendable points", "spendable", spendable)
+
+			return nil, ErrNotEnoughBalance
+		}
+	}
+
+	adjustment := &Adjustment{
+		UserID:     userID,
+		Program:    program,
+		OperatorID: operatorID,
+		Amount:     amount,
+		Reason:     reason,
+	}
+
+	err = s.adjustments.Add(ctx, adjustment, tx)
+	if err != nil {
+		localLogger.Errorw("error adding adjustment", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	reference := fmt.Sprintf("adjustment/%d", adjustment.ID)
+
+	err = s.ledger.Post(ctx, &Posting{
+		UserID:      userID,
+		Program:     program,
+		Kind:        PostingManualAdjustment,
+		Amount:      amount,
+		Reference:   reference,
+		Description: reason,
+	}, tx)
+	if err != nil {
+		localLogger.Errorw("error posting adjustment", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	err = s.repo.Increase(ctx, userID, program, amount, tx)
+	if err != nil {
+		localLogger.Errorw("error adjusting balance", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if amount > 0 {
+		err = s.lots.Add(ctx, userID, program, amount, s.expiresAt(), tx)
+	} else {
+		_, err = s.lots.Consume(ctx, userID, program, reference, -amount, tx)
+	}
+
+	if err != nil {
+		localLogger.Errorw("error adjusting lots", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		localLogger.Errorw("error committing transaction", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	localLogger.Infow("balance adjusted", "adjustmentID", adjustment.ID)
+
+	return adjustment, nil
+}
+
+func (s *service) Adjustments(ctx context.Context, userID string) ([]*Adjustment, error) {
+	list, err := s.adjustments.List(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("error listing adjustments", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	return list, nil
+}
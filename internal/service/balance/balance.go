@@ -3,42 +3,337 @@ package balance
 import (
 	"context"
 	"errors"
-	"io"
+	"strconv"
 	"time"
 
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/pagination"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
+// Balance of points of a single program
 type Balance struct {
+	Program   string
 	Current   int64
 	Withdrawn int64
+	// ExpiringSoon part of the current balance
+	ExpiringSoon int64
+	// Pending provisional accrual of orders, that are not processed yet. It is not a part of the current balance
+	Pending int64
+	// Value of the current balance in rubles
+	Value int64
 }
 
+// DefaultProgram is the program of plain points, one point is worth one ruble
+const DefaultProgram = "default"
+
+// Program of loyalty points with its own conversion rate. Accrual is reported in rubles
+// and is converted into points of the program, the order is uploaded for
+type Program struct {
+	ID string
+	// Rate is the value of one point in kopecks
+	Rate int64
+}
+
+// Points worth the rubles, rounded toward zero
+func (p *Program) Points(rubles int64) int64 {
+	return rubles * 100 / p.Rate
+}
+
+// Value of the points in rubles, rounded toward zero
+func (p *Program) Value(points int64) int64 {
+	return points * p.Rate / 100
+}
+
+type Options struct {
+	// PointsTTL is the lifetime of accrued points, points never expire if zero
+	PointsTTL time.Duration
+	// ExpiringSoonWindow is how long before expiration points are reported as expiring soon
+	ExpiringSoonWindow time.Duration
+	// Programs besides the default one
+	Programs []*Program
+}
+
+type WithdrawalStatus string
+
+func (s WithdrawalStatus) String() string {
+	return string(s)
+}
+
+const WithdrawalCompleted = WithdrawalStatus("COMPLETED")
+
+// WithdrawalCancelled withdrawals are refunded to the balance
+const WithdrawalCancelled = WithdrawalStatus("CANCELLED")
+
 type WithdrawalHistoryEntry struct {
 	OrderNumber string
+	Program     string
 	Sum         int64
+	Status      WithdrawalStatus
 	ProcessedAt time.Time
+	// Cancellation is nil unless the withdrawal is cancelled
+	Cancellation *Cancellation
+}
+
+type Cancellation struct {
+	Reason      string
+	CancelledAt time.Time
 }
 
 var ErrInternal = errors.New("internal error")
 var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrInvalidOrderNumber = errors.New("invalid order number")
 var ErrInvalidWithdrawalSum = errors.New("invalid withdrawal sum")
+var ErrAlreadyWithdrawn = errors.New("points are already withdrawn for this order")
+var ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+var ErrIdempotencyKeyReused = errors.New("idempotency key is already used for another request")
+var ErrInvalidStatementFilter = errors.New("invalid statement filter")
+var ErrInvalidListFilter = errors.New("invalid list filter")
+var ErrWithdrawalNotFound = errors.New("withdrawal not found")
+var ErrAlreadyCancelled = errors.New("withdrawal is already cancelled")
+var ErrEmptyCancellationReason = errors.New("cancellation reason is required")
+var ErrInvalidAdjustmentAmount = errors.New("invalid adjustment amount")
+var ErrEmptyAdjustmentReason = errors.New("adjustment reason is required")
+var ErrUnknownProgram = errors.New("unknown loyalty program")
+
+// WithdrawalsFilter of user withdrawals. Withdrawals are sorted by processing time, newest first
+type WithdrawalsFilter struct {
+	// Statuses to include, all if empty
+	Statuses []WithdrawalStatus
+	// From and To limit processing time, inclusive and exclusive respectively. Zero means no limit
+	From time.Time
+	To   time.Time
+	// After is the cursor of the previous page, nil for the first page
+	After *pagination.Cursor
+	Limit int
+}
+
+type WithdrawalsPage struct {
+	Withdrawals []*WithdrawalHistoryEntry
+	// NextCursor points to the next page, nil if this is the last one
+	NextCursor *pagination.Cursor
+}
 
 type Service interface {
-	io.Closer
-	Get(ctx context.Context, userID string) (*Balance, error)
-	Withdraw(ctx context.Context, userID string, orderNumber string, sum int64) error
-	WithdrawalHistory(ctx context.Context, userID string) ([]*WithdrawalHistoryEntry, error)
+	// Get balances of every program, the default one goes first
+	Get(ctx context.Context, userID string) ([]*Balance, error)
+	HasProgram(program string) bool
+	// Withdraw points of the program for an order. Retry with the same idempotency key succeeds without withdrawing again,
+	// reuse of the key for another order, program or sum returns ErrIdempotencyKeyReused. Key is optional
+	Withdraw(ctx context.Context, userID string, program string, orderNumber string, sum int64, idempotencyKey string) error
+	WithdrawalHistory(ctx context.Context, userID string, filter *WithdrawalsFilter) (*WithdrawalsPage, error)
+	// CancelWithdrawal and refund withdrawn points to the balance
+	CancelWithdrawal(ctx context.Context, userID string, orderNumber string, reason string) error
+	// Adjust the balance manually on behalf of the operator. Negative amount can't drive the current balance negative
+	Adjust(ctx context.Context, userID string, program string, operatorID string, amount int64, reason string) (*Adjustment, error)
+	// Adjustments made to the user balance, newest first
+	Adjustments(ctx context.Context, userID string) ([]*Adjustment, error)
+	// Statement of all balance changes of the program, newest first, with the balance after each change
+	Statement(ctx context.Context, userID string, filter *StatementFilter) (*Statement, error)
+	// CreditAccrual of a processed order as a part of the transaction. Credits every order only once.
+	// Accrual is in rubles, it is converted into points of the program
+	CreditAccrual(ctx context.Context, userID string, program string, orderNumber string, sum int64, tx transaction.Transaction) error
+	// HoldAccrual of an order, that is not processed yet, as a part of the transaction.
+	// Provisional accrual is in rubles and is nil until the accrual system reports it
+	HoldAccrual(ctx context.Context, userID string, program string, orderNumber string, provisional *int64, tx transaction.Transaction) error
+	// ReleaseAccrual held for an order, that has reached a final status
+	ReleaseAccrual(ctx context.Context, orderNumber string, tx transaction.Transaction) error
+	// CorrectAccrual from the old accrual in rubles to the new one as a part of the transaction.
+	// Reference identifies the correction, so it is applied only once.
+	// Reduction is applied even if it drives the balance negative, because points are already spent
+	CorrectAccrual(ctx context.Context, userID string, program string, reference string, oldAccrual int64, newAccrual int64, tx transaction.Transaction) error
+	// Reconcile cached balances with the ledger and return users whose balances differ
+	Reconcile(ctx context.Context) ([]*Mismatch, error)
+	// ExpirePoints of up to limit expired lots and return the number of expired lots
+	ExpirePoints(ctx context.Context, limit int) (int, error)
 }
 
+// Repository of cached balances, that are kept in sync with the ledger. User has a separate balance for every program
 type Repository interface {
-	Get(ctx context.Context, userID string, tx transaction.Transaction) (*Balance, bool, error)
-	Increase(ctx context.Context, userID string, increment int64) error
-	Withdraw(ctx context.Context, userID string, decrement int64, tx transaction.Transaction) error
+	// Get cached balance. With transaction the balance is locked until the transaction ends
+	Get(ctx context.Context, userID string, program string, tx transaction.Transaction) (*Balance, bool, error)
+	Increase(ctx context.Context, userID string, program string, increment int64, tx transaction.Transaction) error
+	Withdraw(ctx context.Context, userID string, program string, decrement int64, tx transaction.Transaction) error
+	// Refund withdrawn points back to the current balance
+	Refund(ctx context.Context, userID string, program string, sum int64, tx transaction.Transaction) error
+}
+
+type PostingKind string
+
+func (k PostingKind) String() string {
+	return string(k)
+}
+
+const PostingAccrual = PostingKind("ACCRUAL")
+const PostingWithdrawal = PostingKind("WITHDRAWAL")
+const PostingAdjustment = PostingKind("ADJUSTMENT")
+
+// PostingReversal returns withdrawn points back to the balance
+const PostingReversal = PostingKind("REVERSAL")
+
+// PostingExpiration removes expired points from the balance, they are not counted as withdrawn
+const PostingExpiration = PostingKind("EXPIRATION")
+
+// PostingManualAdjustment is made by staff, unlike PostingAdjustment of accrual corrections
+const PostingManualAdjustment = PostingKind("MANUAL_ADJUSTMENT")
+
+// Posting is an immutable ledger entry, every change of a balance is a posting
+type Posting struct {
+	ID      int64
+	UserID  string
+	Program string
+	Kind    PostingKind
+	// Amount is positive for credits and negative for debits
+	Amount int64
+	// Reference to the source of the posting, e.g. order number
+	Reference string
+	CreatedAt time.Time
+	// Description shown to the user, e.g. reason of a manual adjustment. Optional
+	Description string
+}
+
+// OrderNumber the posting is made for, empty for postings of expired lots, accrual corrections and manual adjustments
+func (p *Posting) OrderNumber() string {
+	switch p.Kind {
+	case PostingAccrual, PostingWithdrawal, PostingReversal:
+		return p.Reference
+	default:
+		return ""
+	}
+}
+
+type StatementEntry struct {
+	*Posting
+	// Balance right after the posting
+	Balance int64
+}
+
+type Statement struct {
+	Entries []*StatementEntry
+	// NextCursor points to the next page, nil if this is the last one
+	NextCursor *pagination.Cursor
+}
+
+type StatementFilter struct {
+	// Program of the statement, every program has its own running balance
+	Program string
+	// From and To limit creation time of the entries, inclusive and exclusive respectively. Zero means no limit
+	From time.Time
+	To   time.Time
+	// After is the cursor of the previous page, nil for the first page. Its key is the ID of the last entry
+	After *pagination.Cursor
+	Limit int
+}
+
+// AfterID is the ID of the last entry of the previous page, zero for the first page
+func (f *StatementFilter) AfterID() int64 {
+	if f.After == nil {
+		return 0
+	}
+
+	// the cursor is validated by the service
+	id, _ := strconv.ParseInt(f.After.Key, 10, 64)
+
+	return id
+}
+
+type Mismatch struct {
+	UserID  string
+	Program string
+	Cached  *Balance
+	Ledger  *Balance
+}
+
+var ErrDuplicatePosting = errors.New("posting with this kind and reference already exists")
+
+// LedgerRepository is append-only, postings are never changed or deleted
+type LedgerRepository interface {
+	// Post a new entry. Returns ErrDuplicatePosting if user already has a posting of the same kind and reference
+	Post(ctx context.Context, posting *Posting, tx transaction.Transaction) error
+	// Totals of all user postings of the program. Withdrawn is a sum of withdrawals minus their reversals
+	Totals(ctx context.Context, userID string, program string) (*Balance, error)
+	// Statement returns up to filter.Limit user postings of the program, newest first, with running balances
+	Statement(ctx context.Context, userID string, filter *StatementFilter) ([]*StatementEntry, error)
+	// Mismatches between cached balances and ledger totals
+	Mismatches(ctx context.Context) ([]*Mismatch, error)
+}
+
+const MaxIdempotencyKeyLength = 255
+
+var ErrDuplicateIdempotencyKey = errors.New("idempotency key already exists")
+
+// IdempotencyRepository remembers requests by client provided keys.
+// Keys are saved in the transaction of the request, so only successful requests are remembered
+type IdempotencyRepository interface {
+	// Save key with the request fingerprint. Returns ErrDuplicateIdempotencyKey if the user has already used the key
+	Save(ctx context.Context, userID string, key string, fingerprint string, tx transaction.Transaction) error
+	// GetFingerprint of the request made with the key
+	GetFingerprint(ctx context.Context, userID string, key string, tx transaction.Transaction) (string, bool, error)
+}
+
+// Lot of accrued points, that expire together. Points are spent from the oldest lots first
+type Lot struct {
+	ID        int64
+	UserID    string
+	Program   string
+	Remaining int64
+	// ExpiresAt is zero for points that never expire
+	ExpiresAt time.Time
+}
+
+// LotsRepository changes lots only with the user balance locked
+type LotsRepository interface {
+	// Add a lot, zero expiresAt means the lot never expires
+	Add(ctx context.Context, userID string, program string, amount int64, expiresAt time.Time, tx transaction.Transaction) error
+	// Consume up to amount from the lots of the program expiring first and return the consumed amount.
+	// Consumption is remembered by reference, so it can be restored
+	Consume(ctx context.Context, userID string, program string, reference string, amount int64, tx transaction.Transaction) (int64, error)
+	// Restore points consumed with the reference back to their lots
+	Restore(ctx context.Context, userID string, reference string, tx transaction.Transaction) error
+	// Expired lots with remaining points, up to limit
+	Expired(ctx context.Context, limit int) ([]*Lot, error)
+	// Expire the lot if it is still expired and return remaining points it had
+	Expire(ctx context.Context, lotID int64, tx transaction.Transaction) (int64, error)
+	// ExpiringBefore returns the sum of remaining user points of the program expiring before the given time
+	ExpiringBefore(ctx context.Context, userID string, program string, before time.Time) (int64, error)
+	// Spendable returns the sum of remaining user points of the program, that are not expired yet.
+	// Expired lots are not swept right away, so the balance can still count their points
+	Spendable(ctx context.Context, userID string, program string, tx transaction.Transaction) (int64, error)
 }
 
 type WithdrawalsRepository interface {
-	Add(ctx context.Context, userID string, orderNumber string, sum int64, tx transaction.Transaction) error
-	List(ctx context.Context, userID string) ([]*WithdrawalHistoryEntry, error)
+	Add(ctx context.Context, userID string, program string, orderNumber string, sum int64, tx transaction.Transaction) error
+	// Get withdrawal. With transaction the withdrawal is locked until the transaction ends
+	Get(ctx context.Context, userID string, orderNumber string, tx transaction.Transaction) (*WithdrawalHistoryEntry, bool, error)
+	Cancel(ctx context.Context, userID string, orderNumber string, reason string, tx transaction.Transaction) error
+	// List up to filter.Limit user withdrawals
+	List(ctx context.Context, userID string, filter *WithdrawalsFilter) ([]*WithdrawalHistoryEntry, error)
+}
+
+// Adjustment of a balance made manually by staff
+type Adjustment struct {
+	ID      int64
+	UserID  string
+	Program string
+	// OperatorID of the staff user, who made the adjustment
+	OperatorID string
+	Amount     int64
+	Reason     string
+	CreatedAt  time.Time
+}
+
+// AdjustmentsRepository is an audit log of manual adjustments. It is append-only, adjustments are never changed or deleted
+type AdjustmentsRepository interface {
+	// Add the adjustment and fill its ID and creation time
+	Add(ctx context.Context, adjustment *Adjustment, tx transaction.Transaction) error
+	// List user adjustments, newest first
+	List(ctx context.Context, userID string) ([]*Adjustment, error)
+}
+
+// PendingRepository of accruals held for orders, that are not processed yet
+type PendingRepository interface {
+	// Hold accrual of the order in points of the program. Nil amount keeps the previously held amount, if any
+	Hold(ctx context.Context, userID string, program string, orderNumber string, amount *int64, tx transaction.Transaction) error
+	Release(ctx context.Context, orderNumber string, tx transaction.Transaction) error
+	// Sum of provisional accruals held for the user in the program
+	Sum(ctx context.Context, userID string, program string) (int64, error)
 }
@@ -0,0 +1,101 @@
+package balance
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+const expirationBatchSize = 100
+
+// NewExpirer periodically expires points, that have outlived their TTL
+func NewExpirer(service Service, interval time.Duration, timeout time.Duration) io.Closer {
+	e := &expirer{
+		service:  service,
+		interval: interval,
+		timeout:  timeout,
+		done:     make(chan struct{}),
+		wg:       &sync.WaitGroup{},
+		logger:   log.Logger().Named("pointsExpirer"),
+	}
+
+	e.wg.Add(1)
+	go e.run()
+
+	return e
+}
+
+type expirer struct {
+	service  Service
+	interval time.Duration
+	timeout  time.Duration
+	done     chan struct{}
+	wg       *sync.WaitGroup
+	logger   *zap.SugaredLogger
+}
+
+func (e *expirer) Close() error {
+	close(e.done)
+	e.wg.Wait()
+
+	return nil
+}
+
+func (e *expirer) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		e.expire()
+
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *expirer) expire() {
+	total := 0
+
+	for {
+		expired, err := e.expireBatch()
+		total += expired
+
+		if err != nil {
+			e.logger.Errorw("points expiration failed", "error", err)
+
+			break
+		}
+
+		// lots spent concurrently are not counted, so a short batch doesn't mean there are no more expired lots
+		if expired == 0 {
+			break
+		}
+
+		select {
+		case <-e.done:
+			return
+		default:
+		}
+	}
+
+	if total > 0 {
+		e.logger.Infow("points expiration completed", "lots", total)
+	}
+}
+
+func (e *expirer) expireBatch() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	return e.service.ExpirePoints(ctx, expirationBatchSize)
+}
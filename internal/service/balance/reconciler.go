@@ -0,0 +1,88 @@
+package balance
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+// NewReconciler periodically reconciles cached balances with the ledger and reports every mismatch
+func NewReconciler(service Service, interval time.Duration, timeout time.Duration) io.Closer {
+	r := &reconciler{
+		service:  service,
+		interval: interval,
+		timeout:  timeout,
+		done:     make(chan struct{}),
+		wg:       &sync.WaitGroup{},
+		logger:   log.Logger().Named("balanceReconciler"),
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r
+}
+
+type reconciler struct {
+	service  Service
+	interval time.Duration
+	timeout  time.Duration
+	done     chan struct{}
+	wg       *sync.WaitGroup
+	logger   *zap.SugaredLogger
+}
+
+func (r *reconciler) Close() error {
+	close(r.done)
+	r.wg.Wait()
+
+	return nil
+}
+
+func (r *reconciler) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.reconcile()
+
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *reconciler) reconcile() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	mismatches, err := r.service.Reconcile(ctx)
+	if err != nil {
+		r.logger.Errorw("reconciliation failed", "error", err)
+
+		return
+	}
+
+	for _, m := range mismatches {
+		r.logger.Errorw(
+			"cached balance differs from ledger",
+			"userID", m.UserID,
+			"program", m.Program,
+			"cachedCurrent", m.Cached.Current,
+			"cachedWithdrawn", m.Cached.Withdrawn,
+			"ledgerCurrent", m.Ledger.Current,
+			"ledgerWithdrawn", m.Ledger.Withdrawn,
+		)
+	}
+
+	r.logger.Infow("reconciliation completed", "mismatches", len(mismatches))
+}
@@ -2,58 +2,152 @@ package balance
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
-	"github.com/kuvalkin/gophermart-loyalty/internal/support/event"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/pagination"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
-func NewService(repo Repository, wRepo WithdrawalsRepository, txProvider transaction.Provider) (Service, error) {
-	s := &service{
+func NewService(
+	repo Repository,
+	wRepo WithdrawalsRepository,
+	ledger LedgerRepository,
+	idempotencyRepo IdempotencyRepository,
+	lots LotsRepository,
+	pending PendingRepository,
+	adjustments AdjustmentsRepository,
+	txProvider transaction.Provider,
+	options *Options,
+) Service {
+	programs := map[string]*Program{
+		DefaultProgram: {ID: DefaultProgram, Rate: 100},
+	}
+	programIDs := make([]string, 0, len(options.Programs))
+
+	for _, p := range options.Programs {
+		programs[p.ID] = p
+		programIDs = append(programIDs, p.ID)
+	}
+
+	// balances are listed in this order, the default program goes first
+	slices.Sort(programIDs)
+	programIDs = append([]string{DefaultProgram}, programIDs...)
+
+	return &service{
 		repo:            repo,
 		withdrawalsRepo: wRepo,
+		ledger:          ledger,
+		idempotencyRepo: idempotencyRepo,
+		lots:            lots,
+		pending:         pending,
+		adjustments:     adjustments,
 		txProvider:      txProvider,
+		options:         options,
+		programs:        programs,
+		programIDs:      programIDs,
 		logger:          log.Logger().Named("balanceService"),
 	}
-
-	err := event.Subscribe("order:processed", s.onOrderProcessed)
-	if err != nil {
-		return nil, fmt.Errorf("failed to subscribe to order processed event: %w", err)
-	}
-
-	return s, nil
 }
 
 type service struct {
 	repo            Repository
 	withdrawalsRepo WithdrawalsRepository
+	ledger          LedgerRepository
+	idempotencyRepo IdempotencyRepository
+	lots            LotsRepository
+	pending         PendingRepository
+	adjustments     AdjustmentsRepository
 	txProvider      transaction.Provider
+	options         *Options
+	programs        map[string]*Program
+	programIDs      []string
 	logger          *zap.SugaredLogger
 }
 
-func (s *service) Get(ctx context.Context, userID string) (*Balance, error) {
-	b, found, err := s.repo.Get(ctx, userID, nil)
+func (s *service) Get(ctx context.Context, userID string) ([]*Balance, error) {
+	result := make([]*Balance, 0, len(s.programIDs))
+
+	for _, id := range s.programIDs {
+		b, err := s.get(ctx, userID, s.programs[id])
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, b)
+	}
+
+	return result, nil
+}
+
+func (s *service) get(ctx context.Context, userID string, program *Program) (*Balance, error) {
+	localLogger := s.logger.WithLazy("userID", userID, "program", program.ID)
+
+	b, err := s.ledger.Totals(ctx, userID, program.ID)
 	if err != nil {
-		s.logger.Errorw("error getting balance", "userID", userID, "error", err)
+		localLogger.Errorw("error getting balance", "error", err)
 
 		return nil, ErrInternal
 	}
-	if !found {
-		s.logger.Debugw("balance not found, returning empty value", "userID", userID)
 
-		// there is no record in repo until user withdraws or uploads smth
-		return &Balance{}, nil
+	b.Program = program.ID
+	b.Value = program.Value(b.Current)
+
+	if s.options.PointsTTL > 0 {
+		b.ExpiringSoon, err = s.lots.ExpiringBefore(ctx, userID, program.ID, time.Now().Add(s.options.ExpiringSoonWindow))
+		if err != nil {
+			localLogger.Errorw("error getting expiring points", "error", err)
+
+			return nil, ErrInternal
+		}
+	}
+
+	b.Pending, err = s.pending.Sum(ctx, userID, program.ID)
+	if err != nil {
+		localLogger.Errorw("error getting pending accrual", "error", err)
+
+		return nil, ErrInternal
 	}
 
 	return b, nil
 }
 
-func (s *service) Withdraw(ctx context.Context, userID string, orderNumber string, sum int64) error {
-	localLogger := s.logger.WithLazy("userID", userID, "orderNumber", orderNumber, "sum", sum)
+func (s *service) HasProgram(program string) bool {
+	_, ok := s.programs[program]
+
+	return ok
+}
+
+func (s *service) Withdraw(ctx context.Context, userID string, program string, orderNumber string, sum int64, idempotencyKey string) error {
+	localLogger := s.logger.WithLazy(
+		"userID", userID,
+		"program", program,
+		"orderNumber", orderNumber,
+		"sum", sum,
+		"idempotencyKey", idempotencyKey,
+	)
+
+	if !s.HasProgram(program) {
+		localLogger.Debugw("unknown program")
+
+		return ErrUnknownProgram
+	}
+
+	if len(idempotencyKey) > MaxIdempotencyKeyLength {
+		localLogger.Debugw("idempotency key is too long")
+
+		return ErrInvalidIdempotencyKey
+	}
 
 	err := order.ValidateNumber(orderNumber)
 	if err != nil {
@@ -82,7 +176,20 @@ func (s *service) Withdraw(ctx context.Context, userID string, orderNumber strin
 		}
 	}()
 
-	b, found, err := s.repo.Get(ctx, userID, tx)
+	if idempotencyKey != "" {
+		replay, err := s.checkIdempotencyKey(ctx, userID, idempotencyKey, withdrawalFingerprint(program, orderNumber, sum), tx)
+		if err != nil {
+			return err
+		}
+
+		if replay {
+			localLogger.Infow("withdrawal is already made with this idempotency key, skipping")
+
+			return nil
+		}
+	}
+
+	b, found, err := s.repo.Get(ctx, userID, program, tx)
 	if err != nil {
 		localLogger.Errorw("error getting balance", "error", err)
 
@@ -95,20 +202,51 @@ func (s *service) Withdraw(ctx context.Context, userID string, orderNumber strin
 		return ErrNotEnoughBalance
 	}
 
-	if sum > b.Current {
-		localLogger.Debugw("balance is insufficient", "current", b.Current)
+	spendable, err := s.lots.Spendable(ctx, userID, program, tx)
+	if err != nil {
+		localLogger.Errorw("error getting spendable points", "error", err)
+
+		return ErrInternal
+	}
+
+	if sum > min(b.Current, spendable) {
+		localLogger.Debugw("balance is insufficient", "current", b.Current, "spendable", spendable)
 
 		return ErrNotEnoughBalance
 	}
 
-	err = s.repo.Withdraw(ctx, userID, sum, tx)
+	err = s.repo.Withdraw(ctx, userID, program, sum, tx)
 	if err != nil {
 		localLogger.Errorw("error withdrawing balance", "error", err)
 
 		return ErrInternal
 	}
 
-	err = s.withdrawalsRepo.Add(ctx, userID, orderNumber, sum, tx)
+	_, err = s.lots.Consume(ctx, userID, program, orderNumber, sum, tx)
+	if err != nil {
+		localLogger.Errorw("error consuming lots", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.ledger.Post(ctx, &Posting{
+		UserID:    userID,
+		Program:   program,
+		Kind:      PostingWithdrawal,
+		Amount:    -sum,
+		Reference: orderNumber,
+	}, tx)
+	if errors.Is(err, ErrDuplicatePosting) {
+		localLogger.Debugw("order is already used for withdrawal")
+
+		return ErrAlreadyWithdrawn
+	} else if err != nil {
+		localLogger.Errorw("error posting withdrawal", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.withdrawalsRepo.Add(ctx, userID, program, orderNumber, sum, tx)
 	if err != nil {
 		localLogger.Errorw("error writing history", "error", err)
 
@@ -125,30 +263,527 @@ func (s *service) Withdraw(ctx context.Context, userID string, orderNumber strin
 	return nil
 }
 
-func (s *service) WithdrawalHistory(ctx context.Context, userID string) ([]*WithdrawalHistoryEntry, error) {
-	list, err := s.withdrawalsRepo.List(ctx, userID)
+// checkIdempotencyKey saves the key as a part of the transaction and reports whether the request is a retry.
+// Concurrent requests with the same key wait until the first one is committed or rolled back
+func (s *service) checkIdempotencyKey(ctx context.Context, userID string, key string, fingerprint string, tx transaction.Transaction) (bool, error) {
+	localLogger := s.logger.WithLazy("userID", userID, "idempotencyKey", key)
+
+	err := s.idempotencyRepo.Save(ctx, userID, key, fingerprint, tx)
+	if err == nil {
+		return false, nil
+	} else if !errors.Is(err, ErrDuplicateIdempotencyKey) {
+		localLogger.Errorw("error saving idempotency key", "error", err)
+
+		return false, ErrInternal
+	}
+
+	saved, found, err := s.idempotencyRepo.GetFingerprint(ctx, userID, key, tx)
+	if err != nil || !found {
+		localLogger.Errorw("error getting idempotency key", "error", err, "found", found)
+
+		return false, ErrInternal
+	}
+
+	if saved != fingerprint {
+		localLogger.Debugw("idempotency key is reused for another request")
+
+		return false, ErrIdempotencyKeyReused
+	}
+
+	return true, nil
+}
+
+func withdrawalFingerprint(program string, orderNumber string, sum int64) string {
+	request := fmt.Sprintf("withdraw:%s:%d", orderNumber, sum)
+	if program != DefaultProgram {
+		// keys saved before programs were introduced still match retries of the same request
+		request = fmt.Sprintf("withdraw:%s:%s:%d", program, orderNumber, sum)
+	}
+
+	hash := sha256.Sum256([]byte(request))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *service) WithdrawalHistory(ctx context.Context, userID string, filter *WithdrawalsFilter) (*WithdrawalsPage, error) {
+	localLogger := s.logger.WithLazy("userID", userID, "filter", filter)
+
+	f := *filter
+
+	limit, err := pagination.Limit(f.Limit)
+	if err != nil {
+		localLogger.Debugw("invalid limit", "error", err)
+
+		return nil, ErrInvalidListFilter
+	}
+
+	for _, status := range f.Statuses {
+		if status != WithdrawalCompleted && status != WithdrawalCancelled {
+			localLogger.Debugw("invalid status", "status", status)
+
+			return nil, ErrInvalidListFilter
+		}
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		localLogger.Debugw("invalid date range")
+
+		return nil, ErrInvalidListFilter
+	}
+
+	// one extra withdrawal tells whether there is a next page
+	f.Limit = limit + 1
+
+	list, err := s.withdrawalsRepo.List(ctx, userID, &f)
 	if err != nil {
-		s.logger.Errorw("error listing withdrawals", "error", err, "userID", userID)
+		localLogger.Errorw("error listing withdrawals", "error", err)
 
 		return nil, ErrInternal
 	}
 
-	return list, nil
+	page := &WithdrawalsPage{Withdrawals: list}
+	if len(list) > limit {
+		page.Withdrawals = list[:limit]
+
+		last := page.Withdrawals[limit-1]
+		page.NextCursor = &pagination.Cursor{Time: last.ProcessedAt, Key: last.OrderNumber}
+	}
+
+	return page, nil
 }
 
-func (s *service) Close() error {
-	err := event.Unsubscribe("order:processed", s.onOrderProcessed)
+func (s *service) CancelWithdrawal(ctx context.Context, userID string, orderNumber string, reason string) error {
+	localLogger := s.logger.WithLazy("userID", userID, "orderNumber", orderNumber, "reason", reason)
+
+	if strings.TrimSpace(reason) == "" {
+		localLogger.Debugw("empty reason")
+
+		return ErrEmptyCancellationReason
+	}
+
+	tx, err := s.txProvider.StartTransaction(ctx)
+	if err != nil {
+		localLogger.Errorw("error starting transaction", "error", err)
+
+		return ErrInternal
+	}
+
+	defer func() {
+		err := tx.Rollback()
+		if err != nil {
+			localLogger.Errorw("error rolling back transaction", "error", err)
+		}
+	}()
 
+	// program of the withdrawal never changes, so it is known before anything is locked
+	w, found, err := s.withdrawalsRepo.Get(ctx, userID, orderNumber, nil)
 	if err != nil {
-		return fmt.Errorf("failed to unsubscribe to order processed event: %w", err)
+		localLogger.Errorw("error getting withdrawal", "error", err)
+
+		return ErrInternal
 	}
 
+	if !found {
+		localLogger.Debugw("withdrawal not found")
+
+		return ErrWithdrawalNotFound
+	}
+
+	program := w.Program
+
+	// balance is locked before the withdrawal, in the same order as in Withdraw
+	_, found, err = s.repo.Get(ctx, userID, program, tx)
+	if err != nil {
+		localLogger.Errorw("error getting balance", "error", err)
+
+		return ErrInternal
+	}
+
+	if !found {
+		localLogger.Debugw("balance not found")
+
+		return ErrWithdrawalNotFound
+	}
+
+	w, found, err = s.withdrawalsRepo.Get(ctx, userID, orderNumber, tx)
+	if err != nil {
+		localLogger.Errorw("error getting withdrawal", "error", err)
+
+		return ErrInternal
+	}
+
+	if !found {
+		localLogger.Debugw("withdrawal not found")
+
+		return ErrWithdrawalNotFound
+	}
+
+	if w.Status == WithdrawalCancelled {
+		localLogger.Debugw("withdrawal is already cancelled")
+
+		return ErrAlreadyCancelled
+	}
+
+	err = s.withdrawalsRepo.Cancel(ctx, userID, orderNumber, reason, tx)
+	if err != nil {
+		localLogger.Errorw("error cancelling withdrawal", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.ledger.Post(ctx, &Posting{
+		UserID:    userID,
+		Program:   program,
+		Kind:      PostingReversal,
+		Amount:    w.Sum,
+		Reference: orderNumber,
+	}, tx)
+	if err != nil {
+		localLogger.Errorw("error posting reversal", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.repo.Refund(ctx, userID, program, w.Sum, tx)
+	if err != nil {
+		localLogger.Errorw("error refunding balance", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.lots.Restore(ctx, userID, orderNumber, tx)
+	if err != nil {
+		localLogger.Errorw("error restoring lots", "error", err)
+
+		return ErrInternal
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		localLogger.Errorw("error committing transaction", "error", err)
+
+		return ErrInternal
+	}
+
+	localLogger.Infow("withdrawal cancelled", "sum", w.Sum)
+
 	return nil
 }
 
-func (s *service) onOrderProcessed(userID string, accrual int64) {
-	err := s.repo.Increase(context.Background(), userID, accrual)
+func (s *service) Statement(ctx context.Context, userID string, filter *StatementFilter) (*Statement, error) {
+	localLogger := s.logger.WithLazy("userID", userID, "filter", filter)
+
+	f := *filter
+
+	limit, err := pagination.Limit(f.Limit)
+	if err != nil {
+		localLogger.Debugw("invalid limit", "error", err)
+
+		return nil, ErrInvalidStatementFilter
+	}
+
+	if f.Program == "" {
+		f.Program = DefaultProgram
+	}
+
+	if !s.HasProgram(f.Program) {
+		localLogger.Debugw("unknown program")
+
+		return nil, ErrUnknownProgram
+	}
+
+	if f.After != nil {
+		id, err := strconv.ParseInt(f.After.Key, 10, 64)
+		if err != nil || id <= 0 {
+			localLogger.Debugw("invalid cursor")
+
+			return nil, ErrInvalidStatementFilter
+		}
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		localLogger.Debugw("invalid date range")
+
+		return nil, ErrInvalidStatementFilter
+	}
+
+	// one extra entry tells whether there is a next page
+	f.Limit = limit + 1
+
+	entries, err := s.ledger.Statement(ctx, userID, &f)
 	if err != nil {
-		s.logger.Errorw("failed to increase balance", "userID", userID, "error", err)
+		localLogger.Errorw("error getting statement", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	statement := &Statement{Entries: entries}
+	if len(entries) > limit {
+		statement.Entries = entries[:limit]
+
+		last := statement.Entries[limit-1]
+		statement.NextCursor = &pagination.Cursor{Time: last.CreatedAt, Key: strconv.FormatInt(last.ID, 10)}
 	}
+
+	return statement, nil
+}
+
+func (s *service) CreditAccrual(ctx context.Context, userID string, program string, orderNumber string, sum int64, tx transaction.Transaction) error {
+	localLogger := s.logger.WithLazy("userID", userID, "program", program, "orderNumber", orderNumber, "sum", sum)
+
+	p, ok := s.programs[program]
+	if !ok {
+		localLogger.Errorw("unknown program")
+
+		return ErrUnknownProgram
+	}
+
+	points := p.Points(sum)
+
+	err := s.ledger.Post(ctx, &Posting{
+		UserID:    userID,
+		Program:   program,
+		Kind:      PostingAccrual,
+		Amount:    points,
+		Reference: orderNumber,
+	}, tx)
+	if errors.Is(err, ErrDuplicatePosting) {
+		localLogger.Infow("accrual is already credited, skipping")
+
+		return nil
+	} else if err != nil {
+		localLogger.Errorw("error posting accrual", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.repo.Increase(ctx, userID, program, points, tx)
+	if err != nil {
+		localLogger.Errorw("failed to increase balance", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.lots.Add(ctx, userID, program, points, s.expiresAt(), tx)
+	if err != nil {
+		localLogger.Errorw("failed to add lot", "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (s *service) HoldAccrual(ctx context.Context, userID string, program string, orderNumber string, provisional *int64, tx transaction.Transaction) error {
+	localLogger := s.logger.WithLazy("userID", userID, "program", program, "orderNumber", orderNumber)
+
+	p, ok := s.programs[program]
+	if !ok {
+		localLogger.Errorw("unknown program")
+
+		return ErrUnknownProgram
+	}
+
+	var points *int64
+	if provisional != nil {
+		converted := p.Points(*provisional)
+		points = &converted
+	}
+
+	err := s.pending.Hold(ctx, userID, program, orderNumber, points, tx)
+	if err != nil {
+		localLogger.Errorw("failed to hold accrual", "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (s *service) ReleaseAccrual(ctx context.Context, orderNumber string, tx transaction.Transaction) error {
+	err := s.pending.Release(ctx, orderNumber, tx)
+	if err != nil {
+		s.logger.Errorw("failed to release accrual", "orderNumber", orderNumber, "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+// expiresAt of points accrued now, zero if points never expire
+func (s *service) expiresAt() time.Time {
+	if s.options.PointsTTL <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(s.options.PointsTTL)
+}
+
+func (s *service) CorrectAccrual(ctx context.Context, userID string, program string, reference string, oldAccrual int64, newAccrual int64, tx transaction.Transaction) error {
+	localLogger := s.logger.WithLazy(
+		"userID", userID,
+		"program", program,
+		"reference", reference,
+		"oldAccrual", oldAccrual,
+		"newAccrual", newAccrual,
+	)
+
+	p, ok := s.programs[program]
+	if !ok {
+		localLogger.Errorw("unknown program")
+
+		return ErrUnknownProgram
+	}
+
+	// points are rounded the same way as they were credited, so corrections don't drift from credited points
+	points := p.Points(newAccrual) - p.Points(oldAccrual)
+
+	err := s.ledger.Post(ctx, &Posting{
+		UserID:    userID,
+		Program:   program,
+		Kind:      PostingAdjustment,
+		Amount:    points,
+		Reference: reference,
+	}, tx)
+	if errors.Is(err, ErrDuplicatePosting) {
+		localLogger.Infow("correction is already applied, skipping")
+
+		return nil
+	} else if err != nil {
+		localLogger.Errorw("error posting correction", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.repo.Increase(ctx, userID, program, points, tx)
+	if err != nil {
+		localLogger.Errorw("failed to correct balance", "error", err)
+
+		return ErrInternal
+	}
+
+	if points > 0 {
+		err = s.lots.Add(ctx, userID, program, points, s.expiresAt(), tx)
+	} else {
+		// points that are already spent can't be taken from lots, the balance goes negative instead
+		_, err = s.lots.Consume(ctx, userID, program, reference, -points, tx)
+	}
+
+	if err != nil {
+		localLogger.Errorw("failed to correct lots", "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (s *service) Reconcile(ctx context.Context) ([]*Mismatch, error) {
+	mismatches, err := s.ledger.Mismatches(ctx)
+	if err != nil {
+		s.logger.Errorw("error reconciling balances", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	return mismatches, nil
+}
+
+func (s *service) ExpirePoints(ctx context.Context, limit int) (int, error) {
+	lots, err := s.lots.Expired(ctx, limit)
+	if err != nil {
+		s.logger.Errorw("error getting expired lots", "error", err)
+
+		return 0, ErrInternal
+	}
+
+	expired := 0
+	for _, lot := range lots {
+		ok, err := s.expireLot(ctx, lot)
+		if err != nil {
+			return expired, err
+		}
+
+		if ok {
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// expireLot returns false if the lot was already expired or spent concurrently
+func (s *service) expireLot(ctx context.Context, lot *Lot) (bool, error) {
+	localLogger := s.logger.WithLazy("userID", lot.UserID, "program", lot.Program, "lotID", lot.ID)
+
+	tx, err := s.txProvider.StartTransaction(ctx)
+	if err != nil {
+		localLogger.Errorw("error starting transaction", "error", err)
+
+		return false, ErrInternal
+	}
+
+	defer func() {
+		err := tx.Rollback()
+		if err != nil {
+			localLogger.Errorw("error rolling back transaction", "error", err)
+		}
+	}()
+
+	// balance is locked before lots, in the same order as in Withdraw
+	b, found, err := s.repo.Get(ctx, lot.UserID, lot.Program, tx)
+	if err != nil {
+		localLogger.Errorw("error getting balance", "error", err)
+
+		return false, ErrInternal
+	}
+
+	remaining, err := s.lots.Expire(ctx, lot.ID, tx)
+	if err != nil {
+		localLogger.Errorw("error expiring lot", "error", err)
+
+		return false, ErrInternal
+	}
+
+	// lots may hold more than the balance, if the balance went negative after accrual correction
+	var amount int64
+	if found {
+		amount = max(0, min(remaining, b.Current))
+	}
+
+	if amount > 0 {
+		err = s.ledger.Post(ctx, &Posting{
+			UserID:    lot.UserID,
+			Program:   lot.Program,
+			Kind:      PostingExpiration,
+			Amount:    -amount,
+			Reference: fmt.Sprintf("lot/%d", lot.ID),
+		}, tx)
+		if err != nil {
+			localLogger.Errorw("error posting expiration", "error", err)
+
+			return false, ErrInternal
+		}
+
+		err = s.repo.Increase(ctx, lot.UserID, lot.Program, -amount, tx)
+		if err != nil {
+			localLogger.Errorw("error decreasing balance", "error", err)
+
+			return false, ErrInternal
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		localLogger.Errorw("error committing transaction", "error", err)
+
+		return false, ErrInternal
+	}
+
+	if remaining > 0 {
+		localLogger.Infow("points expired", "amount", amount)
+	}
+
+	return remaining > 0, nil
 }
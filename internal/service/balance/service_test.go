@@ -0,0 +1,186 @@
+package balance_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/test"
+)
+
+func TestService(t *testing.T) {
+	log.InitTestLogger(t)
+
+	t.Run("oldest points are spent and expired first", testPointsExpiration)
+	t.Run("manual adjustment", testAdjustment)
+	t.Run("programs have separate balances", testPrograms)
+	t.Run("corrections don't drift from credited points", testCorrectionRounding)
+}
+
+func testPointsExpiration(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	// lots of both services are spent and expired together
+	services := test.NewBalanceServices(
+		&balance.Options{PointsTTL: time.Millisecond, ExpiringSoonWindow: 2 * time.Hour},
+		&balance.Options{PointsTTL: time.Hour, ExpiringSoonWindow: 2 * time.Hour},
+	)
+	shortLived, longLived := services[0], services[1]
+
+	const userID = "user"
+
+	require.NoError(t, shortLived.CreditAccrual(ctx, userID, balance.DefaultProgram, test.NewOrderNumber(), 100, nil))
+	require.NoError(t, longLived.CreditAccrual(ctx, userID, balance.DefaultProgram, test.NewOrderNumber(), 50, nil))
+
+	// spent from the lot expiring first
+	require.NoError(t, longLived.Withdraw(ctx, userID, balance.DefaultProgram, test.NewOrderNumber(), 30, ""))
+
+	time.Sleep(5 * time.Millisecond)
+
+	err := longLived.Withdraw(ctx, userID, balance.DefaultProgram, test.NewOrderNumber(), 60, "")
+	assert.ErrorIs(t, err, balance.ErrNotEnoughBalance, "expired points can't be spent before they are swept")
+
+	expired, err := longLived.ExpirePoints(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	b, err := longLived.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), b[0].Current)
+	assert.Equal(t, int64(30), b[0].Withdrawn, "expired points are not withdrawn")
+	assert.Equal(t, int64(50), b[0].ExpiringSoon)
+
+	expired, err = longLived.ExpirePoints(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired, "lot is expired only once")
+}
+
+func testAdjustment(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	service := test.NewBalanceService(&balance.Options{})
+
+	const userID = "user"
+	const operatorID = "operator"
+
+	_, err := service.Adjust(ctx, userID, balance.DefaultProgram, operatorID, 0, "goodwill")
+	assert.ErrorIs(t, err, balance.ErrInvalidAdjustmentAmount)
+
+	_, err = service.Adjust(ctx, userID, balance.DefaultProgram, operatorID, 100, " ")
+	assert.ErrorIs(t, err, balance.ErrEmptyAdjustmentReason)
+
+	_, err = service.Adjust(ctx, userID, balance.DefaultProgram, operatorID, -1, "mistake")
+	assert.ErrorIs(t, err, balance.ErrNotEnoughBalance, "balance can't go negative")
+
+	credit, err := service.Adjust(ctx, userID, balance.DefaultProgram, operatorID, 100, "goodwill")
+	require.NoError(t, err)
+
+	_, err = service.Adjust(ctx, userID, balance.DefaultProgram, operatorID, -101, "mistake")
+	assert.ErrorIs(t, err, balance.ErrNotEnoughBalance)
+
+	debit, err := service.Adjust(ctx, userID, balance.DefaultProgram, operatorID, -40, "mistake")
+	require.NoError(t, err)
+
+	b, err := service.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), b[0].Current)
+	assert.Equal(t, int64(0), b[0].Withdrawn, "adjustments are not withdrawals")
+
+	list, err := service.Adjustments(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, []int64{debit.ID, credit.ID}, []int64{list[0].ID, list[1].ID})
+	assert.Equal(t, operatorID, list[0].OperatorID)
+	assert.Equal(t, "mistake", list[0].Reason)
+
+	statement, err := service.Statement(ctx, userID, &balance.StatementFilter{})
+	require.NoError(t, err)
+	require.Len(t, statement.Entries, 2)
+	assert.Equal(t, balance.PostingManualAdjustment, statement.Entries[0].Kind)
+	assert.Equal(t, "mistake", statement.Entries[0].Description)
+	assert.Equal(t, int64(60), statement.Entries[0].Balance)
+}
+
+func testPrograms(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	service := test.NewBalanceService(&balance.Options{
+		// one point is worth two rubles
+		Programs: []*balance.Program{{ID: "premium", Rate: 200}},
+	})
+
+	const userID = "user"
+
+	require.NoError(t, service.CreditAccrual(ctx, userID, balance.DefaultProgram, test.NewOrderNumber(), 1000, nil))
+	require.NoError(t, service.CreditAccrual(ctx, userID, "premium", test.NewOrderNumber(), 1000, nil))
+	assert.ErrorIs(t, service.CreditAccrual(ctx, userID, "unknown", test.NewOrderNumber(), 1000, nil), balance.ErrUnknownProgram)
+
+	err := service.Withdraw(ctx, userID, "premium", test.NewOrderNumber(), 600, "")
+	assert.ErrorIs(t, err, balance.ErrNotEnoughBalance, "points of other programs can't be redeemed")
+
+	err = service.Withdraw(ctx, userID, "unknown", test.NewOrderNumber(), 100, "")
+	assert.ErrorIs(t, err, balance.ErrUnknownProgram)
+
+	number := test.NewOrderNumber()
+	require.NoError(t, service.Withdraw(ctx, userID, "premium", number, 300, ""))
+
+	b, err := service.Get(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, b, 2)
+
+	assert.Equal(t, balance.DefaultProgram, b[0].Program)
+	assert.Equal(t, int64(1000), b[0].Current)
+	assert.Equal(t, int64(0), b[0].Withdrawn)
+
+	assert.Equal(t, "premium", b[1].Program)
+	assert.Equal(t, int64(200), b[1].Current)
+	assert.Equal(t, int64(300), b[1].Withdrawn)
+	assert.Equal(t, int64(400), b[1].Value)
+
+	statement, err := service.Statement(ctx, userID, &balance.StatementFilter{Program: "premium"})
+	require.NoError(t, err)
+	require.Len(t, statement.Entries, 2)
+	assert.Equal(t, int64(200), statement.Entries[0].Balance, "running balance is kept per program")
+
+	require.NoError(t, service.CancelWithdrawal(ctx, userID, number, "order cancelled"))
+
+	b, err = service.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), b[1].Current, "withdrawal is refunded to its program")
+	assert.Equal(t, int64(1000), b[0].Current)
+}
+
+func testCorrectionRounding(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	service := test.NewBalanceService(&balance.Options{
+		// one point is worth three rubles, so points are rounded
+		Programs: []*balance.Program{{ID: "premium", Rate: 300}},
+	})
+
+	const userID = "user"
+
+	number := test.NewOrderNumber()
+	require.NoError(t, service.CreditAccrual(ctx, userID, "premium", number, 200, nil))
+	require.NoError(t, service.CorrectAccrual(ctx, userID, "premium", number+"/1", 200, 400, nil))
+
+	b, err := service.Get(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, b, 2)
+	assert.Equal(t, "premium", b[1].Program)
+	assert.Equal(t, int64(133), b[1].Current, "same as if 400 was credited at once")
+
+	require.NoError(t, service.CorrectAccrual(ctx, userID, "premium", number+"/2", 400, 0, nil))
+
+	b, err = service.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), b[1].Current, "revoked accrual takes back all its points")
+}
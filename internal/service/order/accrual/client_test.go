@@ -0,0 +1,91 @@
+package accrual
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/test"
+)
+
+func TestGRPCClient(t *testing.T) {
+	log.InitTestLogger(t)
+
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	processed := test.NewOrderNumber()
+	unregistered := test.NewOrderNumber()
+	limited := test.NewOrderNumber()
+
+	client := newTestGRPCClient(t, func(stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		if method != grpcGetOrderMethod {
+			return status.Error(codes.Unimplemented, method)
+		}
+
+		request := new(grpcOrderRequest)
+		err := stream.RecvMsg(request)
+		if err != nil {
+			return err
+		}
+
+		switch request.Order {
+		case processed:
+			return stream.SendMsg(&accrualResponse{Status: string(statusProcessed), Accrual: 10093})
+		case limited:
+			stream.SetTrailer(metadata.Pairs("retry-after", "3"))
+
+			return status.Error(codes.ResourceExhausted, "No more than 5 requests per second allowed")
+		default:
+			return status.Error(codes.NotFound, "not registered")
+		}
+	})
+
+	state, err := client.Get(ctx, processed)
+	require.NoError(t, err)
+	assert.Equal(t, &order.AccrualState{Status: order.StatusProcessed, Accrual: 10093}, state)
+
+	state, err = client.Get(ctx, unregistered)
+	require.NoError(t, err)
+	assert.Equal(t, order.StatusNew, state.Status)
+
+	_, err = client.Get(ctx, limited)
+	var limitErr *order.AccrualRateLimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, &order.AccrualRateLimitError{RetryAfter: 3 * time.Second, Requests: 5, Period: time.Second}, limitErr)
+}
+
+func newTestGRPCClient(t *testing.T, handler func(stream grpc.ServerStream) error) order.AccrualClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(jsonCodec{}),
+		grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			return handler(stream)
+		}),
+	)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	client, err := NewGRPCClient(listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return client
+}
@@ -0,0 +1,100 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+// grpcGetOrderMethod is a unary call, that takes grpcOrderRequest and returns accrualResponse.
+// Messages are encoded with jsonCodec, so the accrual system has to accept the application/grpc+json content type
+const grpcGetOrderMethod = "/accrual.Accrual/GetOrder"
+
+type grpcOrderRequest struct {
+	Order string `json:"order"`
+}
+
+type grpcClient struct {
+	conn   *grpc.ClientConn
+	logger *zap.SugaredLogger
+}
+
+// NewGRPCClient of the accrual system at host:port. Unregistered order is reported with NotFound code,
+// rate limiting - with ResourceExhausted code, the same message as in HTTP API and retry-after trailer
+func NewGRPCClient(address string) (order.AccrualClient, error) {
+	conn, err := grpc.NewClient(
+		address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cant create grpc client: %w", err)
+	}
+
+	return &grpcClient{
+		conn:   conn,
+		logger: log.Logger().Named("accrualGRPCClient"),
+	}, nil
+}
+
+func (c *grpcClient) Get(ctx context.Context, number string) (*order.AccrualState, error) {
+	wrapped := c.logger.WithLazy("number", number)
+	payload := new(accrualResponse)
+	trailer := metadata.MD{}
+
+	wrapped.Debug("making call")
+	err := c.conn.Invoke(ctx, grpcGetOrderMethod, &grpcOrderRequest{Order: number}, payload, grpc.Trailer(&trailer))
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return &order.AccrualState{Status: order.StatusNew}, nil
+		case codes.ResourceExhausted:
+			retryAfter := ""
+			if values := trailer.Get("retry-after"); len(values) > 0 {
+				retryAfter = values[0]
+			}
+
+			return nil, rateLimitError(retryAfter, status.Convert(err).Message(), wrapped)
+		default:
+			return nil, fmt.Errorf("cant make a call: %w", err)
+		}
+	}
+
+	wrapped.Debug("got response")
+
+	return payload.state()
+}
+
+func (c *grpcClient) Close() error {
+	err := c.conn.Close()
+	if err != nil {
+		return fmt.Errorf("cant close grpc connection: %w", err)
+	}
+
+	return nil
+}
+
+// jsonCodec spares the accrual system and us from sharing generated protobuf code for a single call
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
@@ -0,0 +1,62 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+type httpClient struct {
+	client *resty.Client
+	logger *zap.SugaredLogger
+}
+
+// NewHTTPClient requests GET /api/orders/{number} of the accrual system at the base url
+func NewHTTPClient(address string) order.AccrualClient {
+	return &httpClient{
+		client: resty.New().SetBaseURL(address),
+		logger: log.Logger().Named("accrualHTTPClient"),
+	}
+}
+
+func (c *httpClient) Get(ctx context.Context, number string) (*order.AccrualState, error) {
+	wrapped := c.logger.WithLazy("number", number)
+	payload := new(accrualResponse)
+
+	wrapped.Debug("making request")
+	response, err := c.client.R().
+		SetContext(ctx).
+		SetPathParam("number", number).
+		SetResult(payload).
+		Get("/api/orders/{number}")
+
+	if err != nil {
+		return nil, fmt.Errorf("cant make a request: %w", err)
+	}
+
+	wrapped.Debug("got response")
+
+	if response.StatusCode() == http.StatusTooManyRequests {
+		return nil, rateLimitError(response.Header().Get("Retry-After"), response.String(), wrapped)
+	}
+
+	if response.StatusCode() == http.StatusNoContent {
+		return &order.AccrualState{Status: order.StatusNew}, nil
+	}
+
+	if response.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", response.StatusCode())
+	}
+
+	return payload.state()
+}
+
+func (c *httpClient) Close() error {
+	return nil
+}
@@ -2,13 +2,34 @@ package accrual
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
 )
 
+// accrualResponse of the accrual system. Accrual is computed there, so it is rounded to kopecks instead of being rejected
 type accrualResponse struct {
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string        `json:"order,omitempty"`
+	Status  string        `json:"status"`
+	Accrual money.Rounded `json:"accrual"`
+}
+
+func (r *accrualResponse) state() (*order.AccrualState, error) {
+	s, err := statusFromString(r.Status)
+	if err != nil {
+		return nil, fmt.Errorf("cant parse status from response: %w", err)
+	}
+
+	return &order.AccrualState{
+		Status:  s.orderStatus(),
+		Accrual: int64(r.Accrual),
+	}, nil
 }
 
 func statusFromString(status string) (accrualStatus, error) {
@@ -48,3 +69,57 @@ func (s accrualStatus) orderStatus() order.Status {
 		return order.StatusNew
 	}
 }
+
+var rateLimitMessage = regexp.MustCompile(`^No more than (\d+) requests per (second|minute|hour) allowed$`)
+
+// rateLimitError from the Retry-After value in seconds and the message of the accrual system, defaults are used for unparsable parts
+func rateLimitError(retryAfter string, message string, logger *zap.SugaredLogger) *order.AccrualRateLimitError {
+	limitErr := &order.AccrualRateLimitError{
+		RetryAfter: time.Minute,
+		Requests:   1,
+		Period:     time.Minute,
+	}
+
+	retryAfterSeconds, err := strconv.Atoi(retryAfter)
+	if err != nil {
+		logger.Warnw("cant parse Retry-After, using default value", "retryAfter", retryAfter)
+	} else {
+		limitErr.RetryAfter = time.Duration(retryAfterSeconds) * time.Second
+	}
+
+	message = strings.TrimSpace(message)
+	matches := rateLimitMessage.FindStringSubmatch(message)
+	if len(matches) != 3 {
+		logger.Errorw("cant parse rate limiting message, using default values", "message", message, "matches", matches)
+
+		return limitErr
+	}
+
+	requests, err := strconv.Atoi(matches[1])
+	if err != nil {
+		logger.Errorw("cant parse number of requests, using default value", "number", matches[1])
+	} else {
+		limitErr.Requests = requests
+	}
+
+	switch matches[2] {
+	case "second":
+		limitErr.Period = time.Second
+	case "minute":
+		limitErr.Period = time.Minute
+	case "hour":
+		limitErr.Period = time.Hour
+	}
+
+	return limitErr
+}
+
+// ParseStatus of an order in the accrual system
+func ParseStatus(status string) (order.Status, error) {
+	s, err := statusFromString(status)
+	if err != nil {
+		return "", err
+	}
+
+	return s.orderStatus(), nil
+}
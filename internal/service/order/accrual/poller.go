@@ -5,330 +5,436 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"net/http"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
-	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/pool"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
-type poller struct {
-	pool             *pool.Pool
-	limiter          *rate.Limiter
-	tuningMutex      *sync.Mutex
-	client           *resty.Client
-	timeout          time.Duration
-	maxAttempts      int
-	maxRetryWaitTime time.Duration
-	taskList         *taskList
-	logger           *zap.SugaredLogger
+// claimBatchSize caps the number of jobs claimed at once, so a single instance doesn't lease the whole queue
+const claimBatchSize = 100
+
+type Options struct {
+	// Timeout of a request, and of the rate limiter wait before it
+	Timeout          time.Duration
+	MaxRetries       int
+	MaxRetryWaitTime time.Duration
+	// PollInterval is how often the job queue is checked for due jobs
+	PollInterval time.Duration
 }
 
-type task struct {
-	number      string
-	knownStatus order.Status
-	attempts    int
-	resultChan  chan<- order.AccrualResult
+type poller struct {
+	pool         *pool.Pool
+	limiter      *rate.Limiter
+	tuningMutex  *sync.Mutex
+	blockedUntil time.Time
+	client       order.AccrualClient
+	jobs         order.AccrualJobRepository
+	options      *Options
+	inFlight     *inFlightList
+	results      chan order.AccrualResult
+	wake         chan struct{}
+	done         chan struct{}
+	wg           *sync.WaitGroup
+	logger       *zap.SugaredLogger
 }
 
-type taskList struct {
+type inFlightList struct {
 	sync.Mutex
-	tasks map[string]*task
+	numbers map[string]struct{}
+}
+
+// add returns false if the number is already in flight
+func (l *inFlightList) add(number string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, exists := l.numbers[number]; exists {
+		return false
+	}
+
+	l.numbers[number] = struct{}{}
+
+	return true
 }
 
-func (t *taskList) deleteSingle(number string) {
-	t.Lock()
-	defer t.Unlock()
+func (l *inFlightList) deleteSingle(number string) {
+	l.Lock()
+	defer l.Unlock()
 
-	delete(t.tasks, number)
+	delete(l.numbers, number)
 }
 
-func NewPoller(accrualSystemAddress string, timeout time.Duration, maxRetries int, maxRetryWaitTime time.Duration) (order.AccrualPoller, error) {
+// NewPoller of the accrual system behind the client. Client is not closed with the poller
+func NewPoller(jobs order.AccrualJobRepository, client order.AccrualClient, options *Options) (order.AccrualPoller, error) {
+	if options == nil {
+		return nil, errors.New("no options provided")
+	}
+
 	p, err := pool.NewPool(nil)
 	if err != nil {
 		return nil, fmt.Errorf("cant create a new pool: %w", err)
 	}
 
-	return &poller{
-		pool:             p,
-		limiter:          rate.NewLimiter(rate.Inf, 1), // no limit by default
-		tuningMutex:      &sync.Mutex{},
-		client:           resty.New().SetTimeout(timeout).SetBaseURL(accrualSystemAddress),
-		timeout:          timeout,
-		maxAttempts:      maxRetries,
-		maxRetryWaitTime: maxRetryWaitTime,
-		taskList: &taskList{
-			tasks: make(map[string]*task),
+	pl := &poller{
+		pool:        p,
+		limiter:     rate.NewLimiter(rate.Inf, 1), // no limit by default
+		tuningMutex: &sync.Mutex{},
+		client:      client,
+		jobs:        jobs,
+		options:     options,
+		inFlight: &inFlightList{
+			numbers: make(map[string]struct{}),
 		},
-		logger: log.Logger().Named("accrualPoller"),
-	}, nil
+		results: make(chan order.AccrualResult),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		wg:      &sync.WaitGroup{},
+		logger:  log.Logger().Named("accrualPoller"),
+	}
+
+	pl.wg.Add(1)
+	go pl.run()
+
+	return pl, nil
 }
 
-func (p *poller) Enqueue(number string, currentStatus order.Status) (<-chan order.AccrualResult, error) {
-	p.taskList.Lock()
-	defer p.taskList.Unlock()
+func (p *poller) Enqueue(ctx context.Context, number string, currentStatus order.Status, tx transaction.Transaction) error {
+	err := p.jobs.Add(ctx, number, currentStatus, tx)
+	if err != nil {
+		if errors.Is(err, order.ErrAlreadyEnqueued) {
+			return fmt.Errorf("order %s already enqueued: %w", number, err)
+		}
+
+		return fmt.Errorf("cant add job: %w", err)
+	}
+
+	// don't wait for the next tick to pick up a new job. If the transaction is not committed yet, it is picked up on the next tick
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
 
-	if _, exists := p.taskList.tasks[number]; exists {
-		return nil, fmt.Errorf("order %s already enqueued", number)
+func (p *poller) Complete(ctx context.Context, job *order.AccrualJob, tx transaction.Transaction) error {
+	err := p.jobs.Delete(ctx, job, tx)
+	if err != nil {
+		return fmt.Errorf("cant delete job: %w", err)
 	}
 
-	result := make(chan order.AccrualResult, 1)
+	return nil
+}
+
+func (p *poller) Results() <-chan order.AccrualResult {
+	return p.results
+}
+
+func (p *poller) Check(ctx context.Context, number string) (*order.AccrualResult, error) {
+	if blockedFor := p.blockedFor(); blockedFor > 0 {
+		return nil, fmt.Errorf("requests are blocked for %v", blockedFor)
+	}
 
-	task := &task{
-		number:      number,
-		knownStatus: currentStatus,
-		resultChan:  result,
-		attempts:    0,
+	err := p.limiter.Wait(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rate limiter wait error: %w", err)
 	}
 
-	// enqueue may take a while if all workers are busy
-	err := p.enqueue(task)
+	state, err := p.request(ctx, number)
 	if err != nil {
-		return nil, fmt.Errorf("cant submit to task to pool: %w", err)
+		return nil, fmt.Errorf("error making request to accrual service: %w", err)
 	}
 
-	p.taskList.tasks[number] = task
+	result := &order.AccrualResult{
+		Number: number,
+		Status: state.Status,
+	}
+	if result.Status == order.StatusProcessed {
+		result.Accrual = &state.Accrual
+	} else if !result.Status.IsFinal() {
+		result.Accrual = provisionalAccrual(state.Accrual)
+	}
 
 	return result, nil
 }
 
 func (p *poller) Close() error {
+	close(p.done)
+	p.wg.Wait()
+
 	err := p.pool.Close()
 	if err != nil {
 		return fmt.Errorf("cant close the pool: %w", err)
 	}
 
-	p.taskList.Lock()
-	defer p.taskList.Unlock()
-
-	for _, task := range p.taskList.tasks {
-		close(task.resultChan)
-	}
+	close(p.results)
 
 	return nil
 }
 
-func (p *poller) enqueue(task *task) error {
-	return p.pool.Submit(func() {
-		p.processTask(task)
-	})
+func (p *poller) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		p.claimAndSubmit()
+
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
 }
 
-func (p *poller) processTask(task *task) {
-	// we need an actual deadline for wait, because if we start waiting while requests are blocked, we will wait forever
-	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+func (p *poller) claimAndSubmit() {
+	limit := min(p.pool.Free(), claimBatchSize)
+	if limit <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.options.Timeout)
 	defer cancel()
 
-	p.logger.Debugw("waiting to make a request", "number", task.number)
-	err := p.limiter.Wait(ctx)
+	jobs, err := p.jobs.Claim(ctx, limit, p.leaseDuration())
 	if err != nil {
-		if strings.Contains(err.Error(), "would exceed context deadline") || ctx.Err() != nil {
-			p.logger.Debugw("too much to wait to make a request, try this task later", "number", task.number, "error", err)
-		} else {
-			p.logger.Errorw("rate limiter wait error", "number", task.number, "error", err)
-		}
+		p.logger.Errorw("cant claim jobs", "error", err)
 
-		p.retryLaterOrCloseTask(task)
 		return
 	}
 
-	// task attempts should not be largely affected by rate limiting
-	task.attempts++
+	for _, job := range jobs {
+		if !p.inFlight.add(job.Number) {
+			// lease has passed, but the job is still processed
+			continue
+		}
 
-	receivedStatus, amount, err := p.makeRequest(task.number)
+		p.wg.Add(1)
+		err = p.pool.Submit(func() {
+			defer p.wg.Done()
+			defer p.inFlight.deleteSingle(job.Number)
 
-	var isCompleted bool
-	if err == nil {
-		isCompleted = p.notifyAboutChanges(task, receivedStatus, amount)
-	} else {
-		p.logger.Errorw("error making request to accrual service", "number", task.number, "error", err)
-		isCompleted = false
-	}
+			p.processJob(job)
+		})
+		if err != nil {
+			p.wg.Done()
+			p.inFlight.deleteSingle(job.Number)
 
-	if isCompleted {
-		close(task.resultChan)
-		p.taskList.deleteSingle(task.number)
-	} else {
-		p.retryLaterOrCloseTask(task)
+			p.logger.Errorw("cant submit job to pool, it will be claimed again after lease", "number", job.Number, "error", err)
+		}
 	}
 }
 
-func (p *poller) makeRequest(number string) (accrualStatus, int64, error) {
-	wrapped := p.logger.WithLazy("number", number)
-	payload := new(accrualResponse)
+// leaseDuration should be enough for the rate limiter wait, the request itself and applying the result
+func (p *poller) leaseDuration() time.Duration {
+	return 3 * p.options.Timeout
+}
+
+func (p *poller) processJob(job *order.AccrualJob) {
+	if blockedFor := p.blockedFor(); blockedFor > 0 {
+		p.logger.Debugw("requests are blocked, try this job later", "number", job.Number, "blockedFor", blockedFor)
+
+		p.reschedule(job, blockedFor, "")
+		return
+	}
 
-	wrapped.Debug("making request")
-	response, err := p.client.R().
-		SetPathParam("number", number).
-		SetResult(payload).
-		Get("/api/orders/{number}")
+	// we need an actual deadline for wait, because if we start waiting while requests are blocked, we will wait forever
+	ctx, cancel := context.WithTimeout(context.Background(), p.options.Timeout)
+	defer cancel()
 
+	p.logger.Debugw("waiting to make a request", "number", job.Number)
+	err := p.limiter.Wait(ctx)
 	if err != nil {
-		return "", 0, fmt.Errorf("cant make p request: %w", err)
+		if strings.Contains(err.Error(), "would exceed context deadline") || ctx.Err() != nil {
+			p.logger.Debugw("too much to wait to make a request, try this job later", "number", job.Number, "error", err)
+		} else {
+			p.logger.Errorw("rate limiter wait error", "number", job.Number, "error", err)
+		}
+
+		p.retryLaterOrFail(job, "")
+		return
 	}
 
-	wrapped.Debug("got response")
+	// job attempts should not be largely affected by rate limiting
+	job.Attempts++
+
+	// the wait may have taken most of the timeout, the request gets its own
+	requestCtx, requestCancel := context.WithTimeout(context.Background(), p.options.Timeout)
+	defer requestCancel()
 
-	if response.StatusCode() == http.StatusTooManyRequests {
-		p.tuneRateLimiting(response)
+	state, err := p.request(requestCtx, job.Number)
+	if err != nil {
+		p.logger.Errorw("error making request to accrual service", "number", job.Number, "error", err)
 
-		return "", 0, fmt.Errorf("rate limited")
+		p.retryLaterOrFail(job, err.Error())
+		return
 	}
 
-	if response.StatusCode() == http.StatusNoContent {
-		return "", 0, nil
+	result, isCompleted := p.resultFor(job, state)
+
+	if isCompleted {
+		p.notifyCompleted(job, *result)
+
+		return
 	}
 
-	if response.StatusCode() != http.StatusOK {
-		return "", 0, fmt.Errorf("unexpected status code: %d", response.StatusCode())
+	if result != nil && !p.notify(*result) {
+		// poller is closing, job will be claimed again after its lease
+		return
 	}
 
-	s, err := statusFromString(payload.Status)
-	if err != nil {
-		return "", 0, fmt.Errorf("cant parse status from response: %w", err)
+	p.retryLaterOrFail(job, "")
+}
+
+func (p *poller) request(ctx context.Context, number string) (*order.AccrualState, error) {
+	state, err := p.client.Get(ctx, number)
+
+	var limitErr *order.AccrualRateLimitError
+	if errors.As(err, &limitErr) {
+		p.tuneRateLimiting(limitErr)
 	}
 
-	return s, money.FloatToInt(payload.Accrual), nil
+	return state, err
 }
 
-func (p *poller) tuneRateLimiting(response *resty.Response) {
+func (p *poller) tuneRateLimiting(limitErr *order.AccrualRateLimitError) {
 	p.tuningMutex.Lock()
 	defer p.tuningMutex.Unlock()
 
-	retryAfter, numberOfRequests, period := p.parseRateLimitedResponse(response)
-	p.logger.Infow("tuning rate limiting", "retryAfter", retryAfter, "numberOfRequests", numberOfRequests, "period", period)
+	p.logger.Infow("tuning rate limiting", "retryAfter", limitErr.RetryAfter, "numberOfRequests", limitErr.Requests, "period", limitErr.Period)
 
 	// block new requests until rate limitation has passed
-	// https://github.com/golang/go/issues/18763
-	p.limiter.SetLimit(math.SmallestNonzeroFloat64)
-	p.logger.Infow("all new requests are blocked temporary", "whenSeconds", retryAfter)
+	p.blockedUntil = time.Now().Add(limitErr.RetryAfter)
+	p.logger.Infow("all new requests are blocked temporary", "for", limitErr.RetryAfter)
 
-	// tune limit according to response
-	time.AfterFunc(time.Duration(retryAfter)*time.Second, func() {
-		p.logger.Info("new rate limited installed")
-		p.limiter.SetLimit(rate.Limit(numberOfRequests / period))
-	})
+	// tune limit according to response, it will be applied after the block
+	p.limiter.SetLimit(rate.Limit(float64(limitErr.Requests) / limitErr.Period.Seconds()))
 
 	// limit number of goroutines
-	p.pool.Tune(numberOfRequests)
+	p.pool.Tune(limitErr.Requests)
 }
 
-func (p *poller) parseRateLimitedResponse(response *resty.Response) (int, int, int) {
-	retryAfterHeader := response.Header().Get("Retry-After")
-	retryAfterSeconds, err := strconv.Atoi(retryAfterHeader)
-	if err != nil {
-		p.logger.Warnw("cant parse Retry-After header, using default value", "header", retryAfterHeader)
-
-		retryAfterSeconds = 60
-	}
-
-	defaultNumberOfRequests := 1
-	defaultPeriod := 60
-
-	body := strings.TrimSpace(response.String())
-	matches := regexp.MustCompile(`^No more than (\d+) requests per (second|minute|hour) allowed$`).FindStringSubmatch(body)
-	if len(matches) != 3 {
-		p.logger.Errorw("cant parse rate limiting response, using default values", "body", body, "matches", matches)
+func (p *poller) blockedFor() time.Duration {
+	p.tuningMutex.Lock()
+	defer p.tuningMutex.Unlock()
 
-		return retryAfterSeconds, defaultNumberOfRequests, defaultPeriod
-	}
+	return time.Until(p.blockedUntil)
+}
 
-	numberOfRequests, err := strconv.Atoi(matches[1])
-	if err != nil {
-		p.logger.Errorw("cant parse number of requests, using default value", "number", matches[1])
+func (p *poller) retryLaterOrFail(job *order.AccrualJob, lastError string) {
+	if job.Attempts > p.options.MaxRetries {
+		p.notifyCompleted(job, order.AccrualResult{
+			Number: job.Number,
+			Err:    errors.New("max attempts exceeded"),
+		})
 
-		numberOfRequests = defaultNumberOfRequests
+		return
 	}
 
-	period := defaultPeriod
+	after := max(p.calcRetryPeriod(job.Attempts), p.blockedFor())
 
-	switch matches[2] {
-	case "second":
-		period = 1
-	case "minute":
-		period = 60
-	case "hour":
-		period = 60 * 60
-	default:
-		p.logger.Errorw("cant parse rate limiting period, using default values", "period", matches[2])
-	}
-
-	return numberOfRequests, period, numberOfRequests
+	p.reschedule(job, after, lastError)
 }
 
-func (p *poller) retryLaterOrCloseTask(task *task) {
-	err := p.maybeRetryLater(task)
-
-	if err != nil {
-		task.resultChan <- order.AccrualResult{
-			Err: err,
-		}
+func (p *poller) reschedule(job *order.AccrualJob, after time.Duration, lastError string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.options.Timeout)
+	defer cancel()
 
-		close(task.resultChan)
-		p.taskList.deleteSingle(task.number)
+	err := p.jobs.Reschedule(ctx, job, after, lastError)
+	if errors.Is(err, order.ErrJobNotClaimed) {
+		p.logger.Infow("job was claimed by another worker, dropping it", "number", job.Number)
+	} else if err != nil {
+		p.logger.Errorw("cant reschedule job, it will be claimed again after lease", "number", job.Number, "error", err)
 	}
 }
 
-func (p *poller) maybeRetryLater(task *task) error {
-	if task.attempts > p.maxAttempts {
-		return errors.New("max attempts exceeded")
-	}
-
-	after := p.calcRetryPeriod(task.attempts)
-
-	time.AfterFunc(after, func() {
-		err := p.enqueue(task)
-		if err != nil {
-			p.logger.Errorw("cant submit to task to pool", "number", task.number, "error", err)
-		}
-	})
+// notifyCompleted leaves the job claimed until the result is applied and the job is completed with it.
+// If the result is not applied, the job is claimed again after its lease and the result is reported again
+func (p *poller) notifyCompleted(job *order.AccrualJob, result order.AccrualResult) {
+	result.Job = job
 
-	return nil
+	if !p.notify(result) {
+		p.logger.Warnw("poller is closing, job will be claimed again after lease", "number", job.Number)
+	}
 }
 
 func (p *poller) calcRetryPeriod(attempt int) time.Duration {
 	// capped exponential backoff
-	interval := math.Min(float64(p.maxRetryWaitTime), float64(time.Second)*math.Exp2(float64(attempt)))
+	interval := math.Min(float64(p.options.MaxRetryWaitTime), float64(time.Second)*math.Exp2(float64(attempt)))
 
 	return time.Duration(interval)
 }
 
-func (p *poller) notifyAboutChanges(task *task, receivedStatus accrualStatus, receivedAccrual int64) bool {
-	orderStatus := receivedStatus.orderStatus()
+// resultFor returns a result to notify about (nil if nothing has changed) and whether the job is completed
+func (p *poller) resultFor(job *order.AccrualJob, state *order.AccrualState) (*order.AccrualResult, bool) {
+	orderStatus := state.Status
+	receivedAccrual := state.Accrual
 
 	if orderStatus == order.StatusProcessed {
-		task.resultChan <- order.AccrualResult{
+		return &order.AccrualResult{
+			Number:  job.Number,
 			Status:  orderStatus,
 			Accrual: &receivedAccrual,
-		}
-
-		return true
+		}, true
 	}
 
 	if orderStatus.IsFinal() {
-		task.resultChan <- order.AccrualResult{
+		return &order.AccrualResult{
+			Number: job.Number,
 			Status: orderStatus,
-		}
+		}, true
+	}
 
-		return true
+	provisional := provisionalAccrual(receivedAccrual)
+
+	if orderStatus != job.KnownStatus || !sameAccrual(provisional, job.KnownAccrual) {
+		job.KnownStatus = orderStatus
+		job.KnownAccrual = provisional
+
+		return &order.AccrualResult{
+			Number:  job.Number,
+			Status:  orderStatus,
+			Accrual: provisional,
+		}, false
 	}
 
-	if orderStatus != task.knownStatus {
-		task.knownStatus = orderStatus
-		task.resultChan <- order.AccrualResult{
-			Status: orderStatus,
-		}
+	return nil, false
+}
+
+// provisionalAccrual of an order, that is still processing. Accrual system omits accrual until it is known
+func provisionalAccrual(received int64) *int64 {
+	if received <= 0 {
+		return nil
 	}
 
-	return false
+	return &received
+}
+
+func sameAccrual(a *int64, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// notify returns false if poller was closed before anyone received the result
+func (p *poller) notify(result order.AccrualResult) bool {
+	select {
+	case p.results <- result:
+		return true
+	case <-p.done:
+		return false
+	}
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/order/jobs"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/test"
 )
@@ -21,12 +22,16 @@ func TestPoller(t *testing.T) {
 	log.InitTestLogger(t)
 
 	t.Run("success", testPollerSuccess)
+	t.Run("provisional accrual", testPollerProvisionalAccrual)
 	t.Run("service says that order invalid", testPollerServiceSaysOrderInvalid)
 	t.Run("service not responding", testPollerServiceNotResponding)
 	t.Run("order is never registered", testPollerOrderIsNeverRegistered)
 	t.Run("order status is never changed", testPollerOrderStatusIsNeverChanged)
 	t.Run("order already enqueued", testPollerOrderAlreadyEnqueued)
 	t.Run("rate limiting", testPollerRateLimiting)
+	t.Run("attempts survive restart", testPollerAttemptsSurviveRestart)
+	t.Run("pollers share queue", testPollersShareQueue)
+	t.Run("result is reported again until completed", testPollerReportsUntilCompleted)
 }
 
 func testPollerSuccess(t *testing.T) {
@@ -53,7 +58,7 @@ func testPollerSuccess(t *testing.T) {
 		default:
 			return ctx.JSON(accrualResponse{
 				Status:  string(statusProcessed),
-				Accrual: 100.93,
+				Accrual: 10093,
 			})
 		}
 	}))
@@ -62,7 +67,7 @@ func testPollerSuccess(t *testing.T) {
 	p := newTestPoller(t, server)
 	defer p.Close()
 
-	resultChan, err := p.Enqueue(test.NewOrderNumber(), order.StatusNew)
+	err := p.Enqueue(ctx, test.NewOrderNumber(), order.StatusNew, nil)
 	require.NoError(t, err)
 
 	expectedResultsSequence := map[int]order.AccrualResult{
@@ -80,7 +85,7 @@ func testPollerSuccess(t *testing.T) {
 
 	for {
 		select {
-		case result, ok := <-resultChan:
+		case result, ok := <-p.Results():
 			if !ok {
 				log.Logger().Debug("result chan closed")
 
@@ -103,6 +108,10 @@ func testPollerSuccess(t *testing.T) {
 				require.NotNil(t, result.Accrual)
 				assert.Equal(t, *expected.Accrual, *result.Accrual)
 			}
+
+			if result.Status.IsFinal() {
+				return
+			}
 		case <-ctx.Done():
 			log.Logger().Errorw("ctx done", "error", ctx.Err())
 			t.FailNow()
@@ -110,6 +119,59 @@ func testPollerSuccess(t *testing.T) {
 	}
 }
 
+func testPollerProvisionalAccrual(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	endpointCallCount := new(atomic.Int32)
+	server := httptest.NewServer(adaptor.FiberHandler(func(ctx *fiber.Ctx) error {
+		endpointCallCount.Add(1)
+
+		switch endpointCallCount.Load() {
+		case 1:
+			return ctx.JSON(accrualResponse{
+				Status: string(statusProcessing),
+			})
+		case 2, 3:
+			return ctx.JSON(accrualResponse{
+				Status:  string(statusProcessing),
+				Accrual: 5000,
+			})
+		default:
+			return ctx.JSON(accrualResponse{
+				Status:  string(statusProcessed),
+				Accrual: 6000,
+			})
+		}
+	}))
+	defer server.Close()
+
+	p := newTestPoller(t, server)
+	defer p.Close()
+
+	err := p.Enqueue(ctx, test.NewOrderNumber(), order.StatusNew, nil)
+	require.NoError(t, err)
+
+	expectedResults := []order.AccrualResult{
+		{Status: order.StatusProcessing},
+		// change of provisional accrual is a change too
+		{Status: order.StatusProcessing, Accrual: test.Int64Pointer(5000)},
+		{Status: order.StatusProcessed, Accrual: test.Int64Pointer(6000)},
+	}
+
+	for _, expected := range expectedResults {
+		select {
+		case result, ok := <-p.Results():
+			require.True(t, ok)
+			require.NoError(t, result.Err)
+			assert.Equal(t, expected.Status, result.Status)
+			assert.Equal(t, expected.Accrual, result.Accrual)
+		case <-ctx.Done():
+			require.FailNow(t, "ctx done", ctx.Err())
+		}
+	}
+}
+
 func testPollerServiceSaysOrderInvalid(t *testing.T) {
 	ctx, cancel := test.Context(t)
 	defer cancel()
@@ -142,7 +204,7 @@ func testPollerServiceSaysOrderInvalid(t *testing.T) {
 	p := newTestPoller(t, server)
 	defer p.Close()
 
-	resultChan, err := p.Enqueue(test.NewOrderNumber(), order.StatusNew)
+	err := p.Enqueue(ctx, test.NewOrderNumber(), order.StatusNew, nil)
 	require.NoError(t, err)
 
 	expectedResultsSequence := map[int]order.AccrualResult{
@@ -159,7 +221,7 @@ func testPollerServiceSaysOrderInvalid(t *testing.T) {
 
 	for {
 		select {
-		case result, ok := <-resultChan:
+		case result, ok := <-p.Results():
 			if !ok {
 				log.Logger().Debug("result chan closed")
 
@@ -182,6 +244,10 @@ func testPollerServiceSaysOrderInvalid(t *testing.T) {
 				require.NotNil(t, result.Accrual)
 				assert.Equal(t, *expected.Accrual, *result.Accrual)
 			}
+
+			if result.Status.IsFinal() {
+				return
+			}
 		case <-ctx.Done():
 			log.Logger().Errorw("ctx done", "error", ctx.Err())
 			t.FailNow()
@@ -207,12 +273,12 @@ func testPollerServiceNotResponding(t *testing.T) {
 	p := newTestPoller(t, server)
 	defer p.Close()
 
-	resultChan, err := p.Enqueue(test.NewOrderNumber(), order.StatusNew)
+	err := p.Enqueue(ctx, test.NewOrderNumber(), order.StatusNew, nil)
 	require.NoError(t, err)
 
 	for {
 		select {
-		case result, ok := <-resultChan:
+		case result, ok := <-p.Results():
 			if !ok {
 				log.Logger().Debug("result chan closed")
 
@@ -221,6 +287,8 @@ func testPollerServiceNotResponding(t *testing.T) {
 
 			require.Error(t, result.Err)
 			require.Equal(t, "max attempts exceeded", result.Err.Error())
+
+			return
 		case <-ctx.Done():
 			log.Logger().Errorw("ctx done", "error", ctx.Err())
 			t.FailNow()
@@ -241,12 +309,12 @@ func testPollerOrderIsNeverRegistered(t *testing.T) {
 	p := newTestPoller(t, server)
 	defer p.Close()
 
-	resultChan, err := p.Enqueue(test.NewOrderNumber(), order.StatusNew)
+	err := p.Enqueue(ctx, test.NewOrderNumber(), order.StatusNew, nil)
 	require.NoError(t, err)
 
 	for {
 		select {
-		case result, ok := <-resultChan:
+		case result, ok := <-p.Results():
 			if !ok {
 				log.Logger().Debug("result chan closed")
 
@@ -255,6 +323,8 @@ func testPollerOrderIsNeverRegistered(t *testing.T) {
 
 			require.Error(t, result.Err)
 			require.Equal(t, "max attempts exceeded", result.Err.Error())
+
+			return
 		case <-ctx.Done():
 			log.Logger().Errorw("ctx done", "error", ctx.Err())
 			t.FailNow()
@@ -276,12 +346,12 @@ func testPollerOrderStatusIsNeverChanged(t *testing.T) {
 	p := newTestPoller(t, server)
 	defer p.Close()
 
-	resultChan, err := p.Enqueue(test.NewOrderNumber(), order.StatusProcessing)
+	err := p.Enqueue(ctx, test.NewOrderNumber(), order.StatusProcessing, nil)
 	require.NoError(t, err)
 
 	for {
 		select {
-		case result, ok := <-resultChan:
+		case result, ok := <-p.Results():
 			if !ok {
 				log.Logger().Debug("result chan closed")
 
@@ -290,6 +360,8 @@ func testPollerOrderStatusIsNeverChanged(t *testing.T) {
 
 			require.Error(t, result.Err)
 			require.Equal(t, "max attempts exceeded", result.Err.Error())
+
+			return
 		case <-ctx.Done():
 			log.Logger().Errorw("ctx done", "error", ctx.Err())
 			t.FailNow()
@@ -309,12 +381,15 @@ func testPollerOrderAlreadyEnqueued(t *testing.T) {
 	p := newTestPoller(t, server)
 	defer p.Close()
 
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
 	number := test.NewOrderNumber()
 
-	_, err := p.Enqueue(number, order.StatusProcessing)
+	err := p.Enqueue(ctx, number, order.StatusProcessing, nil)
 	require.NoError(t, err)
 
-	_, err = p.Enqueue(number, order.StatusProcessing)
+	err = p.Enqueue(ctx, number, order.StatusProcessing, nil)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "already enqueued")
 }
@@ -363,12 +438,12 @@ func testPollerRateLimiting(t *testing.T) {
 	p := newTestPoller(t, server)
 	defer p.Close()
 
-	resultChan, err := p.Enqueue(test.NewOrderNumber(), order.StatusProcessing)
+	err := p.Enqueue(ctx, test.NewOrderNumber(), order.StatusProcessing, nil)
 	require.NoError(t, err)
 
 	for {
 		select {
-		case result, ok := <-resultChan:
+		case result, ok := <-p.Results():
 			if !ok {
 				log.Logger().Debug("result chan closed")
 
@@ -377,15 +452,188 @@ func testPollerRateLimiting(t *testing.T) {
 
 			require.NoError(t, result.Err)
 
+			if result.Status.IsFinal() {
+				return
+			}
+		case <-ctx.Done():
+			log.Logger().Errorw("ctx done", "error", ctx.Err())
+			t.FailNow()
+		}
+	}
+}
+
+func testPollerAttemptsSurviveRestart(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	endpointCallCount := new(atomic.Int32)
+	server := httptest.NewServer(adaptor.FiberHandler(func(ctx *fiber.Ctx) error {
+		endpointCallCount.Add(1)
+
+		// pretend that this order is unknown on every request
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}))
+	defer server.Close()
+
+	repo := jobs.NewMemoryRepository()
+
+	first := newTestPollerWithRepo(t, server, repo)
+
+	err := first.Enqueue(ctx, test.NewOrderNumber(), order.StatusNew, nil)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return endpointCallCount.Load() >= 3
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, first.Close())
+
+	second := newTestPollerWithRepo(t, server, repo)
+	defer second.Close()
+
+	select {
+	case result := <-second.Results():
+		require.Error(t, result.Err)
+		require.Equal(t, "max attempts exceeded", result.Err.Error())
+	case <-ctx.Done():
+		log.Logger().Errorw("ctx done", "error", ctx.Err())
+		t.FailNow()
+	}
+
+	// max retries + the first attempt, as if there was no restart
+	assert.Equal(t, int32(6), endpointCallCount.Load())
+}
+
+func testPollersShareQueue(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	server := httptest.NewServer(adaptor.FiberHandler(func(ctx *fiber.Ctx) error {
+		return ctx.JSON(accrualResponse{
+			Status:  string(statusProcessed),
+			Accrual: 1,
+		})
+	}))
+	defer server.Close()
+
+	repo := jobs.NewMemoryRepository()
+
+	first := newTestPollerWithRepo(t, server, repo)
+	defer first.Close()
+
+	second := newTestPollerWithRepo(t, server, repo)
+	defer second.Close()
+
+	const numberOfOrders = 50
+
+	for i := 0; i < numberOfOrders; i++ {
+		poller := first
+		if i%2 == 0 {
+			poller = second
+		}
+
+		err := poller.Enqueue(ctx, test.NewOrderNumber(), order.StatusNew, nil)
+		require.NoError(t, err)
+	}
+
+	reported := make(map[string]int)
+
+	for len(reported) < numberOfOrders {
+		select {
+		case result := <-first.Results():
+			require.NoError(t, result.Err)
+			require.NoError(t, first.Complete(ctx, result.Job, nil))
+			reported[result.Number]++
+		case result := <-second.Results():
+			require.NoError(t, result.Err)
+			require.NoError(t, second.Complete(ctx, result.Job, nil))
+			reported[result.Number]++
+		case <-ctx.Done():
+			log.Logger().Errorw("ctx done", "error", ctx.Err())
+			t.FailNow()
+		}
+	}
+
+	// give pollers a chance to report something twice, longer than the lease
+	time.Sleep(200 * time.Millisecond)
+
+	select {
+	case result := <-first.Results():
+		assert.Failf(t, "order reported twice", "number %s", result.Number)
+	case result := <-second.Results():
+		assert.Failf(t, "order reported twice", "number %s", result.Number)
+	default:
+	}
+
+	for number, count := range reported {
+		assert.Equal(t, 1, count, number)
+	}
+}
+
+func testPollerReportsUntilCompleted(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	server := httptest.NewServer(adaptor.FiberHandler(func(ctx *fiber.Ctx) error {
+		return ctx.JSON(accrualResponse{
+			Status:  string(statusProcessed),
+			Accrual: 1,
+		})
+	}))
+	defer server.Close()
+
+	p := newTestPoller(t, server)
+	defer p.Close()
+
+	number := test.NewOrderNumber()
+	err := p.Enqueue(ctx, number, order.StatusNew, nil)
+	require.NoError(t, err)
+
+	receive := func() order.AccrualResult {
+		select {
+		case result := <-p.Results():
+			return result
 		case <-ctx.Done():
 			log.Logger().Errorw("ctx done", "error", ctx.Err())
 			t.FailNow()
 		}
+
+		return order.AccrualResult{}
+	}
+
+	// the first result is not applied, as if the transaction failed
+	first := receive()
+	require.NotNil(t, first.Job)
+	assert.Equal(t, number, first.Number)
+
+	second := receive()
+	require.NotNil(t, second.Job)
+	assert.Equal(t, number, second.Number)
+	assert.ErrorIs(t, p.Complete(ctx, first.Job, nil), order.ErrJobNotClaimed, "stale claim can't complete the job")
+	require.NoError(t, p.Complete(ctx, second.Job, nil))
+
+	select {
+	case result := <-p.Results():
+		assert.Failf(t, "completed order reported again", "number %s", result.Number)
+	case <-time.After(200 * time.Millisecond):
 	}
 }
 
 func newTestPoller(t *testing.T, server *httptest.Server) order.AccrualPoller {
-	p, err := NewPoller(server.URL, 50*time.Millisecond, 5, time.Millisecond)
+	return newTestPollerWithRepo(t, server, jobs.NewMemoryRepository())
+}
+
+func newTestPollerWithRepo(t *testing.T, server *httptest.Server, repo order.AccrualJobRepository) order.AccrualPoller {
+	return newTestPollerWithClient(t, NewHTTPClient(server.URL), repo)
+}
+
+func newTestPollerWithClient(t *testing.T, client order.AccrualClient, repo order.AccrualJobRepository) order.AccrualPoller {
+	p, err := NewPoller(repo, client, &Options{
+		Timeout:          50 * time.Millisecond,
+		MaxRetries:       5,
+		MaxRetryWaitTime: time.Millisecond,
+		PollInterval:     time.Millisecond,
+	})
 	require.NoError(t, err)
 
 	return p
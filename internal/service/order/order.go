@@ -3,8 +3,12 @@ package order
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/pagination"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
 type Status string
@@ -23,7 +27,9 @@ const StatusInvalid = Status("INVALID")
 const StatusProcessed = Status("PROCESSED")
 
 type Order struct {
-	Number     string
+	Number string
+	// Program of loyalty points, that are accrued for the order
+	Program    string
 	Status     Status
 	Accrual    *int64
 	UploadedAt time.Time
@@ -32,29 +38,179 @@ type Order struct {
 var ErrAlreadyUploaded = errors.New("order already uploaded")
 var ErrUploadedByAnotherUser = errors.New("uploaded by another user")
 var ErrInvalidNumber = errors.New("invalid order number")
+var ErrUnknownProgram = errors.New("unknown loyalty program")
+var ErrOrderNotFound = errors.New("order not found")
 var ErrAlreadyProcessed = errors.New("order already processed")
+var ErrAlreadyEnqueued = errors.New("order already enqueued")
+var ErrJobNotClaimed = errors.New("job is not claimed by this worker")
+var ErrInvalidListFilter = errors.New("invalid list filter")
 var ErrInternal = errors.New("internal error")
 
+// ListFilter of user orders. Orders are sorted by upload time, newest first
+type ListFilter struct {
+	// Statuses to include, all if empty
+	Statuses []Status
+	// From and To limit upload time, inclusive and exclusive respectively. Zero means no limit
+	From time.Time
+	To   time.Time
+	// After is the cursor of the previous page, nil for the first page
+	After *pagination.Cursor
+	Limit int
+}
+
+type Page struct {
+	Orders []*Order
+	// NextCursor points to the next page, nil if this is the last one
+	NextCursor *pagination.Cursor
+}
+
 type Service interface {
-	Upload(ctx context.Context, userID string, number string) error
-	AddToProcessQueue(number, userID string, currentStatus Status) error
-	List(ctx context.Context, userID string) ([]*Order, error)
+	// Upload the order to accrue points of the program for it
+	Upload(ctx context.Context, userID string, number string, program string) error
+	List(ctx context.Context, userID string, filter *ListFilter) (*Page, error)
+	// ApplyAccrualResult pushed by the accrual system, the same way as polled results are applied.
+	// Polling goes on, so results, that were not pushed, are still applied.
+	// Record is called in the transaction applying the result, so the push is recorded only if the result is applied,
+	// its errors are returned as is
+	ApplyAccrualResult(ctx context.Context, result AccrualResult, record func(tx transaction.Transaction) error) error
+}
+
+// Correction of an order, that was processed already
+type Correction struct {
+	ID         int64
+	Number     string
+	UserID     string
+	Program    string
+	OldStatus  Status
+	OldAccrual *int64
+	NewStatus  Status
+	NewAccrual *int64
+}
+
+// Accruals of the order before and after the correction, zero if there was none
+func (c *Correction) Accruals() (int64, int64) {
+	var oldAccrual, newAccrual int64
+	if c.OldAccrual != nil {
+		oldAccrual = *c.OldAccrual
+	}
+
+	if c.NewAccrual != nil {
+		newAccrual = *c.NewAccrual
+	}
+
+	return oldAccrual, newAccrual
+}
+
+// Delta of the user balance caused by the correction
+func (c *Correction) Delta() int64 {
+	oldAccrual, newAccrual := c.Accruals()
+
+	return newAccrual - oldAccrual
+}
+
+// Owner of an order and the program, that the order accrues points of
+type Owner struct {
+	UserID  string
+	Program string
 }
 
 type Repository interface {
-	Add(ctx context.Context, userID string, number string, status Status) error
-	Update(ctx context.Context, number string, status Status, accrual *int64) error
-	GetOwner(ctx context.Context, number string) (string, bool, error)
-	List(ctx context.Context, userID string) ([]*Order, error)
+	Add(ctx context.Context, userID string, number string, program string, status Status, tx transaction.Transaction) error
+	// Update status of a not yet processed order. Returns false if order is already in a final status
+	Update(ctx context.Context, number string, status Status, accrual *int64, tx transaction.Transaction) (bool, error)
+	GetOwner(ctx context.Context, number string) (*Owner, bool, error)
+	// List up to filter.Limit user orders
+	List(ctx context.Context, userID string, filter *ListFilter) ([]*Order, error)
+	// ClaimForVerification up to limit orders processed within the window and not verified for the period.
+	// Claimed orders are not returned again until the period has passed
+	ClaimForVerification(ctx context.Context, window time.Duration, period time.Duration, limit int) ([]string, error)
+	// Correct status and accrual of a processed order and record the correction.
+	// Returns nil if the order is not processed or nothing has changed
+	Correct(ctx context.Context, number string, status Status, accrual *int64, tx transaction.Transaction) (*Correction, error)
+}
+
+// AccrualCreditor credits accrual of a processed order as a part of the transaction, that changes the order status.
+// Accrual is in rubles, creditor converts it into points of the program
+type AccrualCreditor interface {
+	HasProgram(program string) bool
+	CreditAccrual(ctx context.Context, userID string, program string, orderNumber string, sum int64, tx transaction.Transaction) error
+	// HoldAccrual of an order, that is not processed yet. Provisional accrual is nil until the accrual system reports it
+	HoldAccrual(ctx context.Context, userID string, program string, orderNumber string, provisional *int64, tx transaction.Transaction) error
+	// ReleaseAccrual held for an order, that has reached a final status
+	ReleaseAccrual(ctx context.Context, orderNumber string, tx transaction.Transaction) error
+	// CorrectAccrual credited before from the old accrual to the new one, which is less if accrual was reduced or revoked
+	CorrectAccrual(ctx context.Context, userID string, program string, reference string, oldAccrual int64, newAccrual int64, tx transaction.Transaction) error
 }
 
 type AccrualPoller interface {
 	io.Closer
-	Enqueue(number string, currentStatus Status) (<-chan AccrualResult, error)
+	// Enqueue the order as a part of the transaction, that adds it
+	Enqueue(ctx context.Context, number string, currentStatus Status, tx transaction.Transaction) error
+	// Complete the job of a final result as a part of the transaction, that applies the result.
+	// Until then the job is claimed again after its lease, so a result, that was not applied, is reported again.
+	// Returns ErrJobNotClaimed if the job was claimed again by someone else after lease has passed
+	Complete(ctx context.Context, job *AccrualJob, tx transaction.Transaction) error
+	// Results of all enqueued orders, including ones enqueued before restart. Closed when poller is closed
+	Results() <-chan AccrualResult
+	// Check the order in the accrual system right away, bypassing the queue
+	Check(ctx context.Context, number string) (*AccrualResult, error)
+}
+
+// AccrualClient is a transport to the accrual system. AccrualPoller schedules, retries and rate limits its calls
+type AccrualClient interface {
+	io.Closer
+	// Get the order state from the accrual system. Order, that is not registered there yet, is reported as new.
+	// Returns *AccrualRateLimitError if the accrual system refuses requests for a while
+	Get(ctx context.Context, number string) (*AccrualState, error)
+}
+
+type AccrualState struct {
+	Status Status
+	// Accrual of a processed order, or provisional accrual of an order, that is still processing. Zero until it is known
+	Accrual int64
+}
+
+// AccrualRateLimitError tells when and how often the accrual system can be requested again
+type AccrualRateLimitError struct {
+	RetryAfter time.Duration
+	// Requests allowed per Period
+	Requests int
+	Period   time.Duration
+}
+
+func (e *AccrualRateLimitError) Error() string {
+	return fmt.Sprintf("rate limited for %v, no more than %d requests per %v allowed", e.RetryAfter, e.Requests, e.Period)
 }
 
 type AccrualResult struct {
-	Status  Status
+	Number string
+	Status Status
+	// Accrual of a processed order, or provisional accrual of an order, that is still processing
 	Accrual *int64
 	Err     error
+	// Job to complete when a final result is applied, nil if the result is not reported from the queue or is not final
+	Job *AccrualJob
+}
+
+type AccrualJob struct {
+	Number      string
+	KnownStatus Status
+	// KnownAccrual is the last provisional accrual reported for a not yet processed order
+	KnownAccrual *int64
+	Attempts     int
+	// ClaimToken is unique for every claim, jobs can be changed only with the token of the latest claim
+	ClaimToken string
+}
+
+// AccrualJobRepository is a persistent queue of orders waiting for the accrual system
+type AccrualJobRepository interface {
+	// Add a job, returns ErrAlreadyEnqueued if there is a job for this number already
+	Add(ctx context.Context, number string, status Status, tx transaction.Transaction) error
+	// Claim up to limit due jobs. Claimed jobs are not returned again until lease has passed
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*AccrualJob, error)
+	// Reschedule job to be claimed again after the given period.
+	// Returns ErrJobNotClaimed if job was claimed again by someone else after lease has passed
+	Reschedule(ctx context.Context, job *AccrualJob, after time.Duration, lastError string) error
+	// Delete a completed job. Returns ErrJobNotClaimed if job was claimed again by someone else after lease has passed
+	Delete(ctx context.Context, job *AccrualJob, tx transaction.Transaction) error
 }
@@ -2,30 +2,40 @@ package order
 
 import (
 	"context"
+	"errors"
 
 	"go.uber.org/zap"
 
-	"github.com/kuvalkin/gophermart-loyalty/internal/support/event"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/pagination"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
-func NewService(repo Repository, poller AccrualPoller) Service {
-	return &service{
-		repo:   repo,
-		poller: poller,
-		logger: log.Logger().Named("orderService"),
+func NewService(repo Repository, poller AccrualPoller, creditor AccrualCreditor, txProvider transaction.Provider) Service {
+	s := &service{
+		repo:       repo,
+		poller:     poller,
+		creditor:   creditor,
+		txProvider: txProvider,
+		logger:     log.Logger().Named("orderService"),
 	}
+
+	go s.listenAccrualResults()
+
+	return s
 }
 
 type service struct {
-	repo   Repository
-	poller AccrualPoller
-	logger *zap.SugaredLogger
+	repo       Repository
+	poller     AccrualPoller
+	creditor   AccrualCreditor
+	txProvider transaction.Provider
+	logger     *zap.SugaredLogger
 }
 
-func (s *service) Upload(ctx context.Context, userID string, number string) error {
-	localLogger := s.logger.WithLazy("userID", userID, "number", number)
+func (s *service) Upload(ctx context.Context, userID string, number string, program string) error {
+	localLogger := s.logger.WithLazy("userID", userID, "number", number, "program", program)
 
 	err := order.ValidateNumber(number)
 	if err != nil {
@@ -34,7 +44,13 @@ func (s *service) Upload(ctx context.Context, userID string, number string) erro
 		return ErrInvalidNumber
 	}
 
-	ownerID, found, err := s.repo.GetOwner(ctx, number)
+	if !s.creditor.HasProgram(program) {
+		localLogger.Debugw("unknown program")
+
+		return ErrUnknownProgram
+	}
+
+	owner, found, err := s.repo.GetOwner(ctx, number)
 	if err != nil {
 		localLogger.Errorw("can't get owner", "error", err)
 
@@ -42,84 +58,252 @@ func (s *service) Upload(ctx context.Context, userID string, number string) erro
 	}
 
 	if found {
-		if ownerID == userID {
+		if owner.UserID == userID {
 			return ErrAlreadyUploaded
 		} else {
 			return ErrUploadedByAnotherUser
 		}
 	}
 
-	err = s.repo.Add(ctx, userID, number, StatusNew)
+	// order without a job is never polled, so they are added together
+	tx, err := s.txProvider.StartTransaction(ctx)
+	if err != nil {
+		localLogger.Errorw("error starting transaction", "error", err)
+
+		return ErrInternal
+	}
+
+	defer func() {
+		err := tx.Rollback()
+		if err != nil {
+			localLogger.Errorw("error rolling back transaction", "error", err)
+		}
+	}()
+
+	err = s.repo.Add(ctx, userID, number, program, StatusNew, tx)
 	if err != nil {
 		localLogger.Errorw("can't add new order", "error", err)
 
 		return ErrInternal
 	}
 
-	err = s.AddToProcessQueue(number, userID, StatusNew)
+	err = s.creditor.HoldAccrual(ctx, userID, program, number, nil, tx)
+	if err != nil {
+		localLogger.Errorw("can't hold accrual", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.addToProcessQueue(ctx, number, StatusNew, tx)
 	if err != nil {
 		localLogger.Errorw("can't add to process queue", "error", err)
 
 		return ErrInternal
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		localLogger.Errorw("error committing transaction", "error", err)
+
+		return ErrInternal
+	}
+
 	return nil
 }
 
-func (s *service) AddToProcessQueue(number, userID string, currentStatus Status) error {
+func (s *service) addToProcessQueue(ctx context.Context, number string, currentStatus Status, tx transaction.Transaction) error {
 	if currentStatus.IsFinal() {
 		return ErrAlreadyProcessed
 	}
 
-	resultChan, err := s.poller.Enqueue(number, StatusNew)
+	err := s.poller.Enqueue(ctx, number, currentStatus, tx)
 	if err != nil {
 		s.logger.Errorw("can't add to queue", "error", err, "number", number, "currentStatus", currentStatus)
 
 		return ErrInternal
 	}
 
-	go s.listenAccrualResults(resultChan, number, userID)
-
 	return nil
 }
 
-func (s *service) listenAccrualResults(resultChan <-chan AccrualResult, number string, userID string) {
-	localLogger := s.logger.WithLazy("number", number)
+func (s *service) listenAccrualResults() {
+	for result := range s.poller.Results() {
+		// errors are logged already, failed results are applied again when the poller reports them next time
+		_ = s.applyAccrualResult(context.Background(), result, nil)
+	}
+}
+
+func (s *service) ApplyAccrualResult(ctx context.Context, result AccrualResult, record func(tx transaction.Transaction) error) error {
+	_, found, err := s.repo.GetOwner(ctx, result.Number)
+	if err != nil {
+		s.logger.Errorw("can't get owner of order", "number", result.Number, "error", err)
+
+		return ErrInternal
+	}
+
+	if !found {
+		return ErrOrderNotFound
+	}
+
+	return s.applyAccrualResult(ctx, result, record)
+}
+
+// applyAccrualResult changes the order status and credits the accrual in one transaction, record is optional
+func (s *service) applyAccrualResult(ctx context.Context, result AccrualResult, record func(tx transaction.Transaction) error) error {
+	localLogger := s.logger.WithLazy("number", result.Number)
 
-	for result := range resultChan {
-		newStatus := result.Status
+	newStatus := result.Status
 
-		if result.Err != nil {
-			localLogger.Errorw("received error from accrual queue, marking order as invalid", "error", result.Err)
+	if result.Err != nil {
+		localLogger.Errorw("received error from accrual queue, marking order as invalid", "error", result.Err)
 
-			newStatus = StatusInvalid
+		newStatus = StatusInvalid
+	}
+
+	tx, err := s.txProvider.StartTransaction(ctx)
+	if err != nil {
+		localLogger.Errorw("error starting transaction", "error", err)
+		return ErrInternal
+	}
+
+	defer func() {
+		err := tx.Rollback()
+		if err != nil {
+			localLogger.Errorw("error rolling back transaction", "error", err)
 		}
+	}()
+
+	if record != nil {
+		err = record(tx)
+		if err != nil {
+			return err
+		}
+	}
 
-		err := s.repo.Update(
-			context.Background(),
-			number,
-			newStatus,
-			result.Accrual,
-		)
+	// provisional accrual is not stored in the order, it is held in the balance instead
+	var accrual *int64
+	if newStatus == StatusProcessed {
+		accrual = result.Accrual
+	}
+
+	updated, err := s.repo.Update(ctx, result.Number, newStatus, accrual, tx)
+	if err != nil {
+		localLogger.Errorw("can't update order", "error", err)
+		return ErrInternal
+	}
+
+	if !updated {
+		// another instance or a pushed result has already applied the final result, so accrual must not be credited twice
+		localLogger.Infow("order is already processed, ignoring result", "status", newStatus)
+		return s.completeAndCommit(ctx, result, tx)
+	}
+
+	owner, found, err := s.repo.GetOwner(ctx, result.Number)
+	if err != nil {
+		localLogger.Errorw("can't get owner of order", "error", err)
+		return ErrInternal
+	}
+
+	if !found {
+		localLogger.Errorw("order not found")
+		return ErrOrderNotFound
+	}
 
+	if !newStatus.IsFinal() {
+		err = s.creditor.HoldAccrual(ctx, owner.UserID, owner.Program, result.Number, result.Accrual, tx)
+		if err != nil {
+			localLogger.Errorw("can't hold accrual", "error", err)
+			return ErrInternal
+		}
+	} else {
+		err = s.creditor.ReleaseAccrual(ctx, result.Number, tx)
 		if err != nil {
-			localLogger.Errorw("can't update order", "error", err)
-			continue
+			localLogger.Errorw("can't release accrual", "error", err)
+			return ErrInternal
 		}
+	}
 
-		if result.Status == StatusProcessed && result.Accrual != nil {
-			event.Publish("order:processed", userID, *result.Accrual)
+	if newStatus == StatusProcessed && accrual != nil {
+		err = s.creditor.CreditAccrual(ctx, owner.UserID, owner.Program, result.Number, *accrual, tx)
+		if err != nil {
+			localLogger.Errorw("can't credit accrual", "error", err)
+			return ErrInternal
 		}
 	}
+
+	return s.completeAndCommit(ctx, result, tx)
 }
 
-func (s *service) List(ctx context.Context, userID string) ([]*Order, error) {
-	list, err := s.repo.List(ctx, userID)
+// completeAndCommit deletes the job of the result together with applying it,
+// so if the transaction fails, the job is claimed again and the result is reported again
+func (s *service) completeAndCommit(ctx context.Context, result AccrualResult, tx transaction.Transaction) error {
+	localLogger := s.logger.WithLazy("number", result.Number)
+
+	if result.Job != nil {
+		err := s.poller.Complete(ctx, result.Job, tx)
+		if errors.Is(err, ErrJobNotClaimed) {
+			// the worker holding the latest claim reports the result again
+			localLogger.Infow("job was claimed again by another worker, dropping result")
+			return nil
+		} else if err != nil {
+			localLogger.Errorw("can't complete job", "error", err)
+			return ErrInternal
+		}
+	}
+
+	err := tx.Commit()
 	if err != nil {
-		s.logger.Errorw("can't list orders", "userID", userID, "error", err)
+		localLogger.Errorw("error committing transaction", "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (s *service) List(ctx context.Context, userID string, filter *ListFilter) (*Page, error) {
+	localLogger := s.logger.WithLazy("userID", userID, "filter", filter)
+
+	f := *filter
+
+	limit, err := pagination.Limit(f.Limit)
+	if err != nil {
+		localLogger.Debugw("invalid limit", "error", err)
+
+		return nil, ErrInvalidListFilter
+	}
+
+	for _, status := range f.Statuses {
+		if status != StatusNew && status != StatusProcessing && !status.IsFinal() {
+			localLogger.Debugw("invalid status", "status", status)
+
+			return nil, ErrInvalidListFilter
+		}
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		localLogger.Debugw("invalid date range")
+
+		return nil, ErrInvalidListFilter
+	}
+
+	// one extra order tells whether there is a next page
+	f.Limit = limit + 1
+
+	list, err := s.repo.List(ctx, userID, &f)
+	if err != nil {
+		localLogger.Errorw("can't list orders", "error", err)
 
 		return nil, ErrInternal
 	}
 
-	return list, nil
+	page := &Page{Orders: list}
+	if len(list) > limit {
+		page.Orders = list[:limit]
+
+		last := page.Orders[limit-1]
+		page.NextCursor = &pagination.Cursor{Time: last.UploadedAt, Key: last.Number}
+	}
+
+	return page, nil
 }
@@ -0,0 +1,372 @@
+package order_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	orderStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+	"github.com/kuvalkin/gophermart-loyalty/internal/test"
+)
+
+func TestService(t *testing.T) {
+	log.InitTestLogger(t)
+
+	t.Run("duplicate processed result is credited once", testDuplicateResultCreditedOnce)
+	t.Run("verifier corrects changed accrual", testVerifierCorrectsAccrual)
+	t.Run("provisional accrual is pending until processed", testProvisionalAccrualPending)
+	t.Run("accrual is converted into points of the order program", testProgramAccrual)
+	t.Run("pushed result is applied like a polled one", testPushedResult)
+}
+
+func testDuplicateResultCreditedOnce(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	poller := &manualPoller{results: make(chan order.AccrualResult)}
+	defer poller.Close()
+
+	balanceService := test.NewBalanceService(&balance.Options{})
+	orderService := order.NewService(orderStorage.NewInMemoryRepository(), poller, balanceService, test.NewDummyTxProvider())
+
+	const userID = "user"
+	number := test.NewOrderNumber()
+	marker := test.NewOrderNumber()
+
+	require.NoError(t, orderService.Upload(ctx, userID, number, balance.DefaultProgram))
+	require.NoError(t, orderService.Upload(ctx, userID, marker, balance.DefaultProgram))
+
+	processed := order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessed,
+		Accrual: test.Int64Pointer(100),
+		Job:     &order.AccrualJob{Number: number},
+	}
+
+	poller.results <- processed
+	poller.results <- processed
+	// results are applied one by one, so when marker is applied the duplicate is applied too
+	poller.results <- order.AccrualResult{
+		Number:  marker,
+		Status:  order.StatusProcessed,
+		Accrual: test.Int64Pointer(1),
+		Job:     &order.AccrualJob{Number: marker},
+	}
+
+	// the ignored duplicate completes its job too, otherwise it would be reported forever
+	require.Eventually(t, func() bool {
+		return poller.completed.Load() == 3
+	}, time.Second, time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		page, err := orderService.List(ctx, userID, &order.ListFilter{})
+		require.NoError(t, err)
+
+		for _, o := range page.Orders {
+			if o.Number == marker {
+				return o.Status == order.StatusProcessed
+			}
+		}
+
+		return false
+	}, time.Second, time.Millisecond)
+
+	b, err := balanceService.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(101), b[0].Current)
+}
+
+func testVerifierCorrectsAccrual(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	number := test.NewOrderNumber()
+	poller := &manualPoller{
+		results: make(chan order.AccrualResult),
+		checks: map[string]*order.AccrualResult{
+			number: {
+				Number:  number,
+				Status:  order.StatusProcessed,
+				Accrual: test.Int64Pointer(40),
+			},
+		},
+	}
+	defer poller.Close()
+
+	repo := orderStorage.NewInMemoryRepository()
+	balanceService := test.NewBalanceService(&balance.Options{})
+	orderService := order.NewService(repo, poller, balanceService, test.NewDummyTxProvider())
+
+	const userID = "user"
+	require.NoError(t, orderService.Upload(ctx, userID, number, balance.DefaultProgram))
+
+	poller.results <- order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessed,
+		Accrual: test.Int64Pointer(100),
+	}
+
+	require.Eventually(t, func() bool {
+		b, err := balanceService.Get(ctx, userID)
+		require.NoError(t, err)
+
+		return b[0].Current == 100
+	}, time.Second, time.Millisecond)
+
+	verifier := order.NewVerifier(repo, poller, balanceService, test.NewDummyTxProvider(), &order.VerifierOptions{
+		Interval:  time.Millisecond,
+		Window:    time.Hour,
+		Period:    time.Millisecond,
+		BatchSize: 10,
+		Timeout:   time.Second,
+	})
+
+	require.Eventually(t, func() bool {
+		b, err := balanceService.Get(ctx, userID)
+		require.NoError(t, err)
+
+		return b[0].Current == 40
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, verifier.Close())
+
+	page, err := orderService.List(ctx, userID, &order.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 1)
+	assert.Equal(t, int64(40), *page.Orders[0].Accrual)
+
+	b, err := balanceService.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), b[0].Current, "correction is applied once")
+}
+
+type manualPoller struct {
+	results chan order.AccrualResult
+	// checks are results of Check by order number
+	checks map[string]*order.AccrualResult
+	// completed is the number of completed jobs
+	completed atomic.Int32
+}
+
+func (p *manualPoller) Check(_ context.Context, number string) (*order.AccrualResult, error) {
+	result, ok := p.checks[number]
+	if !ok {
+		return nil, errors.New("unknown order")
+	}
+
+	return result, nil
+}
+
+func (p *manualPoller) Enqueue(_ context.Context, _ string, _ order.Status, _ transaction.Transaction) error {
+	return nil
+}
+
+func (p *manualPoller) Complete(_ context.Context, _ *order.AccrualJob, _ transaction.Transaction) error {
+	p.completed.Add(1)
+
+	return nil
+}
+
+func (p *manualPoller) Results() <-chan order.AccrualResult {
+	return p.results
+}
+
+func (p *manualPoller) Close() error {
+	close(p.results)
+
+	return nil
+}
+
+func testProvisionalAccrualPending(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	poller := &manualPoller{results: make(chan order.AccrualResult)}
+	defer poller.Close()
+
+	balanceService := test.NewBalanceService(&balance.Options{})
+	orderService := order.NewService(orderStorage.NewInMemoryRepository(), poller, balanceService, test.NewDummyTxProvider())
+
+	const userID = "user"
+	number := test.NewOrderNumber()
+
+	require.NoError(t, orderService.Upload(ctx, userID, number, balance.DefaultProgram))
+
+	poller.results <- order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessing,
+		Accrual: test.Int64Pointer(50),
+	}
+
+	require.Eventually(t, func() bool {
+		b, err := balanceService.Get(ctx, userID)
+		require.NoError(t, err)
+
+		return b[0].Pending == 50
+	}, time.Second, time.Millisecond)
+
+	b, err := balanceService.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), b[0].Current, "pending accrual is not spendable")
+
+	page, err := orderService.List(ctx, userID, &order.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 1)
+	assert.Nil(t, page.Orders[0].Accrual, "provisional accrual is not an order accrual")
+
+	poller.results <- order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessed,
+		Accrual: test.Int64Pointer(60),
+	}
+
+	require.Eventually(t, func() bool {
+		b, err := balanceService.Get(ctx, userID)
+		require.NoError(t, err)
+
+		return b[0].Current == 60
+	}, time.Second, time.Millisecond)
+
+	b, err = balanceService.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), b[0].Pending)
+}
+
+func testProgramAccrual(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	poller := &manualPoller{results: make(chan order.AccrualResult)}
+	defer poller.Close()
+
+	balanceService := test.NewBalanceService(&balance.Options{
+		// one brand point is worth half a ruble
+		Programs: []*balance.Program{{ID: "brand", Rate: 50}},
+	})
+	orderService := order.NewService(orderStorage.NewInMemoryRepository(), poller, balanceService, test.NewDummyTxProvider())
+
+	const userID = "user"
+	number := test.NewOrderNumber()
+
+	assert.ErrorIs(t, orderService.Upload(ctx, userID, number, "unknown"), order.ErrUnknownProgram)
+	require.NoError(t, orderService.Upload(ctx, userID, number, "brand"))
+
+	poller.results <- order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessed,
+		Accrual: test.Int64Pointer(10000),
+	}
+
+	require.Eventually(t, func() bool {
+		b, err := balanceService.Get(ctx, userID)
+		require.NoError(t, err)
+
+		return b[1].Current == 20000
+	}, time.Second, time.Millisecond)
+
+	b, err := balanceService.Get(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, b, 2)
+	assert.Equal(t, balance.DefaultProgram, b[0].Program)
+	assert.Equal(t, int64(0), b[0].Current)
+	assert.Equal(t, "brand", b[1].Program)
+	assert.Equal(t, int64(10000), b[1].Value)
+
+	page, err := orderService.List(ctx, userID, &order.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 1)
+	assert.Equal(t, "brand", page.Orders[0].Program)
+	assert.Equal(t, int64(10000), *page.Orders[0].Accrual, "order accrual is in rubles")
+}
+
+func testPushedResult(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	poller := &manualPoller{results: make(chan order.AccrualResult)}
+	defer poller.Close()
+
+	balanceService := test.NewBalanceService(&balance.Options{})
+	orderService := order.NewService(orderStorage.NewInMemoryRepository(), poller, balanceService, test.NewDummyTxProvider())
+
+	const userID = "user"
+	number := test.NewOrderNumber()
+
+	require.NoError(t, orderService.Upload(ctx, userID, number, balance.DefaultProgram))
+
+	err := orderService.ApplyAccrualResult(ctx, order.AccrualResult{
+		Number:  test.NewOrderNumber(),
+		Status:  order.StatusProcessed,
+		Accrual: test.Int64Pointer(100),
+	}, nil)
+	assert.ErrorIs(t, err, order.ErrOrderNotFound)
+
+	// the result is not applied, if the push can't be recorded
+	errRejected := errors.New("rejected")
+	err = orderService.ApplyAccrualResult(ctx, order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessing,
+		Accrual: test.Int64Pointer(40),
+	}, func(_ transaction.Transaction) error {
+		return errRejected
+	})
+	assert.ErrorIs(t, err, errRejected)
+
+	recorded := false
+	err = orderService.ApplyAccrualResult(ctx, order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessing,
+		Accrual: test.Int64Pointer(50),
+	}, func(_ transaction.Transaction) error {
+		recorded = true
+
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, recorded)
+
+	b, err := balanceService.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), b[0].Pending)
+
+	processed := order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessed,
+		Accrual: test.Int64Pointer(60),
+	}
+
+	require.NoError(t, orderService.ApplyAccrualResult(ctx, processed, nil))
+
+	// polling reports the same result later, it must not be credited twice
+	poller.results <- processed
+	marker := test.NewOrderNumber()
+	require.NoError(t, orderService.Upload(ctx, userID, marker, balance.DefaultProgram))
+	poller.results <- order.AccrualResult{Number: marker, Status: order.StatusInvalid}
+
+	require.Eventually(t, func() bool {
+		page, err := orderService.List(ctx, userID, &order.ListFilter{})
+		require.NoError(t, err)
+
+		for _, o := range page.Orders {
+			if o.Number == marker {
+				return o.Status == order.StatusInvalid
+			}
+		}
+
+		return false
+	}, time.Second, time.Millisecond)
+
+	b, err = balanceService.Get(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), b[0].Current)
+	assert.Equal(t, int64(0), b[0].Pending)
+}
@@ -0,0 +1,177 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+type VerifierOptions struct {
+	// Interval between checks for orders to verify
+	Interval time.Duration
+	// Window of processing time, older orders are not verified anymore
+	Window time.Duration
+	// Period between verifications of the same order
+	Period time.Duration
+	// BatchSize is the maximum number of orders verified at once
+	BatchSize int
+	Timeout   time.Duration
+}
+
+// NewVerifier periodically re-checks recently processed orders in the accrual system.
+// If accrual system has changed the accrual, the order is corrected and the balance is adjusted by the difference
+func NewVerifier(
+	repo Repository,
+	poller AccrualPoller,
+	creditor AccrualCreditor,
+	txProvider transaction.Provider,
+	options *VerifierOptions,
+) io.Closer {
+	v := &verifier{
+		repo:       repo,
+		poller:     poller,
+		creditor:   creditor,
+		txProvider: txProvider,
+		options:    options,
+		done:       make(chan struct{}),
+		wg:         &sync.WaitGroup{},
+		logger:     log.Logger().Named("accrualVerifier"),
+	}
+
+	v.wg.Add(1)
+	go v.run()
+
+	return v
+}
+
+type verifier struct {
+	repo       Repository
+	poller     AccrualPoller
+	creditor   AccrualCreditor
+	txProvider transaction.Provider
+	options    *VerifierOptions
+	done       chan struct{}
+	wg         *sync.WaitGroup
+	logger     *zap.SugaredLogger
+}
+
+func (v *verifier) Close() error {
+	close(v.done)
+	v.wg.Wait()
+
+	return nil
+}
+
+func (v *verifier) run() {
+	defer v.wg.Done()
+
+	ticker := time.NewTicker(v.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		v.verifyBatch()
+
+		select {
+		case <-v.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (v *verifier) verifyBatch() {
+	ctx, cancel := context.WithTimeout(context.Background(), v.options.Timeout)
+	defer cancel()
+
+	numbers, err := v.repo.ClaimForVerification(ctx, v.options.Window, v.options.Period, v.options.BatchSize)
+	if err != nil {
+		v.logger.Errorw("cant claim orders for verification", "error", err)
+
+		return
+	}
+
+	for _, number := range numbers {
+		select {
+		case <-v.done:
+			// the rest will be claimed again after the period
+			return
+		default:
+		}
+
+		err = v.verify(number)
+		if err != nil {
+			v.logger.Errorw("cant verify order, it will be verified after the period", "number", number, "error", err)
+		}
+	}
+}
+
+func (v *verifier) verify(number string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v.options.Timeout)
+	defer cancel()
+
+	result, err := v.poller.Check(ctx, number)
+	if err != nil {
+		return fmt.Errorf("cant check order: %w", err)
+	}
+
+	if !result.Status.IsFinal() {
+		// accrual system can't take processed order back to processing, nothing to correct
+		v.logger.Warnw("processed order is not final in accrual system", "number", number, "status", result.Status)
+
+		return nil
+	}
+
+	tx, err := v.txProvider.StartTransaction(ctx)
+	if err != nil {
+		return fmt.Errorf("cant start transaction: %w", err)
+	}
+
+	defer func() {
+		err := tx.Rollback()
+		if err != nil {
+			v.logger.Errorw("error rolling back transaction", "number", number, "error", err)
+		}
+	}()
+
+	correction, err := v.repo.Correct(ctx, number, result.Status, result.Accrual, tx)
+	if err != nil {
+		return fmt.Errorf("cant correct order: %w", err)
+	}
+
+	if correction == nil {
+		return nil
+	}
+
+	reference := fmt.Sprintf("%s/%d", number, correction.ID)
+
+	oldAccrual, newAccrual := correction.Accruals()
+
+	err = v.creditor.CorrectAccrual(ctx, correction.UserID, correction.Program, reference, oldAccrual, newAccrual, tx)
+	if err != nil {
+		return fmt.Errorf("cant correct accrual: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("cant commit transaction: %w", err)
+	}
+
+	v.logger.Infow(
+		"order corrected",
+		"number", number,
+		"userID", correction.UserID,
+		"program", correction.Program,
+		"oldStatus", correction.OldStatus,
+		"newStatus", correction.NewStatus,
+		"delta", correction.Delta(),
+	)
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package throttle
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+func NewService(repo Repository, options *Options) Service {
+	return &service{
+		repo:    repo,
+		options: options,
+		logger:  log.Logger().Named("loginThrottle"),
+	}
+}
+
+type service struct {
+	repo    Repository
+	options *Options
+	logger  *zap.SugaredLogger
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (s *service) Reserve(ctx context.Context, login string, ip string) (*Reservation, time.Duration, error) {
+	localLogger := s.logger.WithLazy("login", login, "ip", ip)
+
+	until, err := s.repo.BlockedUntil(ctx, []string{loginKey(login), ipKey(ip)})
+	if err != nil {
+		localLogger.Errorw("cant check block", "error", err)
+
+		return nil, 0, ErrInternal
+	}
+
+	if wait := time.Until(until); wait > 0 {
+		return nil, wait, nil
+	}
+
+	now := time.Now()
+	reservation := &Reservation{Login: login, IP: ip}
+
+	reservation.loginFailures, err = s.repo.RecordFailure(ctx, loginKey(login), now, now.Add(-s.options.Login.ResetAfter))
+	if err != nil {
+		localLogger.Errorw("cant reserve login attempt", "error", err)
+
+		return nil, 0, ErrInternal
+	}
+
+	reservation.ipFailures, err = s.repo.RecordFailure(ctx, ipKey(ip), now, now.Add(-s.options.IP.ResetAfter))
+	if err != nil {
+		localLogger.Errorw("cant reserve ip attempt", "error", err)
+
+		return nil, 0, ErrInternal
+	}
+
+	// parallel attempts have passed the block check together, the ones beyond the threshold are not allowed
+	var wait time.Duration
+	if reservation.loginFailures > s.options.Login.LockoutThreshold {
+		wait = max(wait, s.options.Login.LockoutDuration)
+	}
+
+	if reservation.ipFailures > s.options.IP.LockoutThreshold {
+		wait = max(wait, s.options.IP.LockoutDuration)
+	}
+
+	if wait > 0 {
+		localLogger.Debugw("attempt is beyond lockout threshold")
+
+		return nil, wait, nil
+	}
+
+	return reservation, 0, nil
+}
+
+func (s *service) Fail(ctx context.Context, reservation *Reservation) error {
+	err := s.block(ctx, loginKey(reservation.Login), reservation.loginFailures, &s.options.Login)
+	if err != nil {
+		s.logger.Errorw("cant block login", "login", reservation.Login, "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.block(ctx, ipKey(reservation.IP), reservation.ipFailures, &s.options.IP)
+	if err != nil {
+		s.logger.Errorw("cant block ip", "ip", reservation.IP, "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (s *service) block(ctx context.Context, key string, failures int, policy *Policy) error {
+	delay := policy.delay(failures)
+	if delay == 0 {
+		return nil
+	}
+
+	if failures >= policy.LockoutThreshold {
+		s.logger.Warnw("locked out", "key", key, "failures", failures, "duration", delay)
+	}
+
+	return s.repo.Block(ctx, key, time.Now().Add(delay))
+}
+
+// delay after the given number of failures
+func (p *Policy) delay(failures int) time.Duration {
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+
+	beyond := failures - p.FreeAttempts
+	if beyond <= 0 {
+		return 0
+	}
+
+	// doubling is capped, so it does not overflow
+	delay := p.BaseDelay
+	for i := 1; i < beyond && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+func (s *service) Succeed(ctx context.Context, reservation *Reservation) error {
+	err := s.repo.Reset(ctx, loginKey(reservation.Login))
+	if err != nil {
+		s.logger.Errorw("cant reset failures", "login", reservation.Login, "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.repo.Release(ctx, ipKey(reservation.IP))
+	if err != nil {
+		s.logger.Errorw("cant release ip attempt", "ip", reservation.IP, "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (s *service) Cancel(ctx context.Context, reservation *Reservation) error {
+	for _, key := range []string{loginKey(reservation.Login), ipKey(reservation.IP)} {
+		err := s.repo.Release(ctx, key)
+		if err != nil {
+			s.logger.Errorw("cant release attempt", "key", key, "error", err)
+
+			return ErrInternal
+		}
+	}
+
+	return nil
+}
+
+func (s *service) Unlock(ctx context.Context, login string) error {
+	err := s.repo.Reset(ctx, loginKey(login))
+	if err != nil {
+		s.logger.Errorw("cant unlock", "login", login, "error", err)
+
+		return ErrInternal
+	}
+
+	s.logger.Infow("unlocked", "login", login)
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrInternal = errors.New("internal error")
+
+// Policy of delays after failed attempts
+type Policy struct {
+	// FreeAttempts can fail without any delay
+	FreeAttempts int
+	// BaseDelay after the first failure beyond free attempts, it doubles with every next failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold of failures, after which attempts are locked out for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// ResetAfter of no failures the counter starts over
+	ResetAfter time.Duration
+}
+
+type Options struct {
+	// Login policy protects a single account
+	Login Policy
+	// IP policy limits guessing of many accounts from one address
+	IP Policy
+}
+
+var DefaultOptions = Options{
+	Login: Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+		ResetAfter:       24 * time.Hour,
+	},
+	IP: Policy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+		ResetAfter:       24 * time.Hour,
+	},
+}
+
+// Reservation of a login attempt. The attempt is counted as failed from the moment it is reserved
+type Reservation struct {
+	Login         string
+	IP            string
+	loginFailures int
+	ipFailures    int
+}
+
+// Service throttles login attempts by login and by client IP.
+// Attempts are reserved before the password is verified, so parallel attempts can't get past the lockout threshold
+type Service interface {
+	// Reserve an attempt. It returns how long the client has to wait before the next attempt, if the attempt is not allowed
+	Reserve(ctx context.Context, login string, ip string) (*Reservation, time.Duration, error)
+	// Fail delays the next attempts after the reserved one has failed
+	Fail(ctx context.Context, reservation *Reservation) error
+	// Succeed resets failures of the login and releases the attempt of the IP.
+	// Other failures of the IP are kept, so an attacker can't reset them with own account
+	Succeed(ctx context.Context, reservation *Reservation) error
+	// Cancel releases the reserved attempt, that is not counted as failed
+	Cancel(ctx context.Context, reservation *Reservation) error
+	// Unlock the login after lockout
+	Unlock(ctx context.Context, login string) error
+}
+
+// Repository of failure counters by key, shared by all instances
+type Repository interface {
+	// RecordFailure increments failures of the key, starting over if the last failure is older than resetBefore
+	RecordFailure(ctx context.Context, key string, now time.Time, resetBefore time.Time) (int, error)
+	// Release decrements failures of the key, if it has any
+	Release(ctx context.Context, key string) error
+	Block(ctx context.Context, key string, until time.Time) error
+	// BlockedUntil returns the latest block of the keys, zero if none are blocked
+	BlockedUntil(ctx context.Context, keys []string) (time.Time, error)
+	Reset(ctx context.Context, key string) error
+}
@@ -0,0 +1,272 @@
+package user
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type SigningAlgorithm string
+
+func (a SigningAlgorithm) String() string {
+	return string(a)
+}
+
+const SigningEdDSA = SigningAlgorithm("EdDSA")
+const SigningRS256 = SigningAlgorithm("RS256")
+
+const rsaKeyBits = 2048
+
+var ErrUnknownSigningAlgorithm = errors.New("unknown signing algorithm")
+
+type SigningKey struct {
+	// ID is published as kid
+	ID         string
+	Algorithm  SigningAlgorithm
+	PrivateKey crypto.Signer
+	// ActivatesAt is when the key starts signing tokens. It is published before, so relying parties know it in advance
+	ActivatesAt time.Time
+	// ExpiresAt is when the last token signed with the key expires, the key is not published after that
+	ExpiresAt time.Time
+}
+
+type PublicKey struct {
+	ID        string
+	Algorithm SigningAlgorithm
+	Key       crypto.PublicKey
+}
+
+// SigningKeyRepository of keys, that sign access tokens
+type SigningKeyRepository interface {
+	Add(ctx context.Context, key *SigningKey) error
+	// List keys, that are not expired before the given time
+	List(ctx context.Context, notExpiredBefore time.Time) ([]*SigningKey, error)
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+func (a SigningAlgorithm) method() (jwt.SigningMethod, error) {
+	switch a {
+	case SigningEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	case SigningRS256:
+		return jwt.SigningMethodRS256, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSigningAlgorithm, a)
+	}
+}
+
+func (a SigningAlgorithm) generate() (crypto.Signer, error) {
+	switch a {
+	case SigningEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+
+		return key, err
+	case SigningRS256:
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSigningAlgorithm, a)
+	}
+}
+
+// keyring caches signing keys. Every instance reloads keys periodically,
+// so a new key is activated only after all instances and relying parties have seen it
+type keyring struct {
+	repo    SigningKeyRepository
+	options *Options
+	mutex   sync.RWMutex
+	// keys are nil until loaded
+	keys []*SigningKey
+}
+
+// activationDelay of a new key covers reload by instances and caching of JWKS by relying parties
+func (k *keyring) activationDelay() time.Duration {
+	return 2 * k.options.KeyReloadInterval
+}
+
+// lifetime of a key from activation until the last token signed with it expires
+func (k *keyring) lifetime() time.Duration {
+	return k.options.KeyRotationPeriod + k.activationDelay() + k.options.TokenExpirationPeriod
+}
+
+// rotate adds a new key if the newest one is due to be replaced, deletes expired keys and reloads the keys
+func (k *keyring) rotate(ctx context.Context) error {
+	now := time.Now()
+
+	keys, err := k.repo.List(ctx, now)
+	if err != nil {
+		return fmt.Errorf("cant list keys: %w", err)
+	}
+
+	newest := newestKey(keys)
+
+	switch {
+	case newest == nil || k.usableKey(keys, now) == nil:
+		// nothing can sign tokens right now, so the key is activated immediately
+		err = k.addKey(ctx, now)
+	case !newest.ActivatesAt.Add(k.options.KeyRotationPeriod).After(now.Add(k.activationDelay())):
+		// next key is activated right when the newest one is rotated
+		err = k.addKey(ctx, maxTime(now.Add(k.activationDelay()), newest.ActivatesAt.Add(k.options.KeyRotationPeriod)))
+	}
+
+	if err != nil {
+		return fmt.Errorf("cant add key: %w", err)
+	}
+
+	err = k.repo.DeleteExpired(ctx, now)
+	if err != nil {
+		return fmt.Errorf("cant delete expired keys: %w", err)
+	}
+
+	return k.reload(ctx)
+}
+
+func (k *keyring) addKey(ctx context.Context, activatesAt time.Time) error {
+	privateKey, err := k.options.SigningAlgorithm.generate()
+	if err != nil {
+		return fmt.Errorf("cant generate key: %w", err)
+	}
+
+	// generation of RSA keys is slow
+	activatesAt = maxTime(activatesAt, time.Now())
+
+	return k.repo.Add(ctx, &SigningKey{
+		ID:          uuid.New().String(),
+		Algorithm:   k.options.SigningAlgorithm,
+		PrivateKey:  privateKey,
+		ActivatesAt: activatesAt,
+		ExpiresAt:   activatesAt.Add(k.lifetime()),
+	})
+}
+
+func (k *keyring) reload(ctx context.Context) error {
+	keys, err := k.repo.List(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("cant list keys: %w", err)
+	}
+
+	if keys == nil {
+		keys = make([]*SigningKey, 0)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys = keys
+
+	return nil
+}
+
+// signingKey is the newest active key, keys are rotated if none are loaded yet
+func (k *keyring) signingKey(ctx context.Context) (*SigningKey, error) {
+	k.mutex.RLock()
+	key := k.usableKey(k.keys, time.Now())
+	k.mutex.RUnlock()
+
+	if key != nil {
+		return key, nil
+	}
+
+	err := k.rotate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	key = k.usableKey(k.keys, time.Now())
+	if key == nil {
+		return nil, errors.New("no usable signing key")
+	}
+
+	return key, nil
+}
+
+// verificationKey of a token, expired keys are not accepted
+func (k *keyring) verificationKey(ctx context.Context, id string) (*SigningKey, bool, error) {
+	k.mutex.RLock()
+	loaded := k.keys != nil
+	k.mutex.RUnlock()
+
+	if !loaded {
+		err := k.reload(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	now := time.Now()
+	for _, key := range k.keys {
+		if key.ID == id && key.ExpiresAt.After(now) {
+			return key, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+func (k *keyring) publicKeys() []*PublicKey {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	now := time.Now()
+	result := make([]*PublicKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if key.ExpiresAt.After(now) {
+			result = append(result, &PublicKey{
+				ID:        key.ID,
+				Algorithm: key.Algorithm,
+				Key:       key.PrivateKey.Public(),
+			})
+		}
+	}
+
+	return result
+}
+
+// usableKey is the newest active key, that signs tokens expiring before the key does
+func (k *keyring) usableKey(keys []*SigningKey, now time.Time) *SigningKey {
+	var result *SigningKey
+	for _, key := range keys {
+		if key.ActivatesAt.After(now) || !key.ExpiresAt.After(now.Add(k.options.TokenExpirationPeriod)) {
+			continue
+		}
+
+		if result == nil || key.ActivatesAt.After(result.ActivatesAt) {
+			result = key
+		}
+	}
+
+	return result
+}
+
+func newestKey(keys []*SigningKey) *SigningKey {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return slices.MaxFunc(keys, func(a, b *SigningKey) int {
+		return a.ActivatesAt.Compare(b.ActivatesAt)
+	})
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,285 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/totp"
+)
+
+const recoveryCodesCount = 10
+
+// maxChallengeAttempts limit guessing of a code, the user has to enter the password again after them
+const maxChallengeAttempts = 5
+
+// totpSkew of one step in both directions tolerates clock drift of authenticator apps
+const totpSkew = 1
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+func (s *service) challenge(ctx context.Context, userID string) (*MFAChallenge, error) {
+	token, err := generateToken()
+	if err != nil {
+		s.logger.Errorw("failed to generate MFA challenge", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	err = s.mfa.AddChallenge(ctx, userID, hashToken(token), time.Now().Add(s.options.MFAChallengeExpirationPeriod))
+	if err != nil {
+		s.logger.Errorw("failed to save MFA challenge", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	return &MFAChallenge{Token: token, ExpiresIn: s.options.MFAChallengeExpirationPeriod}, nil
+}
+
+func (s *service) CompleteMFA(ctx context.Context, challenge string, code string) (*Tokens, error) {
+	hash := hashToken(challenge)
+
+	c, err := s.mfa.AttemptChallenge(ctx, hash)
+	if err != nil {
+		if errors.Is(err, ErrChallengeNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+
+		s.logger.Errorw("failed to attempt MFA challenge", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if c.Expired || c.Attempts > maxChallengeAttempts {
+		s.deleteChallenge(ctx, hash)
+
+		return nil, ErrInvalidChallenge
+	}
+
+	mfa, err := s.mfa.Get(ctx, c.UserID)
+	if err != nil {
+		s.logger.Errorw("failed to get MFA", "userID", c.UserID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	// disabled after the challenge was issued
+	if mfa == nil || !mfa.Enabled {
+		s.deleteChallenge(ctx, hash)
+
+		return nil, ErrInvalidChallenge
+	}
+
+	ok, err := s.verifyCode(ctx, c.UserID, mfa, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	s.deleteChallenge(ctx, hash)
+
+	return s.startSession(ctx, c.UserID)
+}
+
+// deleteChallenge, that can't be completed anymore. Failure is not fatal, the challenge expires anyway
+func (s *service) deleteChallenge(ctx context.Context, hash string) {
+	err := s.mfa.DeleteChallenge(ctx, hash)
+	if err != nil {
+		s.logger.Errorw("failed to delete MFA challenge", "error", err)
+	}
+}
+
+func (s *service) EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to get MFA", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if mfa != nil && mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	u, err := s.get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		s.logger.Errorw("failed to generate TOTP secret", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	err = s.mfa.Enroll(ctx, userID, secret)
+	if err != nil {
+		s.logger.Errorw("failed to enroll TOTP", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(s.options.MFAIssuer, u.Login, secret),
+	}, nil
+}
+
+func (s *service) ConfirmTOTP(ctx context.Context, userID string, code string) ([]string, error) {
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to get MFA", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if mfa == nil {
+		return nil, ErrMFANotEnrolled
+	}
+
+	if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	// recovery codes are not issued yet, so only the authenticator app proves the secret is provisioned
+	ok, err := s.verifyTOTP(ctx, userID, mfa.Secret, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for range recoveryCodesCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			s.logger.Errorw("failed to generate recovery code", "error", err)
+
+			return nil, ErrInternal
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	err = s.mfa.Enable(ctx, userID, hashes)
+	if err != nil {
+		s.logger.Errorw("failed to enable MFA", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	s.logger.Infow("MFA enabled", "userID", userID)
+
+	return codes, nil
+}
+
+func (s *service) DisableTOTP(ctx context.Context, userID string, code string) error {
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to get MFA", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	if mfa == nil || !mfa.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	ok, err := s.verifyCode(ctx, userID, mfa, code)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	err = s.mfa.Disable(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to disable MFA", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	s.logger.Infow("MFA disabled", "userID", userID)
+
+	return nil
+}
+
+// verifyCode, that is either a TOTP or a recovery code
+func (s *service) verifyCode(ctx context.Context, userID string, mfa *MFA, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totp.Digits {
+		return s.verifyTOTP(ctx, userID, mfa.Secret, code)
+	}
+
+	used, err := s.mfa.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		s.logger.Errorw("failed to use recovery code", "userID", userID, "error", err)
+
+		return false, ErrInternal
+	}
+
+	if used {
+		s.logger.Infow("recovery code used", "userID", userID)
+	}
+
+	return used, nil
+}
+
+func (s *service) verifyTOTP(ctx context.Context, userID string, secret string, code string) (bool, error) {
+	step, ok, err := totp.Validate(secret, code, time.Now(), totpSkew)
+	if err != nil {
+		s.logger.Errorw("failed to validate TOTP", "userID", userID, "error", err)
+
+		return false, ErrInternal
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	fresh, err := s.mfa.UseStep(ctx, userID, step)
+	if err != nil {
+		s.logger.Errorw("failed to use TOTP step", "userID", userID, "error", err)
+
+		return false, ErrInternal
+	}
+
+	return fresh, nil
+}
+
+// generateRecoveryCode like abcde-fgh23, that is easy to type
+func generateRecoveryCode() (string, error) {
+	// 50 bits of the 56 are used
+	buf := make([]byte, 7)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	code := recoveryCodeEncoding.EncodeToString(buf)[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,167 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func (s *service) ChangePassword(ctx context.Context, userID string, oldPassword string, newPassword string) (*Tokens, error) {
+	u, err := s.get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, savedHash, found, err := s.repo.Find(ctx, u.Login)
+	if err != nil {
+		s.logger.Errorw("failed to fetch password hash", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if !found {
+		return nil, ErrUserNotFound
+	}
+
+	ok, _, err := s.options.PasswordHasher.Verify(oldPassword, savedHash)
+	if err != nil {
+		s.logger.Errorw("failed to verify password", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+
+	err = s.validatePassword(newPassword, u.Login)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.setPassword(ctx, userID, newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startSession(ctx, userID)
+}
+
+func (s *service) RequestPasswordReset(ctx context.Context, login string) error {
+	userID, _, found, err := s.find(ctx, login)
+	if err != nil {
+		s.logger.Errorw("failed to find user", "login", login, "error", err)
+
+		return ErrInternal
+	}
+
+	// the token is made for unknown logins too, so they can't be told apart by the response time
+	token, err := generateToken()
+	if err != nil {
+		s.logger.Errorw("failed to generate reset token", "error", err)
+
+		return ErrInternal
+	}
+
+	tokenHash := hashToken(token)
+
+	if !found {
+		s.logger.Debugw("password reset of unknown user", "login", login)
+
+		return nil
+	}
+
+	u, err := s.get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.resets.Add(ctx, userID, tokenHash, time.Now().Add(s.options.ResetTokenExpirationPeriod))
+	if err != nil {
+		s.logger.Errorw("failed to save reset token", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	// the stored login is sent, the requested one can differ in case
+	err = s.notifier.SendPasswordReset(ctx, userID, u.Login, token)
+	if err != nil {
+		s.logger.Errorw("failed to send reset token", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (s *service) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	hash := hashToken(token)
+
+	// the password is checked before the token is used up
+	reset, err := s.resets.Get(ctx, hash)
+	if err != nil {
+		if errors.Is(err, ErrResetTokenNotFound) {
+			return ErrInvalidResetToken
+		}
+
+		s.logger.Errorw("failed to get reset token", "error", err)
+
+		return ErrInternal
+	}
+
+	if reset.Used || reset.Expired {
+		return ErrInvalidResetToken
+	}
+
+	u, err := s.get(ctx, reset.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = s.validatePassword(newPassword, u.Login)
+	if err != nil {
+		return err
+	}
+
+	reset, err = s.resets.Use(ctx, hash)
+	if err != nil {
+		s.logger.Errorw("failed to use reset token", "error", err)
+
+		return ErrInternal
+	}
+
+	// the token could be used concurrently
+	if reset.Used {
+		return ErrInvalidResetToken
+	}
+
+	return s.setPassword(ctx, reset.UserID, newPassword)
+}
+
+// setPassword and revoke all sessions, because they could be started by whoever knew the old password
+func (s *service) setPassword(ctx context.Context, userID string, password string) error {
+	hash, err := s.options.PasswordHasher.Hash(password)
+	if err != nil {
+		s.logger.Errorw("password hashing failed", "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.repo.UpdatePasswordHash(ctx, userID, hash)
+	if err != nil {
+		s.logger.Errorw("failed to update password hash", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	err = s.sessions.RevokeAll(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to revoke sessions", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	s.logger.Infow("password changed", "userID", userID)
+
+	return nil
+}
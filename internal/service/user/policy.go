@@ -0,0 +1,78 @@
+package user
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	FieldLogin    = "login"
+	FieldPassword = "password"
+)
+
+// Violation of the credentials policy
+type Violation struct {
+	// Field is FieldLogin or FieldPassword
+	Field   string
+	Code    string
+	Message string
+}
+
+// PolicyError lists every violation, so a user can fix them all at once.
+// It matches ErrInvalidLogin and ErrInvalidPassword depending on the violated fields
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e *PolicyError) Is(target error) bool {
+	for _, v := range e.Violations {
+		if v.Field == FieldLogin && errors.Is(target, ErrInvalidLogin) {
+			return true
+		}
+
+		if v.Field == FieldPassword && errors.Is(target, ErrInvalidPassword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type CredentialsPolicy interface {
+	// NormalizeLogin, so different spellings of the same login can't be registered
+	NormalizeLogin(login string) string
+	// ValidateLogin after normalization
+	ValidateLogin(login string) []Violation
+	// ValidatePassword of the user with the normalized login
+	ValidatePassword(password string, login string) []Violation
+}
+
+func (s *service) validateCredentials(login string, password string) error {
+	violations := s.options.Policy.ValidateLogin(login)
+	violations = append(violations, s.options.Policy.ValidatePassword(password, login)...)
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+func (s *service) validatePassword(password string, login string) error {
+	violations := s.options.Policy.ValidatePassword(password, login)
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
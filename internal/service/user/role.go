@@ -0,0 +1,24 @@
+package user
+
+import "errors"
+
+var ErrInvalidRole = errors.New("invalid role")
+
+type Role string
+
+const (
+	RoleUser Role = "user"
+	// RoleSupport can look up users and their data and unlock accounts
+	RoleSupport Role = "support"
+	// RoleAdmin can do everything support can, cancel withdrawals and manage roles
+	RoleAdmin Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+// NewKeyRotator periodically rotates signing keys and reloads keys added by other instances
+func NewKeyRotator(service Service, interval time.Duration, timeout time.Duration) io.Closer {
+	r := &rotator{
+		service:  service,
+		interval: interval,
+		timeout:  timeout,
+		done:     make(chan struct{}),
+		wg:       &sync.WaitGroup{},
+		logger:   log.Logger().Named("keyRotator"),
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r
+}
+
+type rotator struct {
+	service  Service
+	interval time.Duration
+	timeout  time.Duration
+	done     chan struct{}
+	wg       *sync.WaitGroup
+	logger   *zap.SugaredLogger
+}
+
+func (r *rotator) Close() error {
+	close(r.done)
+	r.wg.Wait()
+
+	return nil
+}
+
+func (r *rotator) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.rotate()
+
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *rotator) rotate() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	err := r.service.RotateKeys(ctx)
+	if err != nil {
+		r.logger.Errorw("key rotation failed", "error", err)
+	}
+}
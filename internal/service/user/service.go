@@ -2,8 +2,6 @@ package user
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -14,38 +12,77 @@ import (
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 )
 
-var signingMethod = jwt.SigningMethodHS256
-
-func NewService(repo Repository, options *Options) (Service, error) {
+func NewService(
+	repo Repository,
+	sessions SessionRepository,
+	keys SigningKeyRepository,
+	resets ResetTokenRepository,
+	mfa MFARepository,
+	notifier Notifier,
+	options *Options,
+) (Service, error) {
 	if options == nil {
 		return nil, errors.New("no options provided")
 	}
 
+	_, err := options.SigningAlgorithm.method()
+	if err != nil {
+		return nil, err
+	}
+
+	// unknown logins are verified against it, so they take as long as the known ones
+	dummyHash, err := options.PasswordHasher.Hash("gophermart-dummy-password")
+	if err != nil {
+		return nil, err
+	}
+
 	return &service{
-		repo:    repo,
-		options: options,
-		logger:  log.Logger().Named("userService"),
+		repo:      repo,
+		sessions:  sessions,
+		keys:      &keyring{repo: keys, options: options},
+		resets:    resets,
+		mfa:       mfa,
+		notifier:  notifier,
+		options:   options,
+		dummyHash: dummyHash,
+		logger:    log.Logger().Named("userService"),
 	}, nil
 }
 
 type service struct {
-	repo    Repository
-	options *Options
-	logger  *zap.SugaredLogger
+	repo      Repository
+	sessions  SessionRepository
+	keys      *keyring
+	resets    ResetTokenRepository
+	mfa       MFARepository
+	notifier  Notifier
+	options   *Options
+	dummyHash string
+	logger    *zap.SugaredLogger
+}
+
+type accessClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+	Role      Role   `json:"role"`
 }
 
 func (s *service) Register(ctx context.Context, login string, password string) error {
-	if login == "" {
-		return ErrInvalidLogin
-	}
+	login = s.options.Policy.NormalizeLogin(login)
 
-	if len(password) < s.options.MinPasswordLength {
-		return ErrInvalidPassword
+	err := s.validateCredentials(login, password)
+	if err != nil {
+		return err
 	}
 
-	hash := s.hashPassword(password)
+	hash, err := s.options.PasswordHasher.Hash(password)
+	if err != nil {
+		s.logger.Errorw("password hashing failed", "error", err)
+
+		return ErrInternal
+	}
 
-	err := s.repo.Add(ctx, login, hash)
+	err = s.repo.Add(ctx, login, hash)
 	if err != nil {
 		if errors.Is(err, ErrLoginNotUnique) {
 			return ErrLoginTaken
@@ -59,83 +96,398 @@ func (s *service) Register(ctx context.Context, login string, password string) e
 	return nil
 }
 
-func (s *service) Login(ctx context.Context, login string, password string) (string, error) {
-	id, savedHash, found, err := s.repo.Find(ctx, login)
+func (s *service) Login(ctx context.Context, login string, password string) (*LoginResult, error) {
+	id, savedHash, found, err := s.find(ctx, login)
 	if err != nil {
 		s.logger.Errorw("failed to fetch password hash", "login", login, "error", err)
 
-		return "", ErrInternal
+		return nil, ErrInternal
 	}
 
 	if !found {
-		return "", ErrInvalidPair
+		_, _, _ = s.options.PasswordHasher.Verify(password, s.dummyHash)
+
+		return nil, ErrInvalidPair
+	}
+
+	ok, needsRehash, err := s.options.PasswordHasher.Verify(password, savedHash)
+	if err != nil {
+		s.logger.Errorw("failed to verify password", "login", login, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if !ok {
+		return nil, ErrInvalidPair
+	}
+
+	if needsRehash {
+		s.rehashPassword(ctx, id, password)
+	}
+
+	mfa, err := s.mfa.Get(ctx, id)
+	if err != nil {
+		s.logger.Errorw("failed to get MFA", "login", login, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if mfa != nil && mfa.Enabled {
+		challenge, err := s.challenge(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		return &LoginResult{MFAChallenge: challenge}, nil
+	}
+
+	tokens, err := s.startSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{Tokens: tokens}, nil
+}
+
+func (s *service) startSession(ctx context.Context, userID string) (*Tokens, error) {
+	sessionID, err := s.sessions.Create(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to create session", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	tokens, err := s.issueTokens(ctx, userID, sessionID)
+	if err != nil {
+		s.logger.Errorw("failed to issue tokens", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	return tokens, nil
+}
+
+func (s *service) NormalizeLogin(login string) string {
+	return s.options.Policy.NormalizeLogin(login)
+}
+
+// find the user by the normalized login. Users registered before normalization are found by the login as is
+func (s *service) find(ctx context.Context, login string) (string, string, bool, error) {
+	normalized := s.options.Policy.NormalizeLogin(login)
+
+	id, hash, found, err := s.repo.Find(ctx, normalized)
+	if err != nil || found || normalized == login {
+		return id, hash, found, err
+	}
+
+	return s.repo.Find(ctx, login)
+}
+
+func (s *service) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	old, err := s.sessions.UseRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			return nil, ErrInvalidToken
+		}
+
+		s.logger.Errorw("failed to use refresh token", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	localLogger := s.logger.WithLazy("userID", old.UserID, "sessionID", old.SessionID)
+
+	if old.Used {
+		localLogger.Warnw("refresh token is reused, revoking session")
+
+		err = s.sessions.Revoke(ctx, old.SessionID)
+		if err != nil {
+			localLogger.Errorw("failed to revoke session", "error", err)
+
+			return nil, ErrInternal
+		}
+
+		return nil, ErrInvalidToken
 	}
 
-	if savedHash != s.hashPassword(password) {
-		return "", ErrInvalidPair
+	if old.Expired {
+		return nil, ErrInvalidToken
 	}
 
-	token, err := s.issueToken(id)
+	active, err := s.sessions.IsActive(ctx, old.SessionID)
 	if err != nil {
-		s.logger.Errorw("failed to issue token", "login", login, "error", err)
+		localLogger.Errorw("failed to check session", "error", err)
+
+		return nil, ErrInternal
+	}
 
-		return "", ErrInternal
+	if !active {
+		return nil, ErrInvalidToken
 	}
 
-	return token, nil
+	tokens, err := s.issueTokens(ctx, old.UserID, old.SessionID)
+	if err != nil {
+		localLogger.Errorw("failed to issue tokens", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	return tokens, nil
+}
+
+func (s *service) Logout(ctx context.Context, sessionID string) error {
+	err := s.sessions.Revoke(ctx, sessionID)
+	if err != nil {
+		s.logger.Errorw("failed to revoke session", "sessionID", sessionID, "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
 }
 
-func (s *service) ParseToken(_ context.Context, token string) (string, error) {
-	claims := new(jwt.RegisteredClaims)
+func (s *service) GetUser(ctx context.Context, userID string) (*User, error) {
+	u, err := s.get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to get MFA", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	u.MFAEnabled = mfa != nil && mfa.Enabled
+
+	return u, nil
+}
+
+func (s *service) FindUser(ctx context.Context, login string) (*User, error) {
+	id, _, found, err := s.find(ctx, login)
+	if err != nil {
+		s.logger.Errorw("failed to find user", "login", login, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if !found {
+		return nil, ErrUserNotFound
+	}
+
+	return s.GetUser(ctx, id)
+}
+
+// get the user without MFA status
+func (s *service) get(ctx context.Context, userID string) (*User, error) {
+	u, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to get user", "userID", userID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+func (s *service) SetRole(ctx context.Context, userID string, role Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	found, err := s.repo.SetRole(ctx, userID, role)
+	if err != nil {
+		s.logger.Errorw("failed to set role", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	if !found {
+		return ErrUserNotFound
+	}
+
+	err = s.sessions.RevokeAll(ctx, userID)
+	if err != nil {
+		s.logger.Errorw("failed to revoke sessions", "userID", userID, "error", err)
+
+		return ErrInternal
+	}
+
+	s.logger.Infow("role set", "userID", userID, "role", role)
+
+	return nil
+}
+
+func (s *service) ParseToken(ctx context.Context, token string) (*Claims, error) {
+	claims := new(accessClaims)
 
 	parsedToken, err := jwt.ParseWithClaims(
 		token,
 		claims,
 		func(t *jwt.Token) (interface{}, error) {
-			method, ok := t.Method.(*jwt.SigningMethodHMAC)
+			kid, ok := t.Header["kid"].(string)
 			if !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+				return nil, errors.New("no key id")
+			}
+
+			key, found, err := s.keys.verificationKey(ctx, kid)
+			if err != nil {
+				return nil, fmt.Errorf("cant load keys: %w", err)
 			}
 
-			if method.Name != signingMethod.Name {
+			if !found {
+				return nil, fmt.Errorf("unknown key id: %s", kid)
+			}
+
+			method, err := key.Algorithm.method()
+			if err != nil {
+				return nil, err
+			}
+
+			if t.Method.Alg() != method.Alg() {
 				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 			}
 
-			return s.options.TokenSecret, nil
+			return key.PrivateKey.Public(), nil
 		},
 	)
 
 	if err != nil {
 		s.logger.Infow("failed to parse token", "error", err)
 
-		return "", ErrInvalidToken
+		return nil, ErrInvalidToken
 	}
 
-	if !parsedToken.Valid {
-		return "", ErrInvalidToken
+	if !parsedToken.Valid || claims.SessionID == "" {
+		return nil, ErrInvalidToken
 	}
 
-	return claims.Subject, nil
+	active, err := s.sessions.IsActive(ctx, claims.SessionID)
+	if err != nil {
+		s.logger.Errorw("failed to check session", "sessionID", claims.SessionID, "error", err)
+
+		return nil, ErrInternal
+	}
+
+	if !active {
+		return nil, ErrInvalidToken
+	}
+
+	// tokens issued before roles were introduced
+	role := claims.Role
+	if role == "" {
+		role = RoleUser
+	}
+
+	return &Claims{UserID: claims.Subject, SessionID: claims.SessionID, Role: role}, nil
+}
+
+// rehashPassword with the current algorithm. Failure is not fatal, the password will be rehashed on the next login
+func (s *service) rehashPassword(ctx context.Context, userID string, password string) {
+	hash, err := s.options.PasswordHasher.Hash(password)
+	if err != nil {
+		s.logger.Errorw("password rehashing failed", "userID", userID, "error", err)
+
+		return
+	}
+
+	err = s.repo.UpdatePasswordHash(ctx, userID, hash)
+	if err != nil {
+		s.logger.Errorw("failed to update password hash", "userID", userID, "error", err)
+
+		return
+	}
+
+	s.logger.Infow("password rehashed", "userID", userID)
+}
+
+func (s *service) issueTokens(ctx context.Context, userID string, sessionID string) (*Tokens, error) {
+	access, err := s.issueAccessToken(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.sessions.AddRefreshToken(ctx, sessionID, hashToken(refresh), time.Now().Add(s.options.RefreshTokenExpirationPeriod))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return &Tokens{
+		Access:          access,
+		AccessExpiresIn: s.options.TokenExpirationPeriod,
+		Refresh:         refresh,
+	}, nil
 }
 
-func (s *service) hashPassword(password string) string {
-	withSalt := password + s.options.PasswordSalt
+func (s *service) PublicKeys(ctx context.Context) ([]*PublicKey, error) {
+	// makes sure keys are loaded
+	_, err := s.keys.signingKey(ctx)
+	if err != nil {
+		s.logger.Errorw("failed to get signing key", "error", err)
+
+		return nil, ErrInternal
+	}
+
+	return s.keys.publicKeys(), nil
+}
+
+func (s *service) RotateKeys(ctx context.Context) error {
+	err := s.keys.rotate(ctx)
+	if err != nil {
+		s.logger.Errorw("failed to rotate signing keys", "error", err)
 
-	hashBytes := sha256.Sum256([]byte(withSalt))
+		return ErrInternal
+	}
 
-	return hex.EncodeToString(hashBytes[:])
+	return nil
 }
 
-func (s *service) issueToken(userID string) (string, error) {
+func (s *service) issueAccessToken(ctx context.Context, userID string, sessionID string) (string, error) {
+	key, err := s.keys.signingKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get signing key: %w", err)
+	}
+
+	method, err := key.Algorithm.method()
+	if err != nil {
+		return "", err
+	}
+
+	// role is read on every refresh, so its changes apply to the session
+	u, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if u == nil {
+		return "", ErrUserNotFound
+	}
+
 	now := time.Now()
 
-	token := jwt.NewWithClaims(signingMethod, jwt.RegisteredClaims{
-		Subject:   userID,
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(s.options.TokenExpirationPeriod)),
+	token := jwt.NewWithClaims(method, accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.options.TokenExpirationPeriod)),
+		},
+		SessionID: sessionID,
+		Role:      u.Role,
 	})
 
-	tokenString, err := token.SignedString(s.options.TokenSecret)
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.PrivateKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
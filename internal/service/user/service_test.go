@@ -0,0 +1,569 @@
+package user_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	userStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/keys"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/mfa"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/resets"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/sessions"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/password"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/policy"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/totp"
+	"github.com/kuvalkin/gophermart-loyalty/internal/test"
+)
+
+func TestService(t *testing.T) {
+	log.InitTestLogger(t)
+
+	t.Run("new password is hashed with argon2id", testNewPasswordHash)
+	t.Run("legacy hash is upgraded on login", testLegacyHashUpgraded)
+	t.Run("bcrypt hash is upgraded on login", testBcryptHashUpgraded)
+	t.Run("unknown login is verified like a known one", testUnknownLoginVerified)
+	t.Run("signing key rotation", testKeyRotation)
+	t.Run("RS256 signing", testRS256Signing)
+	t.Run("password change", testPasswordChange)
+	t.Run("password reset", testPasswordReset)
+	t.Run("login is normalized", testLoginNormalized)
+	t.Run("TOTP MFA", testTOTP)
+	t.Run("role", testRole)
+}
+
+const legacySalt = "salt"
+const pass = "correct horse battery staple"
+
+var cheapArgon2 = password.Argon2Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func newService(t *testing.T, repo user.Repository) user.Service {
+	return newServiceWithNotifier(t, repo, new(notifications))
+}
+
+func newServiceWithNotifier(t *testing.T, repo user.Repository, notifier user.Notifier) user.Service {
+	return newServiceWithHasher(t, repo, notifier, newHasher(t))
+}
+
+func newHasher(t *testing.T) user.PasswordHasher {
+	hasher, err := password.NewHasher(&password.Options{
+		Algorithm:  password.Argon2id,
+		Argon2:     cheapArgon2,
+		LegacySalt: legacySalt,
+	})
+	require.NoError(t, err)
+
+	return hasher
+}
+
+func newServiceWithHasher(t *testing.T, repo user.Repository, notifier user.Notifier, hasher user.PasswordHasher) user.Service {
+	service, err := user.NewService(repo, sessions.NewMemoryRepository(), keys.NewMemoryRepository(), resets.NewMemoryRepository(), mfa.NewMemoryRepository(), notifier, &user.Options{
+		SigningAlgorithm:             user.SigningEdDSA,
+		KeyRotationPeriod:            time.Hour,
+		KeyReloadInterval:            time.Minute,
+		PasswordHasher:               hasher,
+		Policy:                       newPolicy(t),
+		TokenExpirationPeriod:        time.Hour,
+		RefreshTokenExpirationPeriod: time.Hour,
+		ResetTokenExpirationPeriod:   time.Hour,
+		MFAIssuer:                    "Gophermart",
+		MFAChallengeExpirationPeriod: time.Minute,
+	})
+	require.NoError(t, err)
+
+	return service
+}
+
+func newPolicy(t *testing.T) user.CredentialsPolicy {
+	credentialsPolicy, err := policy.NewPolicy(&policy.Options{
+		MinLoginLength:    1,
+		MinPasswordLength: 1,
+		FoldLoginCase:     true,
+	})
+	require.NoError(t, err)
+
+	return credentialsPolicy
+}
+
+// notifications keep the last reset token by login
+type notifications struct {
+	resetTokens map[string]string
+}
+
+func (n *notifications) SendPasswordReset(_ context.Context, _ string, login string, token string) error {
+	if n.resetTokens == nil {
+		n.resetTokens = make(map[string]string)
+	}
+
+	n.resetTokens[login] = token
+
+	return nil
+}
+
+func testNewPasswordHash(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	repo := userStorage.NewInMemoryRepository()
+	service := newService(t, repo)
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+	require.NoError(t, service.Register(ctx, "another", pass))
+
+	_, hash, _, err := repo.Find(ctx, "user")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+
+	_, anotherHash, _, err := repo.Find(ctx, "another")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, anotherHash, "salt is unique for every hash")
+
+	_, err = service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+
+	_, err = service.Login(ctx, "user", "wrong")
+	assert.ErrorIs(t, err, user.ErrInvalidPair)
+}
+
+func testLegacyHashUpgraded(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	legacy := sha256.Sum256([]byte(pass + legacySalt))
+
+	repo := userStorage.NewInMemoryRepository()
+	require.NoError(t, repo.Add(ctx, "user", hex.EncodeToString(legacy[:])))
+
+	assertUpgradedOnLogin(t, repo)
+}
+
+func testBcryptHashUpgraded(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	bcryptHasher, err := password.NewHasher(&password.Options{Algorithm: password.Bcrypt, BcryptCost: 4})
+	require.NoError(t, err)
+
+	hash, err := bcryptHasher.Hash(pass)
+	require.NoError(t, err)
+
+	repo := userStorage.NewInMemoryRepository()
+	require.NoError(t, repo.Add(ctx, "user", hash))
+
+	assertUpgradedOnLogin(t, repo)
+}
+
+// countingHasher counts verifications
+type countingHasher struct {
+	user.PasswordHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(password string, hash string) (bool, bool, error) {
+	h.verified++
+
+	return h.PasswordHasher.Verify(password, hash)
+}
+
+func testUnknownLoginVerified(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	hasher := &countingHasher{PasswordHasher: newHasher(t)}
+	service := newServiceWithHasher(t, userStorage.NewInMemoryRepository(), new(notifications), hasher)
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+
+	_, err := service.Login(ctx, "user", "wrong")
+	require.ErrorIs(t, err, user.ErrInvalidPair)
+	require.Equal(t, 1, hasher.verified)
+
+	// an unknown login pays for the verification too, so it can't be told apart by the response time
+	_, err = service.Login(ctx, "unknown", "wrong")
+	require.ErrorIs(t, err, user.ErrInvalidPair)
+	require.Equal(t, 2, hasher.verified)
+}
+
+func assertUpgradedOnLogin(t *testing.T, repo user.Repository) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	service := newService(t, repo)
+
+	_, err := service.Login(ctx, "user", "wrong")
+	require.ErrorIs(t, err, user.ErrInvalidPair)
+
+	_, oldHash, _, err := repo.Find(ctx, "user")
+	require.NoError(t, err)
+	require.False(t, strings.HasPrefix(oldHash, "$argon2id$"), "hash is not upgraded on failed login")
+
+	_, err = service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+
+	_, newHash, _, err := repo.Find(ctx, "user")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(newHash, "$argon2id$"), newHash)
+
+	_, err = service.Login(ctx, "user", pass)
+	assert.NoError(t, err, "upgraded hash is verified")
+}
+
+func testKeyRotation(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	repo := userStorage.NewInMemoryRepository()
+
+	hasher, err := password.NewHasher(&password.Options{Algorithm: password.Argon2id, Argon2: cheapArgon2})
+	require.NoError(t, err)
+
+	// every rotation is due right away, new keys are activated after 200ms
+	service, err := user.NewService(repo, sessions.NewMemoryRepository(), keys.NewMemoryRepository(), resets.NewMemoryRepository(), mfa.NewMemoryRepository(), new(notifications), &user.Options{
+		SigningAlgorithm:             user.SigningEdDSA,
+		KeyRotationPeriod:            time.Millisecond,
+		KeyReloadInterval:            100 * time.Millisecond,
+		PasswordHasher:               hasher,
+		Policy:                       newPolicy(t),
+		TokenExpirationPeriod:        time.Hour,
+		RefreshTokenExpirationPeriod: time.Hour,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+
+	login := func() (*user.Tokens, string) {
+		result, err := service.Login(ctx, "user", pass)
+		require.NoError(t, err)
+		tokens := result.Tokens
+
+		parsed, _, err := jwt.NewParser().ParseUnverified(tokens.Access, jwt.MapClaims{})
+		require.NoError(t, err)
+		assert.Equal(t, "EdDSA", parsed.Header["alg"])
+
+		kid, ok := parsed.Header["kid"].(string)
+		require.True(t, ok)
+
+		return tokens, kid
+	}
+
+	oldTokens, oldKid := login()
+
+	require.NoError(t, service.RotateKeys(ctx))
+
+	publicKeys, err := service.PublicKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, publicKeys, 2, "new key is published before activation")
+
+	_, kid := login()
+	assert.Equal(t, oldKid, kid, "new key is not active yet")
+
+	time.Sleep(250 * time.Millisecond)
+
+	_, kid = login()
+	assert.NotEqual(t, oldKid, kid, "new key is active")
+
+	claims, err := service.ParseToken(ctx, oldTokens.Access)
+	require.NoError(t, err, "tokens signed with the old key are valid until they expire")
+	assert.NotEmpty(t, claims.UserID)
+}
+
+func testRS256Signing(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	hasher, err := password.NewHasher(&password.Options{Algorithm: password.Argon2id, Argon2: cheapArgon2})
+	require.NoError(t, err)
+
+	service, err := user.NewService(userStorage.NewInMemoryRepository(), sessions.NewMemoryRepository(), keys.NewMemoryRepository(), resets.NewMemoryRepository(), mfa.NewMemoryRepository(), new(notifications), &user.Options{
+		SigningAlgorithm:             user.SigningRS256,
+		KeyRotationPeriod:            time.Hour,
+		KeyReloadInterval:            time.Minute,
+		PasswordHasher:               hasher,
+		Policy:                       newPolicy(t),
+		TokenExpirationPeriod:        time.Hour,
+		RefreshTokenExpirationPeriod: time.Hour,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+
+	result, err := service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+	tokens := result.Tokens
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(tokens.Access, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", parsed.Header["alg"])
+
+	_, err = service.ParseToken(ctx, tokens.Access)
+	assert.NoError(t, err)
+}
+
+func testPasswordChange(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	service := newService(t, userStorage.NewInMemoryRepository())
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+
+	result, err := service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+	old := result.Tokens
+
+	claims, err := service.ParseToken(ctx, old.Access)
+	require.NoError(t, err)
+
+	_, err = service.ChangePassword(ctx, claims.UserID, "wrong", "new password")
+	assert.ErrorIs(t, err, user.ErrWrongPassword)
+
+	_, err = service.ChangePassword(ctx, claims.UserID, pass, "")
+	assert.ErrorIs(t, err, user.ErrInvalidPassword)
+
+	tokens, err := service.ChangePassword(ctx, claims.UserID, pass, "new password")
+	require.NoError(t, err)
+
+	_, err = service.ParseToken(ctx, old.Access)
+	assert.ErrorIs(t, err, user.ErrInvalidToken, "old session is revoked")
+
+	_, err = service.ParseToken(ctx, tokens.Access)
+	assert.NoError(t, err, "new session is started")
+
+	_, err = service.Login(ctx, "user", pass)
+	assert.ErrorIs(t, err, user.ErrInvalidPair)
+
+	_, err = service.Login(ctx, "user", "new password")
+	assert.NoError(t, err)
+}
+
+func testPasswordReset(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	sent := new(notifications)
+	service := newServiceWithNotifier(t, userStorage.NewInMemoryRepository(), sent)
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+
+	result, err := service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+	old := result.Tokens
+
+	require.NoError(t, service.RequestPasswordReset(ctx, "unknown"), "unknown login is not reported")
+	assert.Empty(t, sent.resetTokens)
+
+	require.NoError(t, service.RequestPasswordReset(ctx, " USER "))
+	token := sent.resetTokens["user"]
+	require.NotEmpty(t, token, "token is sent to the stored login")
+
+	assert.ErrorIs(t, service.ResetPassword(ctx, "invalid", "new password"), user.ErrInvalidResetToken)
+	assert.ErrorIs(t, service.ResetPassword(ctx, token, ""), user.ErrInvalidPassword)
+
+	require.NoError(t, service.ResetPassword(ctx, token, "new password"))
+	assert.ErrorIs(t, service.ResetPassword(ctx, token, "another password"), user.ErrInvalidResetToken, "token is single-use")
+
+	_, err = service.ParseToken(ctx, old.Access)
+	assert.ErrorIs(t, err, user.ErrInvalidToken, "sessions are revoked")
+
+	_, err = service.Login(ctx, "user", "new password")
+	assert.NoError(t, err)
+}
+
+func testLoginNormalized(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	repo := userStorage.NewInMemoryRepository()
+	sent := new(notifications)
+	service := newServiceWithNotifier(t, repo, sent)
+
+	require.NoError(t, service.Register(ctx, " Gopher ", pass))
+	assert.ErrorIs(t, service.Register(ctx, "GOPHER", pass), user.ErrLoginTaken)
+
+	_, err := service.Login(ctx, "gopher", pass)
+	assert.NoError(t, err)
+
+	t.Run("legacy login with upper case", func(t *testing.T) {
+		hasher, err := password.NewHasher(&password.Options{Algorithm: password.Argon2id, Argon2: cheapArgon2})
+		require.NoError(t, err)
+
+		hash, err := hasher.Hash(pass)
+		require.NoError(t, err)
+
+		require.NoError(t, repo.Add(ctx, "Legacy", hash))
+
+		_, err = service.Login(ctx, "Legacy", pass)
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, service.Register(ctx, "legacy", pass), user.ErrLoginTaken, "legacy login can't be taken in another case")
+
+		// the login in any case still belongs to the legacy user
+		legacy, err := service.FindUser(ctx, "Legacy")
+		require.NoError(t, err)
+		found, err := service.FindUser(ctx, "legacy")
+		require.NoError(t, err)
+		assert.Equal(t, legacy.ID, found.ID)
+		assert.Equal(t, "Legacy", found.Login)
+
+		require.NoError(t, service.RequestPasswordReset(ctx, "legacy"))
+		assert.NotEmpty(t, sent.resetTokens["Legacy"], "reset is sent to the stored login")
+	})
+}
+
+func testTOTP(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	service := newService(t, userStorage.NewInMemoryRepository())
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+
+	result, err := service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+	require.NotNil(t, result.Tokens, "MFA is optional")
+
+	claims, err := service.ParseToken(ctx, result.Tokens.Access)
+	require.NoError(t, err)
+	userID := claims.UserID
+
+	_, err = service.ConfirmTOTP(ctx, userID, "123456")
+	assert.ErrorIs(t, err, user.ErrMFANotEnrolled)
+
+	enrollment, err := service.EnrollTOTP(ctx, userID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/Gophermart:user?"), enrollment.URI)
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	step := totp.Step(time.Now())
+	code := func(step int64) string {
+		code, err := totp.Code(enrollment.Secret, step)
+		require.NoError(t, err)
+
+		return code
+	}
+
+	result, err = service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+	require.NotNil(t, result.Tokens, "MFA is not required until confirmed")
+
+	_, err = service.ConfirmTOTP(ctx, userID, "abcdef")
+	assert.ErrorIs(t, err, user.ErrInvalidMFACode)
+
+	recoveryCodes, err := service.ConfirmTOTP(ctx, userID, code(step))
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, 10)
+
+	_, err = service.EnrollTOTP(ctx, userID)
+	assert.ErrorIs(t, err, user.ErrMFAAlreadyEnabled)
+
+	challenge := func() string {
+		result, err := service.Login(ctx, "user", pass)
+		require.NoError(t, err)
+		require.Nil(t, result.Tokens)
+		require.NotNil(t, result.MFAChallenge)
+		assert.Equal(t, time.Minute, result.MFAChallenge.ExpiresIn)
+
+		return result.MFAChallenge.Token
+	}
+
+	t.Run("TOTP", func(t *testing.T) {
+		c := challenge()
+
+		_, err := service.CompleteMFA(ctx, c, code(step))
+		assert.ErrorIs(t, err, user.ErrInvalidMFACode, "used code can't be replayed")
+
+		_, err = service.CompleteMFA(ctx, "invalid", code(step+1))
+		assert.ErrorIs(t, err, user.ErrInvalidChallenge)
+
+		// next step is accepted, because clocks can drift
+		tokens, err := service.CompleteMFA(ctx, c, code(step+1))
+		require.NoError(t, err)
+
+		_, err = service.ParseToken(ctx, tokens.Access)
+		assert.NoError(t, err)
+
+		_, err = service.CompleteMFA(ctx, c, recoveryCodes[0])
+		assert.ErrorIs(t, err, user.ErrInvalidChallenge, "challenge is completed")
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		_, err := service.CompleteMFA(ctx, challenge(), strings.ToUpper(recoveryCodes[1]))
+		require.NoError(t, err)
+
+		_, err = service.CompleteMFA(ctx, challenge(), recoveryCodes[1])
+		assert.ErrorIs(t, err, user.ErrInvalidMFACode, "recovery code is single-use")
+	})
+
+	t.Run("attempts are limited", func(t *testing.T) {
+		c := challenge()
+
+		for range 5 {
+			_, err := service.CompleteMFA(ctx, c, "000000")
+			assert.ErrorIs(t, err, user.ErrInvalidMFACode)
+		}
+
+		_, err := service.CompleteMFA(ctx, c, recoveryCodes[2])
+		assert.ErrorIs(t, err, user.ErrInvalidChallenge)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		assert.ErrorIs(t, service.DisableTOTP(ctx, userID, "wrong-code"), user.ErrInvalidMFACode)
+		require.NoError(t, service.DisableTOTP(ctx, userID, recoveryCodes[3]))
+
+		result, err := service.Login(ctx, "user", pass)
+		require.NoError(t, err)
+		assert.NotNil(t, result.Tokens)
+
+		assert.ErrorIs(t, service.DisableTOTP(ctx, userID, recoveryCodes[4]), user.ErrMFANotEnabled)
+	})
+}
+
+func testRole(t *testing.T) {
+	ctx, cancel := test.Context(t)
+	defer cancel()
+
+	service := newService(t, userStorage.NewInMemoryRepository())
+
+	require.NoError(t, service.Register(ctx, "user", pass))
+
+	result, err := service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+
+	claims, err := service.ParseToken(ctx, result.Tokens.Access)
+	require.NoError(t, err)
+	assert.Equal(t, user.RoleUser, claims.Role)
+
+	assert.ErrorIs(t, service.SetRole(ctx, claims.UserID, "root"), user.ErrInvalidRole)
+	assert.ErrorIs(t, service.SetRole(ctx, "00000000-0000-0000-0000-000000000000", user.RoleAdmin), user.ErrUserNotFound)
+	require.NoError(t, service.SetRole(ctx, claims.UserID, user.RoleSupport))
+
+	_, err = service.ParseToken(ctx, result.Tokens.Access)
+	assert.ErrorIs(t, err, user.ErrInvalidToken, "token with the old role is revoked")
+
+	result, err = service.Login(ctx, "user", pass)
+	require.NoError(t, err)
+
+	claims, err = service.ParseToken(ctx, result.Tokens.Access)
+	require.NoError(t, err)
+	assert.Equal(t, user.RoleSupport, claims.Role)
+
+	u, err := service.FindUser(ctx, "USER")
+	require.NoError(t, err)
+	assert.Equal(t, claims.UserID, u.ID)
+	assert.Equal(t, user.RoleSupport, u.Role)
+}
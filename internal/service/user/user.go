@@ -7,29 +7,214 @@ import (
 )
 
 var ErrInvalidLogin = errors.New("invalid login")
-var ErrInvalidPassword = errors.New("password is too short")
+var ErrInvalidPassword = errors.New("invalid password")
 var ErrLoginTaken = errors.New("user with this login already exists")
 var ErrInvalidPair = errors.New("login/password pair is invalid")
 var ErrInvalidToken = errors.New("invalid token")
+var ErrUserNotFound = errors.New("user not found")
+var ErrWrongPassword = errors.New("wrong password")
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+var ErrInvalidChallenge = errors.New("invalid or expired MFA challenge")
+var ErrInvalidMFACode = errors.New("invalid MFA code")
+var ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+var ErrMFANotEnrolled = errors.New("MFA enrollment is not started")
+var ErrMFANotEnabled = errors.New("MFA is not enabled")
 var ErrInternal = errors.New("internal error")
 
+type Tokens struct {
+	// Access token is short-lived and authenticates requests
+	Access          string
+	AccessExpiresIn time.Duration
+	// Refresh token is single-use, it is exchanged for new tokens
+	Refresh string
+}
+
+// LoginResult has tokens, or a challenge if the user has to complete MFA
+type LoginResult struct {
+	Tokens       *Tokens
+	MFAChallenge *MFAChallenge
+}
+
+type MFAChallenge struct {
+	Token     string
+	ExpiresIn time.Duration
+}
+
+type TOTPEnrollment struct {
+	Secret string
+	// URI to provision the secret to an authenticator app
+	URI string
+}
+
+// Claims of a valid access token
+type Claims struct {
+	UserID    string
+	SessionID string
+	Role      Role
+}
+
+type User struct {
+	ID         string
+	Login      string
+	Role       Role
+	MFAEnabled bool
+	CreatedAt  time.Time
+}
+
 type Service interface {
 	Register(ctx context.Context, login string, password string) error
-	// Login authenticates a user and returns auth token on success
-	Login(ctx context.Context, login string, password string) (string, error)
-	ParseToken(ctx context.Context, token string) (string, error)
+	// Login authenticates a user and starts a new session. Users with MFA get a challenge instead
+	Login(ctx context.Context, login string, password string) (*LoginResult, error)
+	// CompleteMFA challenge with a TOTP or recovery code and start a new session
+	CompleteMFA(ctx context.Context, challenge string, code string) (*Tokens, error)
+	// EnrollTOTP generates a new secret, which is not required for login until confirmed
+	EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error)
+	// ConfirmTOTP with a code from the authenticator app. Returns single-use recovery codes
+	ConfirmTOTP(ctx context.Context, userID string, code string) ([]string, error)
+	// DisableTOTP with a TOTP or recovery code
+	DisableTOTP(ctx context.Context, userID string, code string) error
+	// Refresh rotates the refresh token of a session. Reuse of a rotated token revokes the session, because it is likely stolen
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	// Logout revokes the session, so neither its access nor its refresh tokens are accepted anymore
+	Logout(ctx context.Context, sessionID string) error
+	// ChangePassword after checking the old one. All sessions are revoked and a new one is started
+	ChangePassword(ctx context.Context, userID string, oldPassword string, newPassword string) (*Tokens, error)
+	// RequestPasswordReset sends a reset token to the user. Unknown login is not reported, so logins can't be enumerated
+	RequestPasswordReset(ctx context.Context, login string) error
+	// ResetPassword with the single-use token. All sessions are revoked
+	ResetPassword(ctx context.Context, token string, newPassword string) error
+	// NormalizeLogin the same way it is normalized on registration
+	NormalizeLogin(login string) string
+	// GetUser returns ErrUserNotFound if there is no such user
+	GetUser(ctx context.Context, userID string) (*User, error)
+	// FindUser by login, returns ErrUserNotFound if there is no such user
+	FindUser(ctx context.Context, login string) (*User, error)
+	// SetRole of the user and revoke all sessions, so tokens with the old role are not accepted
+	SetRole(ctx context.Context, userID string, role Role) error
+	// ParseToken checks the access token and returns its claims if the session is not revoked
+	ParseToken(ctx context.Context, token string) (*Claims, error)
+	// PublicKeys verifying access tokens, including keys, that are not used for signing yet
+	PublicKeys(ctx context.Context) ([]*PublicKey, error)
+	// RotateKeys adds a new signing key when the current one is due to be replaced and reloads keys
+	RotateKeys(ctx context.Context) error
 }
 
 type Options struct {
-	TokenSecret           []byte
-	PasswordSalt          string
-	MinPasswordLength     int
+	SigningAlgorithm SigningAlgorithm
+	// KeyRotationPeriod is how long a key signs tokens before it is replaced
+	KeyRotationPeriod time.Duration
+	// KeyReloadInterval is how often keys are reloaded, new keys are activated after two intervals
+	KeyReloadInterval     time.Duration
+	PasswordHasher        PasswordHasher
+	Policy                CredentialsPolicy
 	TokenExpirationPeriod time.Duration
+	// RefreshTokenExpirationPeriod is the lifetime of every refresh token, session lasts while tokens are refreshed
+	RefreshTokenExpirationPeriod time.Duration
+	// ResetTokenExpirationPeriod is the lifetime of a password reset token
+	ResetTokenExpirationPeriod time.Duration
+	// MFAIssuer is shown in authenticator apps
+	MFAIssuer string
+	// MFAChallengeExpirationPeriod is how long a user has to enter the code after the password
+	MFAChallengeExpirationPeriod time.Duration
+}
+
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify the password against the hash. Rehash is needed if the hash is made with outdated algorithm or parameters
+	Verify(password string, hash string) (ok bool, needsRehash bool, err error)
 }
 
 var ErrLoginNotUnique = errors.New("user with this login already exists")
 
+// Repository of users. Logins are unique ignoring case, so a login registered before normalization can't be taken
+// by the same login in another case
 type Repository interface {
+	// Add the user, returns ErrLoginNotUnique if the login is taken in any case
 	Add(ctx context.Context, login string, passwordHash string) error
+	// Find the user by the login in any case
 	Find(ctx context.Context, login string) (string, string, bool, error)
+	// Get the user without MFA status, nil if there is no such user
+	Get(ctx context.Context, userID string) (*User, error)
+	SetRole(ctx context.Context, userID string, role Role) (bool, error)
+	UpdatePasswordHash(ctx context.Context, userID string, passwordHash string) error
+}
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+type RefreshToken struct {
+	SessionID string
+	UserID    string
+	Expired   bool
+	// Used is true if the token had already been used before
+	Used bool
+}
+
+// SessionRepository keeps hashes of refresh tokens only
+type SessionRepository interface {
+	// Create a session and return its ID
+	Create(ctx context.Context, userID string) (string, error)
+	// IsActive returns false if the session is revoked or not found
+	IsActive(ctx context.Context, sessionID string) (bool, error)
+	Revoke(ctx context.Context, sessionID string) error
+	RevokeAll(ctx context.Context, userID string) error
+	AddRefreshToken(ctx context.Context, sessionID string, tokenHash string, expiresAt time.Time) error
+	// UseRefreshToken marks the token as used. Returns ErrRefreshTokenNotFound if there is no such token
+	UseRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+}
+
+var ErrResetTokenNotFound = errors.New("reset token not found")
+
+type ResetToken struct {
+	UserID  string
+	Expired bool
+	// Used is true if the token had already been used before
+	Used bool
+}
+
+// ResetTokenRepository keeps hashes of password reset tokens only
+type ResetTokenRepository interface {
+	Add(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error
+	// Get the token without using it. Returns ErrResetTokenNotFound if there is no such token
+	Get(ctx context.Context, tokenHash string) (*ResetToken, error)
+	// Use marks the token as used. Returns ErrResetTokenNotFound if there is no such token
+	Use(ctx context.Context, tokenHash string) (*ResetToken, error)
+}
+
+// Notifier delivers messages to users
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, userID string, login string, token string) error
+}
+
+var ErrChallengeNotFound = errors.New("MFA challenge not found")
+
+type MFA struct {
+	Secret  string
+	Enabled bool
+}
+
+type Challenge struct {
+	UserID  string
+	Expired bool
+	// Attempts including the current one
+	Attempts int
+}
+
+// MFARepository keeps hashes of recovery codes and challenges only
+type MFARepository interface {
+	// Get MFA of the user, nil if the user has never enrolled
+	Get(ctx context.Context, userID string) (*MFA, error)
+	// Enroll with a new secret, replacing a not enabled one
+	Enroll(ctx context.Context, userID string, secret string) error
+	// Enable MFA and replace recovery codes
+	Enable(ctx context.Context, userID string, recoveryCodeHashes []string) error
+	// Disable MFA and remove its secret and recovery codes
+	Disable(ctx context.Context, userID string) error
+	// UseStep of the TOTP, returns false if the step or a later one was used already, so codes can't be replayed
+	UseStep(ctx context.Context, userID string, step int64) (bool, error)
+	// UseRecoveryCode returns false if there is no such unused code
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+	AddChallenge(ctx context.Context, userID string, challengeHash string, expiresAt time.Time) error
+	// AttemptChallenge counts an attempt. Returns ErrChallengeNotFound if there is no such challenge
+	AttemptChallenge(ctx context.Context, challengeHash string) (*Challenge, error)
+	DeleteChallenge(ctx context.Context, challengeHash string) error
 }
@@ -2,26 +2,27 @@ package user
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 )
 
-func GenerateTokenSecret() ([]byte, error) {
+// generateToken for refresh and password reset
+func generateToken() (string, error) {
 	buf := make([]byte, 32)
 
 	_, err := rand.Read(buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read random bytes: %w", err)
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
 	}
 
-	return buf, nil
+	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
-func GeneratePasswordSalt() (string, error) {
-	randomBytes, err := GenerateTokenSecret()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate random string: %w", err)
-	}
+// tokens are random, so a fast hash is enough to keep them useless if the database leaks
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
 
-	return hex.EncodeToString(randomBytes), nil
+	return hex.EncodeToString(hash[:])
 }
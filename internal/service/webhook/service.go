@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewService(nonces NonceRepository, options *Options) Service {
+	return &service{
+		nonces:  nonces,
+		options: options,
+		logger:  log.Logger().Named("webhook"),
+	}
+}
+
+type service struct {
+	nonces  NonceRepository
+	options *Options
+	logger  *zap.SugaredLogger
+}
+
+func (s *service) Verify(ctx context.Context, timestamp string, nonce string, signature string, body []byte) error {
+	localLogger := s.logger.WithLazy("timestamp", timestamp, "nonce", nonce)
+
+	if nonce == "" || len(nonce) > maxNonceLength {
+		localLogger.Debugw("invalid nonce")
+
+		return ErrInvalidSignature
+	}
+
+	decoded, err := hex.DecodeString(signature)
+	if err != nil {
+		localLogger.Debugw("invalid signature encoding", "error", err)
+
+		return ErrInvalidSignature
+	}
+
+	expected, _ := hex.DecodeString(Sign(s.options.Secret, timestamp, nonce, body))
+	if !hmac.Equal(decoded, expected) {
+		localLogger.Debugw("signature mismatch")
+
+		return ErrInvalidSignature
+	}
+
+	// timestamp is trusted only after the signature is checked
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		localLogger.Debugw("invalid timestamp", "error", err)
+
+		return ErrInvalidSignature
+	}
+
+	now := time.Now()
+	sentAt := time.Unix(seconds, 0)
+	if sentAt.Before(now.Add(-s.options.Tolerance)) || sentAt.After(now.Add(s.options.Tolerance)) {
+		localLogger.Infow("stale request")
+
+		return ErrStale
+	}
+
+	return nil
+}
+
+func (s *service) UseNonce(ctx context.Context, timestamp string, nonce string, tx transaction.Transaction) error {
+	localLogger := s.logger.WithLazy("timestamp", timestamp, "nonce", nonce)
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		localLogger.Debugw("invalid timestamp", "error", err)
+
+		return ErrInvalidSignature
+	}
+
+	// requests older than the tolerance are rejected anyway, so the nonce is not needed after that
+	err = s.nonces.Use(ctx, nonce, time.Unix(seconds, 0).Add(s.options.Tolerance), time.Now(), tx)
+	if errors.Is(err, ErrReplayed) {
+		localLogger.Warnw("replayed request")
+
+		return ErrReplayed
+	} else if err != nil {
+		localLogger.Errorw("cant use nonce", "error", err)
+
+		return ErrInternal
+	}
+
+	return nil
+}
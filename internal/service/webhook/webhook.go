@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+var ErrStale = errors.New("timestamp is out of tolerance")
+var ErrReplayed = errors.New("nonce is used already")
+var ErrInternal = errors.New("internal error")
+
+// maxNonceLength keeps senders from filling the nonce storage with huge values
+const maxNonceLength = 128
+
+type Options struct {
+	// Secret shared with the sender
+	Secret []byte
+	// Tolerance of the difference between the request timestamp and now, nonces are kept for as long
+	Tolerance time.Duration
+}
+
+// Service verifies requests signed by the accrual system
+type Service interface {
+	// Verify the signature of the body sent at the timestamp in unix seconds
+	Verify(ctx context.Context, timestamp string, nonce string, signature string, body []byte) error
+	// UseNonce of a verified request as a part of the transaction, that applies the request,
+	// so the same request is not accepted twice, but can be retried if it was not applied
+	UseNonce(ctx context.Context, timestamp string, nonce string, tx transaction.Transaction) error
+}
+
+// NonceRepository of used nonces, shared by all instances
+type NonceRepository interface {
+	// Use the nonce until it expires. Returns ErrReplayed if it is used already
+	Use(ctx context.Context, nonce string, expiresAt time.Time, now time.Time, tx transaction.Transaction) error
+}
+
+// Sign the body with the timestamp and the nonce, the signature is hex encoded HMAC-SHA256 of "timestamp.nonce.body"
+func Sign(secret []byte, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
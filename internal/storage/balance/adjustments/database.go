@@ -0,0 +1,84 @@
+package adjustments
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) balance.AdjustmentsRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Add(ctx context.Context, adjustment *balance.Adjustment, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row, err := internal.QueryRowContext(
+		localCtx,
+		d.db,
+		tx,
+		`INSERT INTO balance_adjustments (user_id, program, operator_id, amount, reason) VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at`,
+		adjustment.UserID,
+		adjustment.Program,
+		adjustment.OperatorID,
+		adjustment.Amount,
+		adjustment.Reason,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	err = row.Scan(&adjustment.ID, &adjustment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("scan error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) List(ctx context.Context, userID string) ([]*balance.Adjustment, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(
+		localCtx,
+		`SELECT id, program, operator_id, amount, reason, created_at FROM balance_adjustments
+WHERE user_id = $1
+ORDER BY id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]*balance.Adjustment, 0)
+	for rows.Next() {
+		a := &balance.Adjustment{UserID: userID}
+
+		if err := rows.Scan(&a.ID, &a.Program, &a.OperatorID, &a.Amount, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		result = append(result, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
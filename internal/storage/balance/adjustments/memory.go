@@ -0,0 +1,46 @@
+package adjustments
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewMemoryRepository() balance.AdjustmentsRepository {
+	return &memoryRepo{
+		storage: make(map[string][]*balance.Adjustment),
+	}
+}
+
+type memoryRepo struct {
+	storage map[string][]*balance.Adjustment
+	lastID  int64
+}
+
+func (m *memoryRepo) Add(_ context.Context, adjustment *balance.Adjustment, _ transaction.Transaction) error {
+	m.lastID++
+
+	adjustment.ID = m.lastID
+	adjustment.CreatedAt = time.Now()
+
+	stored := *adjustment
+	m.storage[adjustment.UserID] = append(m.storage[adjustment.UserID], &stored)
+
+	return nil
+}
+
+func (m *memoryRepo) List(_ context.Context, userID string) ([]*balance.Adjustment, error) {
+	result := make([]*balance.Adjustment, 0, len(m.storage[userID]))
+
+	for _, a := range m.storage[userID] {
+		stored := *a
+		result = append(result, &stored)
+	}
+
+	slices.Reverse(result)
+
+	return result, nil
+}
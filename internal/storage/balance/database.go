@@ -8,7 +8,7 @@ import (
 	"time"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
-	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
@@ -21,22 +21,29 @@ type dbRepo struct {
 	timeout time.Duration
 }
 
-func (d *dbRepo) Get(ctx context.Context, userID string, tx transaction.Transaction) (*balance.Balance, bool, error) {
+func (d *dbRepo) Get(ctx context.Context, userID string, program string, tx transaction.Transaction) (*balance.Balance, bool, error) {
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
+	query := "SELECT current, withdrawn FROM balances WHERE user_id = $1 AND program = $2"
+	if tx != nil {
+		// concurrent withdrawals must not see the same balance
+		query += " FOR UPDATE"
+	}
+
 	row, err := internal.QueryRowContext(
 		localCtx,
 		d.db,
 		tx,
-		"SELECT current, withdrawn FROM balances WHERE user_id = $1",
+		query,
 		userID,
+		program,
 	)
 	if err != nil {
 		return nil, false, fmt.Errorf("query error: %w", err)
 	}
 
-	b := &balance.Balance{}
+	b := &balance.Balance{Program: program}
 	err = row.Scan(&b.Current, &b.Withdrawn)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -49,14 +56,18 @@ func (d *dbRepo) Get(ctx context.Context, userID string, tx transaction.Transact
 	return b, true, nil
 }
 
-func (d *dbRepo) Increase(ctx context.Context, userID string, increment int64) error {
+func (d *dbRepo) Increase(ctx context.Context, userID string, program string, increment int64, tx transaction.Transaction) error {
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
-	_, err := d.db.ExecContext(
+	_, err := internal.ExecContext(
 		localCtx,
-		"INSERT INTO balances (user_id, current, withdrawn) VALUES ($1, $2, 0) ON CONFLICT (user_id) DO UPDATE SET current = balances.current + excluded.current",
+		d.db,
+		tx,
+		`INSERT INTO balances (user_id, program, current, withdrawn) VALUES ($1, $2, $3, 0)
+ON CONFLICT (user_id, program) DO UPDATE SET current = balances.current + excluded.current`,
 		userID,
+		program,
 		increment,
 	)
 	if err != nil {
@@ -66,7 +77,7 @@ func (d *dbRepo) Increase(ctx context.Context, userID string, increment int64) e
 	return nil
 }
 
-func (d *dbRepo) Withdraw(ctx context.Context, userID string, decrement int64, tx transaction.Transaction) error {
+func (d *dbRepo) Withdraw(ctx context.Context, userID string, program string, decrement int64, tx transaction.Transaction) error {
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
@@ -74,9 +85,31 @@ func (d *dbRepo) Withdraw(ctx context.Context, userID string, decrement int64, t
 		localCtx,
 		d.db,
 		tx,
-		"UPDATE balances SET current = current - $1, withdrawn = withdrawn + $1 WHERE user_id = $2",
+		"UPDATE balances SET current = current - $1, withdrawn = withdrawn + $1 WHERE user_id = $2 AND program = $3",
 		decrement,
 		userID,
+		program,
+	)
+
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Refund(ctx context.Context, userID string, program string, sum int64, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"UPDATE balances SET current = current + $1, withdrawn = withdrawn - $1 WHERE user_id = $2 AND program = $3",
+		sum,
+		userID,
+		program,
 	)
 
 	if err != nil {
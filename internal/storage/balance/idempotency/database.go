@@ -0,0 +1,82 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) balance.IdempotencyRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Save(ctx context.Context, userID string, key string, fingerprint string, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// waits for uncommitted transaction with the same key, so it is either inserted or the key is already committed
+	res, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		`INSERT INTO idempotency_keys (user_id, key, fingerprint) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, key) DO NOTHING`,
+		userID,
+		key,
+		fingerprint,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	if affected == 0 {
+		return balance.ErrDuplicateIdempotencyKey
+	}
+
+	return nil
+}
+
+func (d *dbRepo) GetFingerprint(ctx context.Context, userID string, key string, tx transaction.Transaction) (string, bool, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row, err := internal.QueryRowContext(
+		localCtx,
+		d.db,
+		tx,
+		"SELECT fingerprint FROM idempotency_keys WHERE user_id = $1 AND key = $2",
+		userID,
+		key,
+	)
+	if err != nil {
+		return "", false, fmt.Errorf("query error: %w", err)
+	}
+
+	var fingerprint string
+	err = row.Scan(&fingerprint)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("query error: %w", err)
+	}
+
+	return fingerprint, true, nil
+}
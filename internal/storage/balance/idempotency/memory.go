@@ -0,0 +1,41 @@
+package idempotency
+
+import (
+	"context"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewMemoryRepository() balance.IdempotencyRepository {
+	return &memoryRepo{
+		storage: make(map[string]map[string]string),
+	}
+}
+
+type memoryRepo struct {
+	// fingerprints by user and key
+	storage map[string]map[string]string
+}
+
+func (m *memoryRepo) Save(_ context.Context, userID string, key string, fingerprint string, _ transaction.Transaction) error {
+	keys, ok := m.storage[userID]
+	if !ok {
+		keys = make(map[string]string)
+		m.storage[userID] = keys
+	}
+
+	if _, ok := keys[key]; ok {
+		return balance.ErrDuplicateIdempotencyKey
+	}
+
+	keys[key] = fingerprint
+
+	return nil
+}
+
+func (m *memoryRepo) GetFingerprint(_ context.Context, userID string, key string, _ transaction.Transaction) (string, bool, error) {
+	fingerprint, ok := m.storage[userID][key]
+
+	return fingerprint, ok, nil
+}
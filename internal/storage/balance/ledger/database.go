@@ -0,0 +1,183 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) balance.LedgerRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Post(ctx context.Context, posting *balance.Posting, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// unique violation would abort the whole transaction, so duplicates are detected by affected rows
+	res, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		`INSERT INTO ledger (user_id, program, kind, amount, reference, description) VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, kind, reference) DO NOTHING`,
+		posting.UserID,
+		posting.Program,
+		string(posting.Kind),
+		posting.Amount,
+		posting.Reference,
+		posting.Description,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	if affected == 0 {
+		return balance.ErrDuplicatePosting
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Totals(ctx context.Context, userID string, program string) (*balance.Balance, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(
+		localCtx,
+		`SELECT COALESCE(SUM(amount), 0), COALESCE(-SUM(amount) FILTER (WHERE kind IN ($3, $4)), 0)
+FROM ledger WHERE user_id = $1 AND program = $2`,
+		userID,
+		program,
+		string(balance.PostingWithdrawal),
+		string(balance.PostingReversal),
+	)
+
+	b := &balance.Balance{Program: program}
+	err := row.Scan(&b.Current, &b.Withdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	return b, nil
+}
+
+func (d *dbRepo) Statement(ctx context.Context, userID string, filter *balance.StatementFilter) ([]*balance.StatementEntry, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// running balance is calculated over the whole history before any filters are applied
+	rows, err := d.db.QueryContext(
+		localCtx,
+		`WITH statement AS (
+	SELECT id, kind, amount, reference, description, created_at, SUM(amount) OVER (ORDER BY created_at, id) AS balance
+	FROM ledger
+	WHERE user_id = $1 AND program = $2
+)
+SELECT id, kind, amount, reference, description, created_at, balance
+FROM statement
+WHERE ($3::timestamptz IS NULL OR created_at >= $3)
+	AND ($4::timestamptz IS NULL OR created_at < $4)
+	AND ($5::bigint IS NULL OR (created_at, id) < (SELECT created_at, id FROM ledger WHERE id = $5 AND user_id = $1))
+ORDER BY created_at DESC, id DESC
+LIMIT $6`,
+		userID,
+		filter.Program,
+		sql.NullTime{Time: filter.From, Valid: !filter.From.IsZero()},
+		sql.NullTime{Time: filter.To, Valid: !filter.To.IsZero()},
+		sql.NullInt64{Int64: filter.AfterID(), Valid: filter.After != nil},
+		filter.Limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]*balance.StatementEntry, 0)
+	for rows.Next() {
+		e := &balance.StatementEntry{
+			Posting: &balance.Posting{UserID: userID, Program: filter.Program},
+		}
+
+		var kind string
+		if err := rows.Scan(&e.ID, &kind, &e.Amount, &e.Reference, &e.Description, &e.CreatedAt, &e.Balance); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		e.Kind = balance.PostingKind(kind)
+
+		result = append(result, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (d *dbRepo) Mismatches(ctx context.Context) ([]*balance.Mismatch, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(
+		localCtx,
+		`WITH totals AS (
+	SELECT user_id, program, SUM(amount) AS current, -COALESCE(SUM(amount) FILTER (WHERE kind IN ($1, $2)), 0) AS withdrawn
+	FROM ledger
+	GROUP BY user_id, program
+)
+SELECT COALESCE(b.user_id, t.user_id), COALESCE(b.program, t.program),
+	COALESCE(b.current, 0), COALESCE(b.withdrawn, 0), COALESCE(t.current, 0), COALESCE(t.withdrawn, 0)
+FROM balances b
+FULL OUTER JOIN totals t ON t.user_id = b.user_id AND t.program = b.program
+WHERE COALESCE(b.current, 0) <> COALESCE(t.current, 0) OR COALESCE(b.withdrawn, 0) <> COALESCE(t.withdrawn, 0)`,
+		string(balance.PostingWithdrawal),
+		string(balance.PostingReversal),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]*balance.Mismatch, 0)
+	for rows.Next() {
+		m := &balance.Mismatch{
+			Cached: &balance.Balance{},
+			Ledger: &balance.Balance{},
+		}
+
+		err := rows.Scan(&m.UserID, &m.Program, &m.Cached.Current, &m.Cached.Withdrawn, &m.Ledger.Current, &m.Ledger.Withdrawn)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		m.Cached.Program = m.Program
+		m.Ledger.Program = m.Program
+
+		result = append(result, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
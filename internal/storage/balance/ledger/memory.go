@@ -0,0 +1,102 @@
+package ledger
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewMemoryRepository() balance.LedgerRepository {
+	return &memoryRepo{
+		storage: make(map[string][]*balance.Posting),
+	}
+}
+
+type memoryRepo struct {
+	storage map[string][]*balance.Posting
+	lastID  int64
+}
+
+func (m *memoryRepo) Post(_ context.Context, posting *balance.Posting, _ transaction.Transaction) error {
+	for _, p := range m.storage[posting.UserID] {
+		if p.Kind == posting.Kind && p.Reference == posting.Reference {
+			return balance.ErrDuplicatePosting
+		}
+	}
+
+	m.lastID++
+
+	stored := *posting
+	stored.ID = m.lastID
+	stored.CreatedAt = time.Now()
+
+	m.storage[posting.UserID] = append(m.storage[posting.UserID], &stored)
+
+	return nil
+}
+
+func (m *memoryRepo) Totals(_ context.Context, userID string, program string) (*balance.Balance, error) {
+	b := &balance.Balance{Program: program}
+
+	for _, p := range m.storage[userID] {
+		if p.Program != program {
+			continue
+		}
+
+		b.Current += p.Amount
+
+		if p.Kind == balance.PostingWithdrawal || p.Kind == balance.PostingReversal {
+			b.Withdrawn -= p.Amount
+		}
+	}
+
+	return b, nil
+}
+
+func (m *memoryRepo) Statement(_ context.Context, userID string, filter *balance.StatementFilter) ([]*balance.StatementEntry, error) {
+	postings := m.storage[userID]
+	result := make([]*balance.StatementEntry, 0)
+
+	afterID := filter.AfterID()
+
+	var running int64
+	// postings are stored in creation order, so cursor entry is always older than the ones after it
+	for _, p := range postings {
+		if p.Program != filter.Program {
+			continue
+		}
+
+		running += p.Amount
+
+		if !filter.From.IsZero() && p.CreatedAt.Before(filter.From) {
+			continue
+		}
+
+		if !filter.To.IsZero() && !p.CreatedAt.Before(filter.To) {
+			continue
+		}
+
+		if afterID != 0 && p.ID >= afterID {
+			continue
+		}
+
+		stored := *p
+		result = append(result, &balance.StatementEntry{Posting: &stored, Balance: running})
+	}
+
+	slices.Reverse(result)
+
+	if len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+
+	return result, nil
+}
+
+func (m *memoryRepo) Mismatches(_ context.Context) ([]*balance.Mismatch, error) {
+	// there are no cached balances to compare with in memory
+	return make([]*balance.Mismatch, 0), nil
+}
@@ -0,0 +1,230 @@
+package lots
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) balance.LotsRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Add(ctx context.Context, userID string, program string, amount int64, expiresAt time.Time, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"INSERT INTO point_lots (user_id, program, amount, remaining, expires_at) VALUES ($1, $2, $3, $3, $4::timestamptz)",
+		userID,
+		program,
+		amount,
+		sql.NullTime{Time: expiresAt, Valid: !expiresAt.IsZero()},
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Consume(ctx context.Context, userID string, program string, reference string, amount int64, tx transaction.Transaction) (int64, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// running total of the lots before each one tells how much to take from it
+	row, err := internal.QueryRowContext(
+		localCtx,
+		d.db,
+		tx,
+		`WITH candidates AS (
+	SELECT id, remaining, COALESCE(SUM(remaining) OVER (ORDER BY expires_at NULLS LAST, id ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING), 0) AS before
+	FROM point_lots
+	WHERE user_id = $1 AND program = $2 AND remaining > 0 AND (expires_at IS NULL OR expires_at > now())
+), taken AS (
+	SELECT id, LEAST(remaining, $3 - before) AS amount
+	FROM candidates
+	WHERE before < $3
+), updated AS (
+	UPDATE point_lots l SET remaining = l.remaining - t.amount
+	FROM taken t
+	WHERE l.id = t.id
+	RETURNING l.id, t.amount
+), consumed AS (
+	INSERT INTO point_lot_consumptions (lot_id, reference, amount)
+	SELECT id, $4, amount FROM updated
+	RETURNING amount
+)
+SELECT COALESCE(SUM(amount), 0) FROM consumed`,
+		userID,
+		program,
+		amount,
+		reference,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	var consumed int64
+	err = row.Scan(&consumed)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	return consumed, nil
+}
+
+func (d *dbRepo) Restore(ctx context.Context, userID string, reference string, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		`WITH restored AS (
+	DELETE FROM point_lot_consumptions c
+	USING point_lots l
+	WHERE c.lot_id = l.id AND l.user_id = $1 AND c.reference = $2
+	RETURNING c.lot_id, c.amount
+)
+UPDATE point_lots l SET remaining = l.remaining + r.amount
+FROM (SELECT lot_id, SUM(amount) AS amount FROM restored GROUP BY lot_id) r
+WHERE l.id = r.lot_id`,
+		userID,
+		reference,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Expired(ctx context.Context, limit int) ([]*balance.Lot, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(
+		localCtx,
+		`SELECT id, user_id, program, remaining, expires_at FROM point_lots
+WHERE expires_at <= now() AND remaining > 0
+ORDER BY expires_at
+LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]*balance.Lot, 0)
+	for rows.Next() {
+		lot := &balance.Lot{}
+		if err := rows.Scan(&lot.ID, &lot.UserID, &lot.Program, &lot.Remaining, &lot.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		result = append(result, lot)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (d *dbRepo) Expire(ctx context.Context, lotID int64, tx transaction.Transaction) (int64, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row, err := internal.QueryRowContext(
+		localCtx,
+		d.db,
+		tx,
+		`UPDATE point_lots l SET remaining = 0, expired_at = now()
+FROM (SELECT id, remaining FROM point_lots WHERE id = $1 FOR UPDATE) old
+WHERE l.id = old.id AND l.expires_at <= now() AND l.remaining > 0
+RETURNING old.remaining`,
+		lotID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	var remaining int64
+	err = row.Scan(&remaining)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	return remaining, nil
+}
+
+func (d *dbRepo) ExpiringBefore(ctx context.Context, userID string, program string, before time.Time) (int64, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(
+		localCtx,
+		`SELECT COALESCE(SUM(remaining), 0) FROM point_lots
+WHERE user_id = $1 AND program = $2 AND remaining > 0 AND expires_at < $3::timestamptz`,
+		userID,
+		program,
+		before,
+	)
+
+	var sum int64
+	err := row.Scan(&sum)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	return sum, nil
+}
+
+func (d *dbRepo) Spendable(ctx context.Context, userID string, program string, tx transaction.Transaction) (int64, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row, err := internal.QueryRowContext(
+		localCtx,
+		d.db,
+		tx,
+		`SELECT COALESCE(SUM(remaining), 0) FROM point_lots
+WHERE user_id = $1 AND program = $2 AND remaining > 0 AND (expires_at IS NULL OR expires_at > now())`,
+		userID,
+		program,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	var sum int64
+	err = row.Scan(&sum)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	return sum, nil
+}
@@ -0,0 +1,164 @@
+package lots
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewMemoryRepository() balance.LotsRepository {
+	return &memoryRepo{
+		storage:      make(map[string][]*balance.Lot),
+		consumptions: make(map[string][]*consumption),
+	}
+}
+
+type memoryRepo struct {
+	// lots by user
+	storage map[string][]*balance.Lot
+	// consumptions by user and reference
+	consumptions map[string][]*consumption
+	lastID       int64
+}
+
+type consumption struct {
+	lot    *balance.Lot
+	amount int64
+}
+
+func (m *memoryRepo) Add(_ context.Context, userID string, program string, amount int64, expiresAt time.Time, _ transaction.Transaction) error {
+	m.lastID++
+
+	m.storage[userID] = append(m.storage[userID], &balance.Lot{
+		ID:        m.lastID,
+		UserID:    userID,
+		Program:   program,
+		Remaining: amount,
+		ExpiresAt: expiresAt,
+	})
+
+	return nil
+}
+
+func (m *memoryRepo) Consume(_ context.Context, userID string, program string, reference string, amount int64, _ transaction.Transaction) (int64, error) {
+	now := time.Now()
+	lots := slices.DeleteFunc(slices.Clone(m.storage[userID]), func(lot *balance.Lot) bool {
+		return lot.Program != program || isExpired(lot, now)
+	})
+	slices.SortStableFunc(lots, func(a, b *balance.Lot) int {
+		// lots without expiration go last
+		switch {
+		case a.ExpiresAt.IsZero() && b.ExpiresAt.IsZero():
+			return 0
+		case a.ExpiresAt.IsZero():
+			return 1
+		case b.ExpiresAt.IsZero():
+			return -1
+		default:
+			return a.ExpiresAt.Compare(b.ExpiresAt)
+		}
+	})
+
+	var consumed int64
+	key := userID + "/" + reference
+
+	for _, lot := range lots {
+		if consumed == amount {
+			break
+		}
+
+		taken := min(lot.Remaining, amount-consumed)
+		if taken <= 0 {
+			continue
+		}
+
+		lot.Remaining -= taken
+		consumed += taken
+
+		m.consumptions[key] = append(m.consumptions[key], &consumption{lot: lot, amount: taken})
+	}
+
+	return consumed, nil
+}
+
+func (m *memoryRepo) Restore(_ context.Context, userID string, reference string, _ transaction.Transaction) error {
+	key := userID + "/" + reference
+
+	for _, c := range m.consumptions[key] {
+		c.lot.Remaining += c.amount
+	}
+
+	delete(m.consumptions, key)
+
+	return nil
+}
+
+func (m *memoryRepo) Expired(_ context.Context, limit int) ([]*balance.Lot, error) {
+	result := make([]*balance.Lot, 0)
+	now := time.Now()
+
+	for _, lots := range m.storage {
+		for _, lot := range lots {
+			if len(result) == limit {
+				return result, nil
+			}
+
+			if lot.Remaining > 0 && isExpired(lot, now) {
+				result = append(result, lot)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (m *memoryRepo) Expire(_ context.Context, lotID int64, _ transaction.Transaction) (int64, error) {
+	now := time.Now()
+
+	for _, lots := range m.storage {
+		for _, lot := range lots {
+			if lot.ID != lotID || !isExpired(lot, now) {
+				continue
+			}
+
+			remaining := lot.Remaining
+			lot.Remaining = 0
+
+			return remaining, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (m *memoryRepo) ExpiringBefore(_ context.Context, userID string, program string, before time.Time) (int64, error) {
+	var sum int64
+
+	for _, lot := range m.storage[userID] {
+		if lot.Program == program && !lot.ExpiresAt.IsZero() && lot.ExpiresAt.Before(before) {
+			sum += lot.Remaining
+		}
+	}
+
+	return sum, nil
+}
+
+func (m *memoryRepo) Spendable(_ context.Context, userID string, program string, _ transaction.Transaction) (int64, error) {
+	var sum int64
+	now := time.Now()
+
+	for _, lot := range m.storage[userID] {
+		if lot.Program == program && !isExpired(lot, now) {
+			sum += lot.Remaining
+		}
+	}
+
+	return sum, nil
+}
+
+func isExpired(lot *balance.Lot, now time.Time) bool {
+	return !lot.ExpiresAt.IsZero() && !lot.ExpiresAt.After(now)
+}
@@ -9,20 +9,25 @@ import (
 
 func NewInMemoryRepository() balance.Repository {
 	return &memoryRepo{
-		storage: make(map[string]*value),
+		storage: make(map[key]*value),
 	}
 }
 
 type memoryRepo struct {
-	storage map[string]*value
+	storage map[key]*value
+}
+
+type key struct {
+	userID  string
+	program string
 }
 
 type value struct {
 	balance *balance.Balance
 }
 
-func (m *memoryRepo) Get(_ context.Context, userID string, _ transaction.Transaction) (*balance.Balance, bool, error) {
-	value, ok := m.storage[userID]
+func (m *memoryRepo) Get(_ context.Context, userID string, program string, _ transaction.Transaction) (*balance.Balance, bool, error) {
+	value, ok := m.storage[key{userID: userID, program: program}]
 	if !ok {
 		return nil, false, nil
 	}
@@ -30,14 +35,16 @@ func (m *memoryRepo) Get(_ context.Context, userID string, _ transaction.Transac
 	return value.balance, true, nil
 }
 
-func (m *memoryRepo) Increase(_ context.Context, userID string, increment int64) error {
-	v, ok := m.storage[userID]
+func (m *memoryRepo) Increase(_ context.Context, userID string, program string, increment int64, _ transaction.Transaction) error {
+	k := key{userID: userID, program: program}
+
+	v, ok := m.storage[k]
 	if !ok {
 		v = &value{
-			balance: &balance.Balance{},
+			balance: &balance.Balance{Program: program},
 		}
 
-		m.storage[userID] = v
+		m.storage[k] = v
 	}
 
 	v.balance.Current += increment
@@ -45,8 +52,8 @@ func (m *memoryRepo) Increase(_ context.Context, userID string, increment int64)
 	return nil
 }
 
-func (m *memoryRepo) Withdraw(_ context.Context, userID string, decrement int64, _ transaction.Transaction) error {
-	v, ok := m.storage[userID]
+func (m *memoryRepo) Withdraw(_ context.Context, userID string, program string, decrement int64, _ transaction.Transaction) error {
+	v, ok := m.storage[key{userID: userID, program: program}]
 	if !ok {
 		return nil
 	}
@@ -56,3 +63,15 @@ func (m *memoryRepo) Withdraw(_ context.Context, userID string, decrement int64,
 
 	return nil
 }
+
+func (m *memoryRepo) Refund(_ context.Context, userID string, program string, sum int64, _ transaction.Transaction) error {
+	v, ok := m.storage[key{userID: userID, program: program}]
+	if !ok {
+		return nil
+	}
+
+	v.balance.Current += sum
+	v.balance.Withdrawn -= sum
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package pending
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) balance.PendingRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Hold(ctx context.Context, userID string, program string, orderNumber string, amount *int64, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	var amountArg sql.NullInt64
+	if amount != nil {
+		amountArg = sql.NullInt64{Int64: *amount, Valid: true}
+	}
+
+	_, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		`INSERT INTO pending_accruals (order_number, user_id, program, amount) VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_number) DO UPDATE SET amount = COALESCE(EXCLUDED.amount, pending_accruals.amount), updated_at = now()`,
+		orderNumber,
+		userID,
+		program,
+		amountArg,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Release(ctx context.Context, orderNumber string, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"DELETE FROM pending_accruals WHERE order_number = $1",
+		orderNumber,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Sum(ctx context.Context, userID string, program string) (int64, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(
+		localCtx,
+		"SELECT COALESCE(SUM(amount), 0) FROM pending_accruals WHERE user_id = $1 AND program = $2",
+		userID,
+		program,
+	)
+
+	var sum int64
+	err := row.Scan(&sum)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	return sum, nil
+}
@@ -0,0 +1,56 @@
+package pending
+
+import (
+	"context"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewMemoryRepository() balance.PendingRepository {
+	return &memoryRepo{
+		storage: make(map[string]*hold),
+	}
+}
+
+type memoryRepo struct {
+	// holds by order number
+	storage map[string]*hold
+}
+
+type hold struct {
+	userID  string
+	program string
+	amount  int64
+}
+
+func (m *memoryRepo) Hold(_ context.Context, userID string, program string, orderNumber string, amount *int64, _ transaction.Transaction) error {
+	h, ok := m.storage[orderNumber]
+	if !ok {
+		h = &hold{userID: userID, program: program}
+		m.storage[orderNumber] = h
+	}
+
+	if amount != nil {
+		h.amount = *amount
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) Release(_ context.Context, orderNumber string, _ transaction.Transaction) error {
+	delete(m.storage, orderNumber)
+
+	return nil
+}
+
+func (m *memoryRepo) Sum(_ context.Context, userID string, program string) (int64, error) {
+	var sum int64
+	for _, h := range m.storage {
+		if h.userID == userID && h.program == program {
+			sum += h.amount
+		}
+	}
+
+	return sum, nil
+}
@@ -3,11 +3,12 @@ package withdrawals
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
-	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
@@ -20,7 +21,7 @@ type dbRepo struct {
 	timeout time.Duration
 }
 
-func (d *dbRepo) Add(ctx context.Context, userID string, orderNumber string, sum int64, tx transaction.Transaction) error {
+func (d *dbRepo) Add(ctx context.Context, userID string, program string, orderNumber string, sum int64, tx transaction.Transaction) error {
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
@@ -28,8 +29,9 @@ func (d *dbRepo) Add(ctx context.Context, userID string, orderNumber string, sum
 		localCtx,
 		d.db,
 		tx,
-		"INSERT INTO withdrawals (user_id, order_number, sum) VALUES ($1, $2, $3)",
+		"INSERT INTO withdrawals (user_id, program, order_number, sum) VALUES ($1, $2, $3, $4)",
 		userID,
+		program,
 		orderNumber,
 		sum,
 	)
@@ -40,11 +42,84 @@ func (d *dbRepo) Add(ctx context.Context, userID string, orderNumber string, sum
 	return nil
 }
 
-func (d *dbRepo) List(ctx context.Context, userID string) ([]*balance.WithdrawalHistoryEntry, error) {
+func (d *dbRepo) Get(ctx context.Context, userID string, orderNumber string, tx transaction.Transaction) (*balance.WithdrawalHistoryEntry, bool, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	query := `SELECT order_number, program, sum, status, processed_at, cancel_reason, cancelled_at FROM withdrawals
+WHERE user_id = $1 AND order_number = $2`
+	if tx != nil {
+		query += " FOR UPDATE"
+	}
+
+	row, err := internal.QueryRowContext(localCtx, d.db, tx, query, userID, orderNumber)
+	if err != nil {
+		return nil, false, fmt.Errorf("query error: %w", err)
+	}
+
+	e, err := scanEntry(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("query error: %w", err)
+	}
+
+	return e, true, nil
+}
+
+func (d *dbRepo) Cancel(ctx context.Context, userID string, orderNumber string, reason string, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"UPDATE withdrawals SET status = $1, cancel_reason = $2, cancelled_at = now() WHERE user_id = $3 AND order_number = $4",
+		balance.WithdrawalCancelled.String(),
+		reason,
+		userID,
+		orderNumber,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) List(ctx context.Context, userID string, filter *balance.WithdrawalsFilter) ([]*balance.WithdrawalHistoryEntry, error) {
+	statuses := make([]string, 0, len(filter.Statuses))
+	for _, status := range filter.Statuses {
+		statuses = append(statuses, status.String())
+	}
+
+	var afterTime sql.NullTime
+	var afterNumber sql.NullString
+	if filter.After != nil {
+		afterTime = sql.NullTime{Time: filter.After.Time, Valid: true}
+		afterNumber = sql.NullString{String: filter.After.Key, Valid: true}
+	}
+
 	rows, err := d.db.QueryContext(
 		ctx,
-		`SELECT order_number, sum, processed_at FROM withdrawals WHERE user_id = $1`,
+		`SELECT order_number, program, sum, status, processed_at, cancel_reason, cancelled_at FROM withdrawals
+WHERE user_id = $1
+	AND (cardinality($2::text[]) = 0 OR status::text = ANY($2))
+	AND ($3::timestamptz IS NULL OR processed_at >= $3)
+	AND ($4::timestamptz IS NULL OR processed_at < $4)
+	AND ($5::timestamp IS NULL OR (processed_at, order_number) < ($5, $6))
+ORDER BY processed_at DESC, order_number DESC
+LIMIT $7`,
 		userID,
+		statuses,
+		sql.NullTime{Time: filter.From, Valid: !filter.From.IsZero()},
+		sql.NullTime{Time: filter.To, Valid: !filter.To.IsZero()},
+		afterTime,
+		afterNumber,
+		filter.Limit,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("query error: %w", err)
@@ -54,21 +129,12 @@ func (d *dbRepo) List(ctx context.Context, userID string) ([]*balance.Withdrawal
 
 	result := make([]*balance.WithdrawalHistoryEntry, 0)
 	for rows.Next() {
-		var number string
-		var sum int64
-		var processedAt time.Time
-
-		if err := rows.Scan(&number, &sum, &processedAt); err != nil {
+		e, err := scanEntry(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
 
-		singleResult := &balance.WithdrawalHistoryEntry{
-			OrderNumber: number,
-			Sum:         sum,
-			ProcessedAt: processedAt,
-		}
-
-		result = append(result, singleResult)
+		result = append(result, e)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -77,3 +143,30 @@ func (d *dbRepo) List(ctx context.Context, userID string) ([]*balance.Withdrawal
 
 	return result, nil
 }
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(row scanner) (*balance.WithdrawalHistoryEntry, error) {
+	e := &balance.WithdrawalHistoryEntry{}
+
+	var status string
+	var reason sql.NullString
+	var cancelledAt sql.NullTime
+
+	err := row.Scan(&e.OrderNumber, &e.Program, &e.Sum, &status, &e.ProcessedAt, &reason, &cancelledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Status = balance.WithdrawalStatus(status)
+	if cancelledAt.Valid {
+		e.Cancellation = &balance.Cancellation{
+			Reason:      reason.String,
+			CancelledAt: cancelledAt.Time,
+		}
+	}
+
+	return e, nil
+}
@@ -2,6 +2,7 @@ package withdrawals
 
 import (
 	"context"
+	"slices"
 	"time"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
@@ -18,7 +19,7 @@ type memoryRepo struct {
 	storage map[string][]*balance.WithdrawalHistoryEntry
 }
 
-func (d *memoryRepo) Add(_ context.Context, userID string, orderNumber string, sum int64, _ transaction.Transaction) error {
+func (d *memoryRepo) Add(_ context.Context, userID string, program string, orderNumber string, sum int64, _ transaction.Transaction) error {
 	_, ok := d.storage[userID]
 	if !ok {
 		d.storage[userID] = make([]*balance.WithdrawalHistoryEntry, 0)
@@ -26,18 +27,70 @@ func (d *memoryRepo) Add(_ context.Context, userID string, orderNumber string, s
 
 	d.storage[userID] = append(d.storage[userID], &balance.WithdrawalHistoryEntry{
 		OrderNumber: orderNumber,
+		Program:     program,
 		Sum:         sum,
+		Status:      balance.WithdrawalCompleted,
 		ProcessedAt: time.Now(),
 	})
 
 	return nil
 }
 
-func (d *memoryRepo) List(_ context.Context, userID string) ([]*balance.WithdrawalHistoryEntry, error) {
-	list, ok := d.storage[userID]
-	if !ok {
-		return make([]*balance.WithdrawalHistoryEntry, 0), nil
+func (d *memoryRepo) Get(_ context.Context, userID string, orderNumber string, _ transaction.Transaction) (*balance.WithdrawalHistoryEntry, bool, error) {
+	for _, e := range d.storage[userID] {
+		if e.OrderNumber == orderNumber {
+			return e, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+func (d *memoryRepo) Cancel(ctx context.Context, userID string, orderNumber string, reason string, tx transaction.Transaction) error {
+	e, found, _ := d.Get(ctx, userID, orderNumber, tx)
+	if !found {
+		return nil
+	}
+
+	e.Status = balance.WithdrawalCancelled
+	e.Cancellation = &balance.Cancellation{
+		Reason:      reason,
+		CancelledAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (d *memoryRepo) List(_ context.Context, userID string, filter *balance.WithdrawalsFilter) ([]*balance.WithdrawalHistoryEntry, error) {
+	result := make([]*balance.WithdrawalHistoryEntry, 0)
+
+	// withdrawals are stored in processing order
+	list := d.storage[userID]
+	for i := len(list) - 1; i >= 0; i-- {
+		e := list[i]
+
+		if !filter.From.IsZero() && e.ProcessedAt.Before(filter.From) {
+			continue
+		}
+
+		if !filter.To.IsZero() && !e.ProcessedAt.Before(filter.To) {
+			continue
+		}
+
+		if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, e.Status) {
+			continue
+		}
+
+		if filter.After != nil && !filter.After.After(e.ProcessedAt, e.OrderNumber) {
+			continue
+		}
+
+		result = append(result, e)
+
+		if len(result) == filter.Limit {
+			break
+		}
 	}
 
-	return list, nil
+	return result, nil
 }
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
 type dbRepo struct {
@@ -19,15 +21,18 @@ func NewDatabaseRepository(db *sql.DB, timeout time.Duration) order.Repository {
 	return &dbRepo{db: db, timeout: timeout}
 }
 
-func (d *dbRepo) Add(ctx context.Context, userID string, number string, status order.Status) error {
+func (d *dbRepo) Add(ctx context.Context, userID string, number string, program string, status order.Status, tx transaction.Transaction) error {
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
-	_, err := d.db.ExecContext(
+	_, err := internal.ExecContext(
 		localCtx,
-		"INSERT INTO orders (user_id, number, status) VALUES ($1, $2, $3)",
+		d.db,
+		tx,
+		"INSERT INTO orders (user_id, number, program, status) VALUES ($1, $2, $3, $4)",
 		userID,
 		number,
+		program,
 		string(status),
 	)
 	if err != nil {
@@ -37,57 +42,90 @@ func (d *dbRepo) Add(ctx context.Context, userID string, number string, status o
 	return nil
 }
 
-func (d *dbRepo) Update(ctx context.Context, number string, status order.Status, accrual *int64) error {
+func (d *dbRepo) Update(ctx context.Context, number string, status order.Status, accrual *int64, tx transaction.Transaction) (bool, error) {
 	var query string
 	var args []any
 
+	// final statuses are never overwritten, so concurrent results for the same order are applied only once
 	if accrual == nil {
-		query = "UPDATE orders SET status = $1, updated_at = $2 WHERE number = $3"
-		args = []any{status, time.Now(), number}
+		query = "UPDATE orders SET status = $1, updated_at = $2 WHERE number = $3 AND status NOT IN ($4, $5)"
+		args = []any{status, time.Now(), number, order.StatusProcessed, order.StatusInvalid}
 	} else {
-		query = "UPDATE orders SET status = $1, updated_at = $2, accrual = $3 WHERE number = $4"
-		args = []any{status, time.Now(), *accrual, number}
+		query = "UPDATE orders SET status = $1, updated_at = $2, accrual = $3 WHERE number = $4 AND status NOT IN ($5, $6)"
+		args = []any{status, time.Now(), *accrual, number, order.StatusProcessed, order.StatusInvalid}
 	}
 
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
-	_, err := d.db.ExecContext(
+	res, err := internal.ExecContext(
 		localCtx,
+		d.db,
+		tx,
 		query,
 		args...,
 	)
 	if err != nil {
-		return fmt.Errorf("query error: %w", err)
+		return false, fmt.Errorf("query error: %w", err)
 	}
 
-	return nil
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	return affected > 0, nil
 }
 
-func (d *dbRepo) GetOwner(ctx context.Context, number string) (string, bool, error) {
+func (d *dbRepo) GetOwner(ctx context.Context, number string) (*order.Owner, bool, error) {
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
-	row := d.db.QueryRowContext(localCtx, "SELECT user_id FROM orders WHERE number = $1", number)
+	row := d.db.QueryRowContext(localCtx, "SELECT user_id, program FROM orders WHERE number = $1", number)
 
-	var userID string
-	err := row.Scan(&userID)
+	owner := &order.Owner{}
+	err := row.Scan(&owner.UserID, &owner.Program)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", false, nil
+			return nil, false, nil
 		}
 
-		return "", false, fmt.Errorf("query error: %w", err)
+		return nil, false, fmt.Errorf("query error: %w", err)
 	}
 
-	return userID, true, nil
+	return owner, true, nil
 }
 
-func (d *dbRepo) List(ctx context.Context, userID string) ([]*order.Order, error) {
+func (d *dbRepo) List(ctx context.Context, userID string, filter *order.ListFilter) ([]*order.Order, error) {
+	statuses := make([]string, 0, len(filter.Statuses))
+	for _, status := range filter.Statuses {
+		statuses = append(statuses, status.String())
+	}
+
+	var afterTime sql.NullTime
+	var afterNumber sql.NullString
+	if filter.After != nil {
+		afterTime = sql.NullTime{Time: filter.After.Time, Valid: true}
+		afterNumber = sql.NullString{String: filter.After.Key, Valid: true}
+	}
+
 	rows, err := d.db.QueryContext(
 		ctx,
-		`SELECT number, status, accrual, updated_at FROM orders WHERE user_id = $1`,
+		`SELECT number, program, status, accrual, uploaded_at FROM orders
+WHERE user_id = $1
+	AND (cardinality($2::text[]) = 0 OR status::text = ANY($2))
+	AND ($3::timestamptz IS NULL OR uploaded_at >= $3)
+	AND ($4::timestamptz IS NULL OR uploaded_at < $4)
+	AND ($5::timestamp IS NULL OR (uploaded_at, number) < ($5, $6))
+ORDER BY uploaded_at DESC, number DESC
+LIMIT $7`,
 		userID,
+		statuses,
+		sql.NullTime{Time: filter.From, Valid: !filter.From.IsZero()},
+		sql.NullTime{Time: filter.To, Valid: !filter.To.IsZero()},
+		afterTime,
+		afterNumber,
+		filter.Limit,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("query error: %w", err)
@@ -98,18 +136,20 @@ func (d *dbRepo) List(ctx context.Context, userID string) ([]*order.Order, error
 	result := make([]*order.Order, 0)
 	for rows.Next() {
 		var number string
+		var program string
 		var status string
 		var accrual sql.NullInt64
-		var updatedAt time.Time
+		var uploadedAt time.Time
 
-		if err := rows.Scan(&number, &status, &accrual, &updatedAt); err != nil {
+		if err := rows.Scan(&number, &program, &status, &accrual, &uploadedAt); err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
 
 		singleResult := &order.Order{
 			Number:     number,
+			Program:    program,
 			Status:     order.Status(status),
-			UploadedAt: updatedAt,
+			UploadedAt: uploadedAt,
 		}
 		if accrual.Valid {
 			singleResult.Accrual = &accrual.Int64
@@ -124,3 +164,134 @@ func (d *dbRepo) List(ctx context.Context, userID string) ([]*order.Order, error
 
 	return result, nil
 }
+
+func (d *dbRepo) ClaimForVerification(ctx context.Context, window time.Duration, period time.Duration, limit int) ([]string, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(
+		localCtx,
+		`UPDATE orders SET verified_at = now()
+WHERE number IN (
+	SELECT number FROM orders
+	WHERE status = $1
+		AND updated_at > now() - $2 * interval '1 millisecond'
+		AND (verified_at IS NULL OR verified_at <= now() - $3 * interval '1 millisecond')
+	ORDER BY verified_at NULLS FIRST
+	LIMIT $4
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING number`,
+		order.StatusProcessed,
+		window.Milliseconds(),
+		period.Milliseconds(),
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		result = append(result, number)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (d *dbRepo) Correct(ctx context.Context, number string, status order.Status, accrual *int64, tx transaction.Transaction) (*order.Correction, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row, err := internal.QueryRowContext(
+		localCtx,
+		d.db,
+		tx,
+		"SELECT user_id, program, status, accrual FROM orders WHERE number = $1 FOR UPDATE",
+		number,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	c := &order.Correction{
+		Number:     number,
+		NewStatus:  status,
+		NewAccrual: accrual,
+	}
+
+	var oldStatus string
+	var oldAccrual sql.NullInt64
+	err = row.Scan(&c.UserID, &c.Program, &oldStatus, &oldAccrual)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	c.OldStatus = order.Status(oldStatus)
+	if oldAccrual.Valid {
+		c.OldAccrual = &oldAccrual.Int64
+	}
+
+	if c.OldStatus != order.StatusProcessed || (c.NewStatus == c.OldStatus && c.Delta() == 0) {
+		return nil, nil
+	}
+
+	_, err = internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"UPDATE orders SET status = $1, accrual = $2, corrected_at = now() WHERE number = $3",
+		status,
+		sql.NullInt64{Int64: deref(accrual), Valid: accrual != nil},
+		number,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update error: %w", err)
+	}
+
+	row, err = internal.QueryRowContext(
+		localCtx,
+		d.db,
+		tx,
+		`INSERT INTO order_corrections (order_number, old_status, old_accrual, new_status, new_accrual)
+VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		number,
+		oldStatus,
+		oldAccrual,
+		status,
+		sql.NullInt64{Int64: deref(accrual), Valid: accrual != nil},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert error: %w", err)
+	}
+
+	err = row.Scan(&c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("insert error: %w", err)
+	}
+
+	return c, nil
+}
+
+func deref(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
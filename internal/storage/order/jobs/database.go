@@ -0,0 +1,174 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) order.AccrualJobRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Add(ctx context.Context, number string, status order.Status, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"INSERT INTO accrual_jobs (order_number, known_status) VALUES ($1, $2)",
+		number,
+		string(status),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return order.ErrAlreadyEnqueued
+		}
+
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]*order.AccrualJob, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// skip locked rows so concurrent claims never return the same job
+	rows, err := d.db.QueryContext(
+		localCtx,
+		`UPDATE accrual_jobs
+SET claim_token = gen_random_uuid(), next_attempt_at = now() + $2 * interval '1 millisecond', updated_at = now()
+WHERE order_number IN (
+	SELECT order_number FROM accrual_jobs
+	WHERE next_attempt_at <= now()
+	ORDER BY next_attempt_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING order_number, known_status, known_accrual, attempts, claim_token`,
+		limit,
+		lease.Milliseconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]*order.AccrualJob, 0)
+	for rows.Next() {
+		job := &order.AccrualJob{}
+		var status string
+		var accrual sql.NullInt64
+
+		if err := rows.Scan(&job.Number, &status, &accrual, &job.Attempts, &job.ClaimToken); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		job.KnownStatus = order.Status(status)
+		if accrual.Valid {
+			job.KnownAccrual = &accrual.Int64
+		}
+
+		result = append(result, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (d *dbRepo) Reschedule(ctx context.Context, job *order.AccrualJob, after time.Duration, lastError string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	var lastErrorArg sql.NullString
+	if lastError != "" {
+		lastErrorArg = sql.NullString{String: lastError, Valid: true}
+	}
+
+	var knownAccrualArg sql.NullInt64
+	if job.KnownAccrual != nil {
+		knownAccrualArg = sql.NullInt64{Int64: *job.KnownAccrual, Valid: true}
+	}
+
+	res, err := d.db.ExecContext(
+		localCtx,
+		`UPDATE accrual_jobs
+SET known_status = $1, known_accrual = $2, attempts = $3, next_attempt_at = now() + $4 * interval '1 millisecond', last_error = $5, updated_at = now()
+WHERE order_number = $6 AND claim_token = $7`,
+		string(job.KnownStatus),
+		knownAccrualArg,
+		job.Attempts,
+		after.Milliseconds(),
+		lastErrorArg,
+		job.Number,
+		job.ClaimToken,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return checkClaimed(res)
+}
+
+func (d *dbRepo) Delete(ctx context.Context, job *order.AccrualJob, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	res, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"DELETE FROM accrual_jobs WHERE order_number = $1 AND claim_token = $2",
+		job.Number,
+		job.ClaimToken,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return checkClaimed(res)
+}
+
+func checkClaimed(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	if affected == 0 {
+		return order.ErrJobNotClaimed
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return true
+	}
+
+	return false
+}
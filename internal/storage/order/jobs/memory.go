@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewMemoryRepository() order.AccrualJobRepository {
+	return &memoryRepo{
+		storage: make(map[string]*value),
+	}
+}
+
+type memoryRepo struct {
+	// poller workers access repo concurrently
+	mutex   sync.Mutex
+	storage map[string]*value
+}
+
+type value struct {
+	job           order.AccrualJob
+	nextAttemptAt time.Time
+	lastError     string
+}
+
+func (m *memoryRepo) Add(_ context.Context, number string, status order.Status, _ transaction.Transaction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.storage[number]; exists {
+		return order.ErrAlreadyEnqueued
+	}
+
+	m.storage[number] = &value{
+		job: order.AccrualJob{
+			Number:      number,
+			KnownStatus: status,
+		},
+		nextAttemptAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) Claim(_ context.Context, limit int, lease time.Duration) ([]*order.AccrualJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	result := make([]*order.AccrualJob, 0)
+
+	for _, v := range m.storage {
+		if len(result) >= limit {
+			break
+		}
+
+		if v.nextAttemptAt.After(now) {
+			continue
+		}
+
+		v.nextAttemptAt = now.Add(lease)
+		v.job.ClaimToken = uuid.New().String()
+
+		job := v.job
+		result = append(result, &job)
+	}
+
+	return result, nil
+}
+
+func (m *memoryRepo) Reschedule(_ context.Context, job *order.AccrualJob, after time.Duration, lastError string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v, ok := m.storage[job.Number]
+	if !ok || v.job.ClaimToken != job.ClaimToken {
+		return order.ErrJobNotClaimed
+	}
+
+	v.job = *job
+	v.nextAttemptAt = time.Now().Add(after)
+	v.lastError = lastError
+
+	return nil
+}
+
+func (m *memoryRepo) Delete(_ context.Context, job *order.AccrualJob, _ transaction.Transaction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v, ok := m.storage[job.Number]
+	if !ok || v.job.ClaimToken != job.ClaimToken {
+		return order.ErrJobNotClaimed
+	}
+
+	delete(m.storage, job.Number)
+
+	return nil
+}
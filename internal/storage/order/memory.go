@@ -2,20 +2,27 @@ package order
 
 import (
 	"context"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
 type memoryRepo struct {
-	storage map[string]*value
+	storage        map[string]*value
+	lastCorrection int64
 }
 
 type value struct {
 	userID     string
+	program    string
 	status     order.Status
 	accrual    *int64
 	uploadedAt time.Time
+	updatedAt  time.Time
+	verifiedAt time.Time
 }
 
 func NewInMemoryRepository() order.Repository {
@@ -24,9 +31,10 @@ func NewInMemoryRepository() order.Repository {
 	}
 }
 
-func (m *memoryRepo) Add(_ context.Context, userID string, number string, status order.Status) error {
+func (m *memoryRepo) Add(_ context.Context, userID string, number string, program string, status order.Status, _ transaction.Transaction) error {
 	m.storage[number] = &value{
 		userID:     userID,
+		program:    program,
 		status:     status,
 		uploadedAt: time.Now(),
 	}
@@ -34,44 +42,123 @@ func (m *memoryRepo) Add(_ context.Context, userID string, number string, status
 	return nil
 }
 
-func (m *memoryRepo) Update(_ context.Context, number string, status order.Status, accrual *int64) error {
+func (m *memoryRepo) Update(_ context.Context, number string, status order.Status, accrual *int64, _ transaction.Transaction) (bool, error) {
 	value, ok := m.storage[number]
-	if !ok {
-		return nil
+	if !ok || value.status.IsFinal() {
+		return false, nil
 	}
 
 	value.status = status
+	value.updatedAt = time.Now()
 	if accrual != nil {
 		value.accrual = accrual
 	}
 
-	return nil
+	return true, nil
 }
 
-func (m *memoryRepo) GetOwner(_ context.Context, number string) (string, bool, error) {
+func (m *memoryRepo) GetOwner(_ context.Context, number string) (*order.Owner, bool, error) {
 	value, ok := m.storage[number]
 	if !ok {
-		return "", false, nil
+		return nil, false, nil
 	}
 
-	return value.userID, true, nil
+	return &order.Owner{UserID: value.userID, Program: value.program}, true, nil
 }
 
-func (m *memoryRepo) List(_ context.Context, userID string) ([]*order.Order, error) {
+func (m *memoryRepo) List(_ context.Context, userID string, filter *order.ListFilter) ([]*order.Order, error) {
 	result := make([]*order.Order, 0)
 
 	for number, value := range m.storage {
-		if value.userID != userID {
+		if value.userID != userID || !matches(number, value, filter) {
 			continue
 		}
 
 		result = append(result, &order.Order{
 			Number:     number,
+			Program:    value.program,
 			Status:     value.status,
 			Accrual:    value.accrual,
 			UploadedAt: value.uploadedAt,
 		})
 	}
 
+	slices.SortFunc(result, func(a, b *order.Order) int {
+		if c := b.UploadedAt.Compare(a.UploadedAt); c != 0 {
+			return c
+		}
+
+		return strings.Compare(b.Number, a.Number)
+	})
+
+	if len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+
 	return result, nil
 }
+
+func matches(number string, value *value, filter *order.ListFilter) bool {
+	if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, value.status) {
+		return false
+	}
+
+	if !filter.From.IsZero() && value.uploadedAt.Before(filter.From) {
+		return false
+	}
+
+	if !filter.To.IsZero() && !value.uploadedAt.Before(filter.To) {
+		return false
+	}
+
+	return filter.After == nil || filter.After.After(value.uploadedAt, number)
+}
+
+func (m *memoryRepo) ClaimForVerification(_ context.Context, window time.Duration, period time.Duration, limit int) ([]string, error) {
+	result := make([]string, 0)
+	now := time.Now()
+
+	for number, value := range m.storage {
+		if len(result) == limit {
+			break
+		}
+
+		if value.status != order.StatusProcessed || value.updatedAt.Before(now.Add(-window)) || value.verifiedAt.After(now.Add(-period)) {
+			continue
+		}
+
+		value.verifiedAt = now
+		result = append(result, number)
+	}
+
+	return result, nil
+}
+
+func (m *memoryRepo) Correct(_ context.Context, number string, status order.Status, accrual *int64, _ transaction.Transaction) (*order.Correction, error) {
+	value, ok := m.storage[number]
+	if !ok || value.status != order.StatusProcessed {
+		return nil, nil
+	}
+
+	c := &order.Correction{
+		Number:     number,
+		UserID:     value.userID,
+		Program:    value.program,
+		OldStatus:  value.status,
+		OldAccrual: value.accrual,
+		NewStatus:  status,
+		NewAccrual: accrual,
+	}
+
+	if c.NewStatus == c.OldStatus && c.Delta() == 0 {
+		return nil, nil
+	}
+
+	m.lastCorrection++
+	c.ID = m.lastCorrection
+
+	value.status = status
+	value.accrual = accrual
+
+	return c, nil
+}
@@ -0,0 +1,106 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) throttle.Repository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) RecordFailure(ctx context.Context, key string, now time.Time, resetBefore time.Time) (int, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// times are stored in UTC, the upsert is atomic, so concurrent failures on different replicas are all counted
+	row := d.db.QueryRowContext(
+		localCtx,
+		`INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 1, $2)
+ON CONFLICT (key) DO UPDATE SET
+	failures = CASE WHEN login_throttles.last_failure_at < $3 THEN 1 ELSE login_throttles.failures + 1 END,
+	last_failure_at = EXCLUDED.last_failure_at
+RETURNING failures`,
+		key,
+		now.UTC(),
+		resetBefore.UTC(),
+	)
+
+	var failures int
+	err := row.Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (d *dbRepo) Release(ctx context.Context, key string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(localCtx, "UPDATE login_throttles SET failures = failures - 1 WHERE key = $1 AND failures > 0", key)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Block(ctx context.Context, key string, until time.Time) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(
+		localCtx,
+		"UPDATE login_throttles SET blocked_until = GREATEST(blocked_until, $2) WHERE key = $1",
+		key,
+		until.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) BlockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(
+		localCtx,
+		"SELECT MAX(blocked_until) FROM login_throttles WHERE key = ANY($1)",
+		keys,
+	)
+
+	var until sql.NullTime
+	err := row.Scan(&until)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query error: %w", err)
+	}
+
+	// zero if none of the keys is known
+	return until.Time, nil
+}
+
+func (d *dbRepo) Reset(ctx context.Context, key string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(localCtx, "DELETE FROM login_throttles WHERE key = $1", key)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
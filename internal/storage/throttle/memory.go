@@ -0,0 +1,89 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
+)
+
+func NewMemoryRepository() throttle.Repository {
+	return &memoryRepo{counters: make(map[string]*counter)}
+}
+
+type memoryRepo struct {
+	mutex    sync.Mutex
+	counters map[string]*counter
+}
+
+type counter struct {
+	failures      int
+	lastFailureAt time.Time
+	blockedUntil  time.Time
+}
+
+func (m *memoryRepo) RecordFailure(_ context.Context, key string, now time.Time, resetBefore time.Time) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c, ok := m.counters[key]
+	if !ok {
+		c = &counter{}
+		m.counters[key] = c
+	}
+
+	if c.lastFailureAt.Before(resetBefore) {
+		c.failures = 0
+	}
+
+	c.failures++
+	c.lastFailureAt = now
+
+	return c.failures, nil
+}
+
+func (m *memoryRepo) Release(_ context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if c, ok := m.counters[key]; ok && c.failures > 0 {
+		c.failures--
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) Block(_ context.Context, key string, until time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if c, ok := m.counters[key]; ok && until.After(c.blockedUntil) {
+		c.blockedUntil = until
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) BlockedUntil(_ context.Context, keys []string) (time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var until time.Time
+	for _, key := range keys {
+		if c, ok := m.counters[key]; ok && c.blockedUntil.After(until) {
+			until = c.blockedUntil
+		}
+	}
+
+	return until, nil
+}
+
+func (m *memoryRepo) Reset(_ context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.counters, key)
+
+	return nil
+}
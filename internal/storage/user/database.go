@@ -42,7 +42,7 @@ func (d *dbRepo) Find(ctx context.Context, login string) (string, string, bool,
 	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
 	defer cancel()
 
-	row := d.db.QueryRowContext(localCtx, "SELECT id, password_hash FROM users WHERE login = $1", login)
+	row := d.db.QueryRowContext(localCtx, "SELECT id, password_hash FROM users WHERE lower(login) = lower($1)", login)
 
 	var userID string
 	var hash string
@@ -58,6 +58,54 @@ func (d *dbRepo) Find(ctx context.Context, login string) (string, string, bool,
 	return userID, hash, true, nil
 }
 
+func (d *dbRepo) Get(ctx context.Context, userID string) (*user.User, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(localCtx, "SELECT id, login, role, created_at FROM users WHERE id = $1", userID)
+
+	u := new(user.User)
+	err := row.Scan(&u.ID, &u.Login, &u.Role, &u.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	return u, nil
+}
+
+func (d *dbRepo) SetRole(ctx context.Context, userID string, role user.Role) (bool, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	result, err := d.db.ExecContext(localCtx, "UPDATE users SET role = $1 WHERE id = $2", role, userID)
+	if err != nil {
+		return false, fmt.Errorf("query error: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (d *dbRepo) UpdatePasswordHash(ctx context.Context, userID string, passwordHash string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(localCtx, "UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
 func isUniqueViolation(err error) bool {
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
@@ -0,0 +1,124 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) user.SigningKeyRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+const pemType = "PRIVATE KEY"
+
+func (d *dbRepo) Add(ctx context.Context, key *user.SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("cant marshal private key: %w", err)
+	}
+
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// times are stored in UTC
+	_, err = d.db.ExecContext(
+		localCtx,
+		"INSERT INTO signing_keys (id, algorithm, private_key, activates_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		key.ID,
+		key.Algorithm.String(),
+		string(pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der})),
+		key.ActivatesAt.UTC(),
+		key.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) List(ctx context.Context, notExpiredBefore time.Time) ([]*user.SigningKey, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(
+		localCtx,
+		"SELECT id, algorithm, private_key, activates_at, expires_at FROM signing_keys WHERE expires_at > $1 ORDER BY activates_at",
+		notExpiredBefore.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]*user.SigningKey, 0)
+	for rows.Next() {
+		key := new(user.SigningKey)
+		var algorithm string
+		var privateKey string
+
+		if err := rows.Scan(&key.ID, &algorithm, &privateKey, &key.ActivatesAt, &key.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		key.Algorithm = user.SigningAlgorithm(algorithm)
+
+		key.PrivateKey, err = parsePrivateKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("cant parse key %s: %w", key.ID, err)
+		}
+
+		result = append(result, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (d *dbRepo) DeleteExpired(ctx context.Context, before time.Time) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(localCtx, "DELETE FROM signing_keys WHERE expires_at <= $1", before.UTC())
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func parsePrivateKey(encoded string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil || block.Type != pemType {
+		return nil, errors.New("invalid PEM block")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cant parse PKCS8: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("key cant sign")
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,57 @@
+package keys
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewMemoryRepository() user.SigningKeyRepository {
+	return &memoryRepo{}
+}
+
+type memoryRepo struct {
+	mutex   sync.Mutex
+	storage []*user.SigningKey
+}
+
+func (m *memoryRepo) Add(_ context.Context, key *user.SigningKey) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.storage = append(m.storage, key)
+
+	return nil
+}
+
+func (m *memoryRepo) List(_ context.Context, notExpiredBefore time.Time) ([]*user.SigningKey, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result := make([]*user.SigningKey, 0)
+	for _, key := range m.storage {
+		if key.ExpiresAt.After(notExpiredBefore) {
+			result = append(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *memoryRepo) DeleteExpired(_ context.Context, before time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	kept := m.storage[:0]
+	for _, key := range m.storage {
+		if key.ExpiresAt.After(before) {
+			kept = append(kept, key)
+		}
+	}
+
+	m.storage = kept
+
+	return nil
+}
@@ -2,6 +2,8 @@ package user
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -9,12 +11,16 @@ import (
 )
 
 type memoryRepo struct {
+	// users by lower case login
 	storage map[string]*value
 }
 
 type value struct {
-	id   string
-	hash string
+	id        string
+	login     string
+	hash      string
+	role      user.Role
+	createdAt time.Time
 }
 
 func NewInMemoryRepository() user.Repository {
@@ -24,23 +30,63 @@ func NewInMemoryRepository() user.Repository {
 }
 
 func (d *memoryRepo) Add(_ context.Context, login string, passwordHash string) error {
-	if _, exists := d.storage[login]; exists {
+	if _, exists := d.storage[strings.ToLower(login)]; exists {
 		return user.ErrLoginNotUnique
 	}
 
-	d.storage[login] = &value{
-		id:   uuid.New().String(),
-		hash: passwordHash,
+	d.storage[strings.ToLower(login)] = &value{
+		id:        uuid.New().String(),
+		login:     login,
+		hash:      passwordHash,
+		role:      user.RoleUser,
+		createdAt: time.Now(),
 	}
 
 	return nil
 }
 
 func (d *memoryRepo) Find(_ context.Context, login string) (string, string, bool, error) {
-	value, ok := d.storage[login]
+	value, ok := d.storage[strings.ToLower(login)]
 	if !ok {
 		return "", "", false, nil
 	}
 
 	return value.id, value.hash, true, nil
 }
+
+func (d *memoryRepo) Get(_ context.Context, userID string) (*user.User, error) {
+	for _, value := range d.storage {
+		if value.id == userID {
+			return &user.User{
+				ID:        value.id,
+				Login:     value.login,
+				Role:      value.role,
+				CreatedAt: value.createdAt,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (d *memoryRepo) SetRole(_ context.Context, userID string, role user.Role) (bool, error) {
+	for _, value := range d.storage {
+		if value.id == userID {
+			value.role = role
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (d *memoryRepo) UpdatePasswordHash(_ context.Context, userID string, passwordHash string) error {
+	for _, value := range d.storage {
+		if value.id == userID {
+			value.hash = passwordHash
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) user.MFARepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Get(ctx context.Context, userID string) (*user.MFA, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(localCtx, "SELECT secret, enabled_at IS NOT NULL FROM user_mfa WHERE user_id = $1", userID)
+
+	result := new(user.MFA)
+	err := row.Scan(&result.Secret, &result.Enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (d *dbRepo) Enroll(ctx context.Context, userID string, secret string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// enabled MFA is not replaced
+	_, err := d.db.ExecContext(
+		localCtx,
+		`INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0 WHERE user_mfa.enabled_at IS NULL`,
+		userID,
+		secret,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Enable(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// a single statement is atomic, new codes are random, so they never collide with the deleted ones
+	_, err := d.db.ExecContext(
+		localCtx,
+		`WITH enabled AS (
+	UPDATE user_mfa SET enabled_at = now() WHERE user_id = $1
+), deleted AS (
+	DELETE FROM mfa_recovery_codes WHERE user_id = $1
+)
+INSERT INTO mfa_recovery_codes (code_hash, user_id) SELECT unnest($2::text[]), $1`,
+		userID,
+		recoveryCodeHashes,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Disable(ctx context.Context, userID string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// recovery codes are deleted by the cascade
+	_, err := d.db.ExecContext(localCtx, "DELETE FROM user_mfa WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	result, err := d.db.ExecContext(
+		localCtx,
+		"UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2",
+		userID,
+		step,
+	)
+	if err != nil {
+		return false, fmt.Errorf("query error: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (d *dbRepo) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	result, err := d.db.ExecContext(
+		localCtx,
+		"UPDATE mfa_recovery_codes SET used_at = now() WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL",
+		codeHash,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("query error: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (d *dbRepo) AddChallenge(ctx context.Context, userID string, challengeHash string, expiresAt time.Time) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(
+		localCtx,
+		"INSERT INTO mfa_challenges (challenge_hash, user_id, expires_at) VALUES ($1, $2, $3::timestamptz)",
+		challengeHash,
+		userID,
+		expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) AttemptChallenge(ctx context.Context, challengeHash string) (*user.Challenge, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(
+		localCtx,
+		`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE challenge_hash = $1
+RETURNING user_id, expires_at <= now(), attempts`,
+		challengeHash,
+	)
+
+	result := new(user.Challenge)
+	err := row.Scan(&result.UserID, &result.Expired, &result.Attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, user.ErrChallengeNotFound
+		}
+
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (d *dbRepo) DeleteChallenge(ctx context.Context, challengeHash string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(localCtx, "DELETE FROM mfa_challenges WHERE challenge_hash = $1", challengeHash)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package mfa
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewMemoryRepository() user.MFARepository {
+	return &memoryRepo{
+		mfa:        make(map[string]*mfa),
+		challenges: make(map[string]*challenge),
+	}
+}
+
+type memoryRepo struct {
+	mutex sync.Mutex
+	// MFA by user ID
+	mfa map[string]*mfa
+	// challenges by hash
+	challenges map[string]*challenge
+}
+
+type mfa struct {
+	secret       string
+	enabled      bool
+	lastUsedStep int64
+	// used flags by code hash
+	recoveryCodes map[string]bool
+}
+
+type challenge struct {
+	userID    string
+	expiresAt time.Time
+	attempts  int
+}
+
+func (m *memoryRepo) Get(_ context.Context, userID string) (*user.MFA, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	value, ok := m.mfa[userID]
+	if !ok {
+		return nil, nil
+	}
+
+	return &user.MFA{Secret: value.secret, Enabled: value.enabled}, nil
+}
+
+func (m *memoryRepo) Enroll(_ context.Context, userID string, secret string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if value, ok := m.mfa[userID]; ok && value.enabled {
+		return nil
+	}
+
+	m.mfa[userID] = &mfa{secret: secret}
+
+	return nil
+}
+
+func (m *memoryRepo) Enable(_ context.Context, userID string, recoveryCodeHashes []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	value, ok := m.mfa[userID]
+	if !ok {
+		return nil
+	}
+
+	value.enabled = true
+	value.recoveryCodes = make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		value.recoveryCodes[hash] = false
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) Disable(_ context.Context, userID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.mfa, userID)
+
+	return nil
+}
+
+func (m *memoryRepo) UseStep(_ context.Context, userID string, step int64) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	value, ok := m.mfa[userID]
+	if !ok || step <= value.lastUsedStep {
+		return false, nil
+	}
+
+	value.lastUsedStep = step
+
+	return true, nil
+}
+
+func (m *memoryRepo) UseRecoveryCode(_ context.Context, userID string, codeHash string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	value, ok := m.mfa[userID]
+	if !ok {
+		return false, nil
+	}
+
+	used, exists := value.recoveryCodes[codeHash]
+	if !exists || used {
+		return false, nil
+	}
+
+	value.recoveryCodes[codeHash] = true
+
+	return true, nil
+}
+
+func (m *memoryRepo) AddChallenge(_ context.Context, userID string, challengeHash string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.challenges[challengeHash] = &challenge{userID: userID, expiresAt: expiresAt}
+
+	return nil
+}
+
+func (m *memoryRepo) AttemptChallenge(_ context.Context, challengeHash string) (*user.Challenge, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	value, ok := m.challenges[challengeHash]
+	if !ok {
+		return nil, user.ErrChallengeNotFound
+	}
+
+	value.attempts++
+
+	return &user.Challenge{
+		UserID:   value.userID,
+		Expired:  !value.expiresAt.After(time.Now()),
+		Attempts: value.attempts,
+	}, nil
+}
+
+func (m *memoryRepo) DeleteChallenge(_ context.Context, challengeHash string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.challenges, challengeHash)
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package resets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) user.ResetTokenRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Add(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(
+		localCtx,
+		"INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3::timestamptz)",
+		tokenHash,
+		userID,
+		expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) Get(ctx context.Context, tokenHash string) (*user.ResetToken, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(
+		localCtx,
+		"SELECT user_id, expires_at <= now(), used_at IS NOT NULL FROM password_reset_tokens WHERE token_hash = $1",
+		tokenHash,
+	)
+
+	token := new(user.ResetToken)
+	err := row.Scan(&token.UserID, &token.Expired, &token.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, user.ErrResetTokenNotFound
+		}
+
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	return token, nil
+}
+
+func (d *dbRepo) Use(ctx context.Context, tokenHash string) (*user.ResetToken, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// concurrent uses of the same token are serialized by the row lock, so only one of them sees it unused
+	row := d.db.QueryRowContext(
+		localCtx,
+		`UPDATE password_reset_tokens SET used_at = now()
+WHERE token_hash = $1 AND used_at IS NULL
+RETURNING user_id, expires_at <= now()`,
+		tokenHash,
+	)
+
+	token := new(user.ResetToken)
+	err := row.Scan(&token.UserID, &token.Expired)
+	if err == nil {
+		return token, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	row = d.db.QueryRowContext(
+		localCtx,
+		"SELECT user_id, expires_at <= now() FROM password_reset_tokens WHERE token_hash = $1",
+		tokenHash,
+	)
+
+	err = row.Scan(&token.UserID, &token.Expired)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, user.ErrResetTokenNotFound
+		}
+
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	token.Used = true
+
+	return token, nil
+}
@@ -0,0 +1,73 @@
+package resets
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewMemoryRepository() user.ResetTokenRepository {
+	return &memoryRepo{tokens: make(map[string]*resetToken)}
+}
+
+type memoryRepo struct {
+	mutex sync.Mutex
+	// reset tokens by hash
+	tokens map[string]*resetToken
+}
+
+type resetToken struct {
+	userID    string
+	expiresAt time.Time
+	used      bool
+}
+
+func (m *memoryRepo) Add(_ context.Context, userID string, tokenHash string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.tokens[tokenHash] = &resetToken{
+		userID:    userID,
+		expiresAt: expiresAt,
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) Get(_ context.Context, tokenHash string) (*user.ResetToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, ok := m.tokens[tokenHash]
+	if !ok {
+		return nil, user.ErrResetTokenNotFound
+	}
+
+	return &user.ResetToken{
+		UserID:  token.userID,
+		Expired: !token.expiresAt.After(time.Now()),
+		Used:    token.used,
+	}, nil
+}
+
+func (m *memoryRepo) Use(_ context.Context, tokenHash string) (*user.ResetToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, ok := m.tokens[tokenHash]
+	if !ok {
+		return nil, user.ErrResetTokenNotFound
+	}
+
+	result := &user.ResetToken{
+		UserID:  token.userID,
+		Expired: !token.expiresAt.After(time.Now()),
+		Used:    token.used,
+	}
+
+	token.used = true
+
+	return result, nil
+}
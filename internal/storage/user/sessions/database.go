@@ -0,0 +1,150 @@
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) user.SessionRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Create(ctx context.Context, userID string) (string, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(localCtx, "INSERT INTO user_sessions (user_id) VALUES ($1) RETURNING id", userID)
+
+	var id string
+	err := row.Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("query error: %w", err)
+	}
+
+	return id, nil
+}
+
+func (d *dbRepo) IsActive(ctx context.Context, sessionID string) (bool, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	row := d.db.QueryRowContext(
+		localCtx,
+		"SELECT EXISTS (SELECT 1 FROM user_sessions WHERE id = $1 AND revoked_at IS NULL)",
+		sessionID,
+	)
+
+	var active bool
+	err := row.Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("query error: %w", err)
+	}
+
+	return active, nil
+}
+
+func (d *dbRepo) Revoke(ctx context.Context, sessionID string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(
+		localCtx,
+		"UPDATE user_sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL",
+		sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) RevokeAll(ctx context.Context, userID string) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(
+		localCtx,
+		"UPDATE user_sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) AddRefreshToken(ctx context.Context, sessionID string, tokenHash string, expiresAt time.Time) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(
+		localCtx,
+		"INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3::timestamptz)",
+		tokenHash,
+		sessionID,
+		expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dbRepo) UseRefreshToken(ctx context.Context, tokenHash string) (*user.RefreshToken, error) {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// concurrent uses of the same token are serialized by the row lock, so only one of them sees it unused
+	row := d.db.QueryRowContext(
+		localCtx,
+		`UPDATE refresh_tokens t SET used_at = now()
+FROM user_sessions s
+WHERE t.token_hash = $1 AND t.used_at IS NULL AND s.id = t.session_id
+RETURNING t.session_id, s.user_id, t.expires_at <= now()`,
+		tokenHash,
+	)
+
+	token := new(user.RefreshToken)
+	err := row.Scan(&token.SessionID, &token.UserID, &token.Expired)
+	if err == nil {
+		return token, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	row = d.db.QueryRowContext(
+		localCtx,
+		`SELECT t.session_id, s.user_id, t.expires_at <= now() FROM refresh_tokens t
+JOIN user_sessions s ON s.id = t.session_id
+WHERE t.token_hash = $1`,
+		tokenHash,
+	)
+
+	err = row.Scan(&token.SessionID, &token.UserID, &token.Expired)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, user.ErrRefreshTokenNotFound
+		}
+
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	token.Used = true
+
+	return token, nil
+}
@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+func NewMemoryRepository() user.SessionRepository {
+	return &memoryRepo{
+		sessions: make(map[string]*session),
+		tokens:   make(map[string]*refreshToken),
+	}
+}
+
+type memoryRepo struct {
+	// every authenticated request checks the session
+	mutex    sync.Mutex
+	sessions map[string]*session
+	// refresh tokens by hash
+	tokens map[string]*refreshToken
+}
+
+type refreshToken struct {
+	sessionID string
+	expiresAt time.Time
+	used      bool
+}
+
+type session struct {
+	userID  string
+	revoked bool
+}
+
+func (m *memoryRepo) Create(_ context.Context, userID string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	id := uuid.New().String()
+	m.sessions[id] = &session{userID: userID}
+
+	return id, nil
+}
+
+func (m *memoryRepo) IsActive(_ context.Context, sessionID string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, ok := m.sessions[sessionID]
+
+	return ok && !s.revoked, nil
+}
+
+func (m *memoryRepo) Revoke(_ context.Context, sessionID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if s, ok := m.sessions[sessionID]; ok {
+		s.revoked = true
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) RevokeAll(_ context.Context, userID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, s := range m.sessions {
+		if s.userID == userID {
+			s.revoked = true
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) AddRefreshToken(_ context.Context, sessionID string, tokenHash string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.tokens[tokenHash] = &refreshToken{
+		sessionID: sessionID,
+		expiresAt: expiresAt,
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) UseRefreshToken(_ context.Context, tokenHash string) (*user.RefreshToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, ok := m.tokens[tokenHash]
+	if !ok {
+		return nil, user.ErrRefreshTokenNotFound
+	}
+
+	result := &user.RefreshToken{
+		SessionID: token.sessionID,
+		UserID:    m.sessions[token.sessionID].userID,
+		Expired:   !token.expiresAt.After(time.Now()),
+		Used:      token.used,
+	}
+
+	token.used = true
+
+	return result, nil
+}
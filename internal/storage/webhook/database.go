@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/webhook"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewDatabaseRepository(db *sql.DB, timeout time.Duration) webhook.NonceRepository {
+	return &dbRepo{db: db, timeout: timeout}
+}
+
+type dbRepo struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (d *dbRepo) Use(ctx context.Context, nonce string, expiresAt time.Time, now time.Time, tx transaction.Transaction) error {
+	localCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// expired nonces are forgotten first, so they don't conflict with a reused one.
+	// It is done outside of the transaction, so concurrent requests don't wait for each other
+	_, err := d.db.ExecContext(localCtx, "DELETE FROM webhook_nonces WHERE expires_at < $1", now.UTC())
+	if err != nil {
+		return fmt.Errorf("cant delete expired nonces: %w", err)
+	}
+
+	// the same nonce used concurrently waits until the first transaction ends, and is replayed only if it is committed
+	result, err := internal.ExecContext(
+		localCtx,
+		d.db,
+		tx,
+		"INSERT INTO webhook_nonces (nonce, expires_at) VALUES ($1, $2) ON CONFLICT (nonce) DO NOTHING",
+		nonce,
+		expiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cant get affected rows: %w", err)
+	}
+
+	if affected == 0 {
+		return webhook.ErrReplayed
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/webhook"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+func NewMemoryRepository() webhook.NonceRepository {
+	return &memoryRepo{nonces: make(map[string]time.Time)}
+}
+
+type memoryRepo struct {
+	mutex  sync.Mutex
+	nonces map[string]time.Time
+}
+
+func (m *memoryRepo) Use(_ context.Context, nonce string, expiresAt time.Time, now time.Time, _ transaction.Transaction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for n, e := range m.nonces {
+		if e.Before(now) {
+			delete(m.nonces, n)
+		}
+	}
+
+	if _, ok := m.nonces[nonce]; ok {
+		return webhook.ErrReplayed
+	}
+
+	m.nonces[nonce] = expiresAt
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -14,15 +15,69 @@ import (
 )
 
 type Config struct {
-	RunAddress            string `env:"RUN_ADDRESS"`
-	DatabaseDSN           string `env:"DATABASE_URI"`
-	DatabaseTimeout       time.Duration
-	AccrualSystemAddress  string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	AccrualMaxRetries     int
-	AccrualMaxRetryPeriod time.Duration
-	AccrualTimeout        time.Duration
-	MinPasswordLength     int
-	TokenExpirationPeriod time.Duration
+	RunAddress             string `env:"RUN_ADDRESS"`
+	DatabaseDSN            string `env:"DATABASE_URI"`
+	DatabaseTimeout        time.Duration
+	AccrualSystemAddress   string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	AccrualMaxRetries      int
+	AccrualMaxRetryPeriod  time.Duration
+	AccrualTimeout         time.Duration
+	AccrualPollInterval    time.Duration
+	MinPasswordLength      int
+	TokenExpirationPeriod  time.Duration
+	ReconciliationInterval time.Duration
+	// AccrualVerificationInterval of re-checking processed orders, verification is disabled if zero
+	AccrualVerificationInterval time.Duration `env:"ACCRUAL_VERIFICATION_INTERVAL"`
+	AccrualVerificationWindow   time.Duration
+	AccrualVerificationPeriod   time.Duration
+	// PointsTTL is the lifetime of accrued points, points never expire if zero
+	PointsTTL                time.Duration `env:"POINTS_TTL"`
+	PointsExpiringSoonWindow time.Duration
+	PointsExpirationInterval time.Duration
+	// LoyaltyPrograms besides the default one with values of their points in rubles, e.g. "brand:0.5,partner:2"
+	LoyaltyPrograms map[string]string `env:"LOYALTY_PROGRAMS"`
+	// PasswordHashAlgorithm of new password hashes, argon2id or bcrypt
+	PasswordHashAlgorithm string `env:"PASSWORD_HASH_ALGORITHM"`
+	// RefreshTokenExpirationPeriod is the lifetime of a refresh token, access tokens live TokenExpirationPeriod
+	RefreshTokenExpirationPeriod time.Duration
+	// TokenSigningAlgorithm of access tokens, EdDSA or RS256
+	TokenSigningAlgorithm    string `env:"TOKEN_SIGNING_ALGORITHM"`
+	SigningKeyRotationPeriod time.Duration
+	SigningKeyReloadInterval time.Duration
+	// LoginLockoutThreshold of failed logins in a row, after which the account is locked for LoginLockoutDuration
+	LoginLockoutThreshold int           `env:"LOGIN_LOCKOUT_THRESHOLD"`
+	LoginLockoutDuration  time.Duration `env:"LOGIN_LOCKOUT_DURATION"`
+	// PasswordResetTokenExpirationPeriod is the lifetime of a token sent to a user, who forgot the password
+	PasswordResetTokenExpirationPeriod time.Duration
+	// NotificationsFile collects notifications to users, they are logged if it is empty
+	NotificationsFile string `env:"NOTIFICATIONS_FILE"`
+	// login and password lengths are counted in characters
+	MinLoginLength    int
+	MaxLoginLength    int
+	MaxPasswordLength int
+	// FoldLoginCase makes logins case-insensitive
+	FoldLoginCase bool `env:"FOLD_LOGIN_CASE"`
+	// PasswordMinCharacterClasses of lower and upper case letters, digits and symbols
+	PasswordMinCharacterClasses int `env:"PASSWORD_MIN_CHARACTER_CLASSES"`
+	// BreachedPasswordsFile replaces the embedded list of common passwords, one password per line
+	BreachedPasswordsFile string `env:"BREACHED_PASSWORDS_FILE"`
+	// MFAIssuer is shown in authenticator apps next to the login
+	MFAIssuer                    string `env:"MFA_ISSUER"`
+	MFAChallengeExpirationPeriod time.Duration
+	// MigrateOnStart applies pending migrations on start. Otherwise they are applied with the migrate command,
+	// and the server refuses to start until the database is migrated
+	MigrateOnStart bool `env:"MIGRATE_ON_START"`
+	// AccrualWebhookSecret signs results pushed by the accrual system, pushing is disabled if it is empty
+	AccrualWebhookSecret    string `env:"ACCRUAL_WEBHOOK_SECRET"`
+	AccrualWebhookTolerance time.Duration
+	// MigrationTimeout of applying migrations on start, they may take much longer than queries
+	MigrationTimeout time.Duration `env:"MIGRATION_TIMEOUT"`
+	// ProxyHeader with the client IP set by a reverse proxy, e.g. X-Real-IP. The login lockout counts failures by client IP,
+	// so the header is trusted only from TrustedProxies, and the proxy must overwrite it instead of appending to it.
+	// Remote address is used if it is empty
+	ProxyHeader string `env:"PROXY_HEADER"`
+	// TrustedProxies are IPs and CIDR ranges of reverse proxies, e.g. "10.0.0.0/8,127.0.0.1"
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
 }
 
 func Resolve() (*Config, error) {
@@ -31,12 +86,43 @@ func Resolve() (*Config, error) {
 		DatabaseDSN:          "",
 		AccrualSystemAddress: "",
 		// hardcoded for now
-		AccrualMaxRetries:     10,
-		AccrualMaxRetryPeriod: 5 * time.Minute,
-		AccrualTimeout:        time.Minute,
-		DatabaseTimeout:       5 * time.Second,
-		MinPasswordLength:     12,
-		TokenExpirationPeriod: time.Hour,
+		AccrualMaxRetries:      10,
+		AccrualMaxRetryPeriod:  5 * time.Minute,
+		AccrualTimeout:         time.Minute,
+		AccrualPollInterval:    time.Second,
+		DatabaseTimeout:        5 * time.Second,
+		MinPasswordLength:      12,
+		TokenExpirationPeriod:  15 * time.Minute,
+		ReconciliationInterval: time.Hour,
+		// processed orders are verified daily during a month after processing
+		AccrualVerificationWindow: 30 * 24 * time.Hour,
+		AccrualVerificationPeriod: 24 * time.Hour,
+		// points are reported as expiring soon a month before expiration
+		PointsExpiringSoonWindow: 30 * 24 * time.Hour,
+		PointsExpirationInterval: time.Hour,
+		PasswordHashAlgorithm:    "argon2id",
+		// sessions end after a month of inactivity
+		RefreshTokenExpirationPeriod: 30 * 24 * time.Hour,
+		TokenSigningAlgorithm:        "EdDSA",
+		SigningKeyRotationPeriod:     30 * 24 * time.Hour,
+		SigningKeyReloadInterval:     5 * time.Minute,
+		LoginLockoutThreshold:        10,
+		LoginLockoutDuration:         15 * time.Minute,
+		// reset tokens are delivered outside the service, so they should not stay valid for long
+		PasswordResetTokenExpirationPeriod: time.Hour,
+		// length and the breached list make passwords strong, composition rules mostly make them harder to remember
+		MinLoginLength:              2,
+		MaxLoginLength:              64,
+		MaxPasswordLength:           128,
+		FoldLoginCase:               true,
+		PasswordMinCharacterClasses: 1,
+		MFAIssuer:                   "Gophermart",
+		// enough to open an authenticator app
+		MFAChallengeExpirationPeriod: 5 * time.Minute,
+		MigrateOnStart:               true,
+		// signed requests are accepted within this difference between clocks, and their nonces are kept as long
+		AccrualWebhookTolerance: 5 * time.Minute,
+		MigrationTimeout:        10 * time.Minute,
 	}
 
 	parseFlags(conf)
@@ -54,6 +140,33 @@ func Resolve() (*Config, error) {
 	return conf, nil
 }
 
+// ResolveForCommand resolves only the database connection from args of the command and env, and returns the rest of args
+func ResolveForCommand(command string, args []string) (*Config, []string, error) {
+	conf := &Config{
+		DatabaseDSN: "",
+		// commands like migrations may take much longer than queries
+		DatabaseTimeout: 10 * time.Minute,
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.StringVar(&conf.DatabaseDSN, "d", conf.DatabaseDSN, "Database DSN for PostgreSQL connection")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing flags: %w", err)
+	}
+
+	if dsn, ok := os.LookupEnv("DATABASE_URI"); ok {
+		conf.DatabaseDSN = dsn
+	}
+
+	if conf.DatabaseDSN == "" {
+		return nil, nil, errors.New("database DSN is required")
+	}
+
+	return conf, flags.Args(), nil
+}
+
 func parseFlags(conf *Config) {
 	flag.Func(
 		"a",
@@ -71,7 +184,7 @@ func parseFlags(conf *Config) {
 	)
 	flag.Func(
 		"r",
-		"Base url for accrual system, http(s)://host:port",
+		"Base url for accrual system, http(s)://host:port, or grpc://host:port for its gRPC API",
 		func(u string) error {
 			err := validateAccrualSystemAddress(u)
 			if err != nil {
@@ -105,6 +218,11 @@ func parseEnv(conf *Config) error {
 		}
 	}
 
+	err = validateTrustedProxies(conf.ProxyHeader, conf.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
 	return nil
 }
 
@@ -154,6 +272,30 @@ func validateIP(ipString string) error {
 	return nil
 }
 
+// validateTrustedProxies, that are required with the proxy header, otherwise any client could set its own IP
+func validateTrustedProxies(header string, proxies []string) error {
+	if header != "" && len(proxies) == 0 {
+		return errors.New("trusted proxies are required with the proxy header")
+	}
+
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			_, _, err := net.ParseCIDR(proxy)
+			if err != nil {
+				return fmt.Errorf("could not parse cidr: %w", err)
+			}
+
+			continue
+		}
+
+		if net.ParseIP(proxy) == nil {
+			return fmt.Errorf("could not parse ip: %v", proxy)
+		}
+	}
+
+	return nil
+}
+
 func validatePort(portString string) error {
 	port, err := strconv.Atoi(portString)
 	if err != nil {
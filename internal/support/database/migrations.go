@@ -0,0 +1,397 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is an arbitrary key of advisory lock, that serializes migrations of concurrently started instances
+const migrationLockKey = 7_301_455_102
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrChecksumMismatch = errors.New("applied migration differs from its file")
+var ErrPendingMigrations = errors.New("database has pending migrations")
+
+// Migration is a pair of embedded files, NNNN_name.up.sql and NNNN_name.down.sql. Versions are applied in ascending order
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Checksum of the up file, applied migrations must not change
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Version int
+	Name    string
+	// AppliedAt is zero for pending migrations
+	AppliedAt time.Time
+	// Modified migrations are applied, but their files have changed since
+	Modified bool
+	// Unknown migrations are applied by a newer version of the application
+	Unknown bool
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, fmt.Errorf("could not load migrations: %w", err)
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrate the database to the latest version
+func Migrate(ctx context.Context, db *sql.DB) error {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Up(ctx)
+
+	return err
+}
+
+// CheckMigrations returns ErrPendingMigrations if the database is behind the application
+func CheckMigrations(ctx context.Context, db *sql.DB) error {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		if s.Modified {
+			return fmt.Errorf("migration %d_%s: %w", s.Version, s.Name, ErrChecksumMismatch)
+		}
+
+		if s.AppliedAt.IsZero() {
+			return fmt.Errorf("migration %d_%s: %w", s.Version, s.Name, ErrPendingMigrations)
+		}
+	}
+
+	return nil
+}
+
+// Up applies pending migrations, each in its own transaction, and returns them
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	applied := make([]*Migration, 0)
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			checksum, ok := versions[migration.Version]
+			if ok && checksum != migration.Checksum {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, ErrChecksumMismatch)
+			}
+
+			if ok {
+				continue
+			}
+
+			err = m.apply(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(
+					ctx,
+					"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+					migration.Version,
+					migration.Name,
+					migration.Checksum,
+				)
+
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("could not apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back up to steps latest applied migrations and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	rolledBack := make([]*Migration, 0)
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := m.migrations[i]
+
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			err = m.apply(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("could not roll back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			rolledBack = append(rolledBack, migration)
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Status of all known and applied migrations, ordered by version. It only reads, so it neither waits for running
+// migrations nor creates schema_migrations, all migrations are pending if there is no such table yet
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("could not check schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]*MigrationStatus)
+	checksums := make(map[int]string)
+
+	if exists {
+		rows, err := m.db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+		if err != nil {
+			return nil, fmt.Errorf("could not query schema_migrations: %w", err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			s := &MigrationStatus{}
+			var checksum string
+
+			if err := rows.Scan(&s.Version, &s.Name, &checksum, &s.AppliedAt); err != nil {
+				return nil, fmt.Errorf("scan error: %w", err)
+			}
+
+			applied[s.Version] = s
+			checksums[s.Version] = checksum
+		}
+
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows error: %w", err)
+		}
+	}
+
+	result := make([]*MigrationStatus, 0, len(m.migrations))
+
+	for _, migration := range m.migrations {
+		s, ok := applied[migration.Version]
+		if !ok {
+			result = append(result, &MigrationStatus{Version: migration.Version, Name: migration.Name})
+
+			continue
+		}
+
+		s.Modified = checksums[migration.Version] != migration.Checksum
+		result = append(result, s)
+		delete(applied, migration.Version)
+	}
+
+	for _, s := range applied {
+		s.Unknown = true
+		result = append(result, s)
+	}
+
+	slices.SortFunc(result, func(a, b *MigrationStatus) int {
+		return a.Version - b.Version
+	})
+
+	return result, nil
+}
+
+// withLock runs f on a connection holding the migration lock. Session lock is used, so every migration has its own transaction
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get connection: %w", err)
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+	if err != nil {
+		return fmt.Errorf("could not acquire migration lock: %w", err)
+	}
+
+	defer func() {
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		if err != nil {
+			// session lock is released only when the connection is closed, so it must not return to the pool
+			_ = conn.Raw(func(_ any) error {
+				return driver.ErrBadConn
+			})
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL DEFAULT now()
+)`)
+	if err != nil {
+		return fmt.Errorf("could not create schema_migrations table: %w", err)
+	}
+
+	return f(conn)
+}
+
+// apply the migration script and record it in the same transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return fmt.Errorf("could not execute script: %w", err)
+	}
+
+	err = record(tx)
+	if err != nil {
+		return fmt.Errorf("could not record migration: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("could not commit: %w", err)
+	}
+
+	return nil
+}
+
+// appliedVersions with their checksums
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]string, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("could not query schema_migrations: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		result[version] = checksum
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func loadMigrations(files fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(files, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("could not read %q: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+			hash := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(hash[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	result := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", migration.Version, migration.Name)
+		}
+
+		result = append(result, migration)
+	}
+
+	slices.SortFunc(result, func(a, b *Migration) int {
+		return a.Version - b.Version
+	})
+
+	return result, nil
+}
@@ -0,0 +1,24 @@
+DROP TABLE IF EXISTS
+	balance_adjustments,
+	mfa_challenges,
+	mfa_recovery_codes,
+	user_mfa,
+	password_reset_tokens,
+	login_throttles,
+	signing_keys,
+	refresh_tokens,
+	user_sessions,
+	pending_accruals,
+	secrets,
+	idempotency_keys,
+	point_lot_consumptions,
+	point_lots,
+	ledger,
+	accrual_jobs,
+	withdrawals,
+	order_corrections,
+	orders,
+	balances,
+	users;
+
+DROP TYPE IF EXISTS posting_kind, withdrawal_status, order_status;
@@ -0,0 +1,274 @@
+-- schema, that was created on start before versioned migrations. Statements are idempotent,
+-- so databases created that way are brought to the same state without losing data
+
+CREATE TABLE IF NOT EXISTS users (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	login VARCHAR(250) UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS balances (
+	user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE RESTRICT,
+	current INT NOT NULL DEFAULT 0,
+	withdrawn INT NOT NULL DEFAULT 0
+);
+
+DO $$ BEGIN
+	CREATE TYPE order_status AS ENUM (
+		'NEW',
+		'PROCESSING',
+		'INVALID',
+		'PROCESSED'
+	);
+EXCEPTION
+	WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS orders (
+	number TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	status order_status NOT NULL DEFAULT 'NEW',
+	uploaded_at TIMESTAMP NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP NOT NULL DEFAULT now(),
+	accrual INT DEFAULT NULL
+);
+
+ALTER TABLE orders
+	ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP DEFAULT NULL,
+	ADD COLUMN IF NOT EXISTS corrected_at TIMESTAMP DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS order_corrections (
+	id BIGSERIAL PRIMARY KEY,
+	order_number TEXT NOT NULL REFERENCES orders(number) ON DELETE CASCADE,
+	old_status order_status NOT NULL,
+	old_accrual INT DEFAULT NULL,
+	new_status order_status NOT NULL,
+	new_accrual INT DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_id_uploaded_at_idx ON orders (user_id, uploaded_at, number);
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	order_number TEXT NOT NULL,
+	sum INT NOT NULL DEFAULT 0,
+	processed_at TIMESTAMP NOT NULL DEFAULT now(),
+
+	PRIMARY KEY (user_id, order_number)
+);
+
+DO $$ BEGIN
+	CREATE TYPE withdrawal_status AS ENUM (
+		'COMPLETED',
+		'CANCELLED'
+	);
+EXCEPTION
+	WHEN duplicate_object THEN null;
+END $$;
+
+ALTER TABLE withdrawals
+	ADD COLUMN IF NOT EXISTS status withdrawal_status NOT NULL DEFAULT 'COMPLETED',
+	ADD COLUMN IF NOT EXISTS cancel_reason TEXT DEFAULT NULL,
+	ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS withdrawals_user_id_processed_at_idx ON withdrawals (user_id, processed_at, order_number);
+
+CREATE TABLE IF NOT EXISTS accrual_jobs (
+	order_number TEXT PRIMARY KEY REFERENCES orders(number) ON DELETE CASCADE,
+	known_status order_status NOT NULL DEFAULT 'NEW',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT now(),
+	last_error TEXT DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE accrual_jobs ADD COLUMN IF NOT EXISTS claim_token UUID DEFAULT NULL;
+
+ALTER TABLE accrual_jobs ADD COLUMN IF NOT EXISTS known_accrual BIGINT DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS accrual_jobs_next_attempt_at_idx ON accrual_jobs (next_attempt_at);
+
+-- orders uploaded before the job queue existed. Existing jobs are kept as is, so their attempts are not reset
+INSERT INTO accrual_jobs (order_number, known_status)
+SELECT number, status FROM orders WHERE status IN ('NEW', 'PROCESSING')
+ON CONFLICT (order_number) DO NOTHING;
+
+DO $$ BEGIN
+	CREATE TYPE posting_kind AS ENUM (
+		'ACCRUAL',
+		'WITHDRAWAL',
+		'ADJUSTMENT',
+		'REVERSAL'
+	);
+EXCEPTION
+	WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS ledger (
+	id BIGSERIAL PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	kind posting_kind NOT NULL,
+	amount BIGINT NOT NULL,
+	reference TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+
+	UNIQUE (user_id, kind, reference)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_user_id_created_at_idx ON ledger (user_id, created_at, id);
+
+-- balance changes made before the ledger existed. Every later change is posted in the same transaction
+INSERT INTO ledger (user_id, kind, amount, reference, created_at)
+SELECT user_id, 'ACCRUAL', accrual, number, updated_at FROM orders WHERE status = 'PROCESSED' AND accrual IS NOT NULL
+UNION ALL
+SELECT user_id, 'WITHDRAWAL', -sum, order_number, processed_at FROM withdrawals
+ON CONFLICT (user_id, kind, reference) DO NOTHING;
+
+-- replaced by ledger
+DROP TABLE IF EXISTS accruals;
+
+ALTER TYPE posting_kind ADD VALUE IF NOT EXISTS 'EXPIRATION';
+
+CREATE TABLE IF NOT EXISTS point_lots (
+	id BIGSERIAL PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	amount BIGINT NOT NULL,
+	remaining BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP DEFAULT NULL,
+	expired_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS point_lots_user_id_idx ON point_lots (user_id) WHERE remaining > 0;
+
+CREATE INDEX IF NOT EXISTS point_lots_expires_at_idx ON point_lots (expires_at) WHERE remaining > 0;
+
+CREATE TABLE IF NOT EXISTS point_lot_consumptions (
+	lot_id BIGINT NOT NULL REFERENCES point_lots(id) ON DELETE RESTRICT,
+	reference TEXT NOT NULL,
+	amount BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS point_lot_consumptions_reference_idx ON point_lot_consumptions (reference);
+
+-- points accrued before lots existed never expire
+INSERT INTO point_lots (user_id, amount, remaining)
+SELECT user_id, current, current FROM balances b
+WHERE current > 0 AND NOT EXISTS (SELECT 1 FROM point_lots l WHERE l.user_id = b.user_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+
+	PRIMARY KEY (user_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS secrets (
+	name TEXT NOT NULL PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pending_accruals (
+	order_number TEXT PRIMARY KEY REFERENCES orders(number) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	amount BIGINT DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS pending_accruals_user_id_idx ON pending_accruals (user_id);
+
+-- orders uploaded before accruals were held
+INSERT INTO pending_accruals (order_number, user_id)
+SELECT number, user_id FROM orders WHERE status IN ('NEW', 'PROCESSING')
+ON CONFLICT (order_number) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	revoked_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token_hash TEXT PRIMARY KEY,
+	session_id UUID NOT NULL REFERENCES user_sessions(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_session_id_idx ON refresh_tokens (session_id);
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+	id TEXT PRIMARY KEY,
+	algorithm TEXT NOT NULL,
+	private_key TEXT NOT NULL,
+	activates_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- tokens are not signed with the shared secret anymore
+DELETE FROM secrets WHERE name = 'jwt_secret';
+
+CREATE TABLE IF NOT EXISTS login_throttles (
+	key TEXT PRIMARY KEY,
+	failures INT NOT NULL DEFAULT 0,
+	last_failure_at TIMESTAMP NOT NULL,
+	blocked_until TIMESTAMP DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_sessions_user_id_idx ON user_sessions (user_id) WHERE revoked_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS user_mfa (
+	user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	enabled_at TIMESTAMP DEFAULT NULL,
+	last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES user_mfa(user_id) ON DELETE CASCADE,
+	used_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+	challenge_hash TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	attempts INT NOT NULL DEFAULT 0
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+
+ALTER TYPE posting_kind ADD VALUE IF NOT EXISTS 'MANUAL_ADJUSTMENT';
+
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+	id BIGSERIAL PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	operator_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+	amount BIGINT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_id_idx ON balance_adjustments (user_id, id);
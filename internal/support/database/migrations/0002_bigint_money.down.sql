@@ -0,0 +1,12 @@
+-- fails if any sum doesn't fit into 32 bits anymore
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE INT;
+
+ALTER TABLE order_corrections
+	ALTER COLUMN old_accrual TYPE INT,
+	ALTER COLUMN new_accrual TYPE INT;
+
+ALTER TABLE orders ALTER COLUMN accrual TYPE INT;
+
+ALTER TABLE balances
+	ALTER COLUMN current TYPE INT,
+	ALTER COLUMN withdrawn TYPE INT;
@@ -0,0 +1,12 @@
+-- sums are stored in kopecks, so 32 bits overflow at about 21 million points
+ALTER TABLE balances
+	ALTER COLUMN current TYPE BIGINT,
+	ALTER COLUMN withdrawn TYPE BIGINT;
+
+ALTER TABLE orders ALTER COLUMN accrual TYPE BIGINT;
+
+ALTER TABLE order_corrections
+	ALTER COLUMN old_accrual TYPE BIGINT,
+	ALTER COLUMN new_accrual TYPE BIGINT;
+
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE BIGINT;
@@ -0,0 +1,28 @@
+-- points of other programs would silently become plain points, so they must be gone before rolling back
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM ledger WHERE program <> 'default') OR EXISTS (SELECT 1 FROM orders WHERE program <> 'default') THEN
+		RAISE EXCEPTION 'points of loyalty programs other than default exist';
+	END IF;
+END $$;
+
+ALTER TABLE balance_adjustments DROP COLUMN program;
+
+ALTER TABLE pending_accruals DROP COLUMN program;
+
+DROP INDEX point_lots_user_id_program_idx;
+CREATE INDEX point_lots_user_id_idx ON point_lots (user_id) WHERE remaining > 0;
+ALTER TABLE point_lots DROP COLUMN program;
+
+DROP INDEX ledger_user_id_program_created_at_idx;
+CREATE INDEX ledger_user_id_created_at_idx ON ledger (user_id, created_at, id);
+ALTER TABLE ledger DROP COLUMN program;
+
+ALTER TABLE withdrawals DROP COLUMN program;
+
+ALTER TABLE orders DROP COLUMN program;
+
+DELETE FROM balances WHERE program <> 'default';
+ALTER TABLE balances DROP CONSTRAINT balances_pkey;
+ALTER TABLE balances ADD PRIMARY KEY (user_id);
+ALTER TABLE balances DROP COLUMN program;
@@ -0,0 +1,20 @@
+-- everything accrued so far belongs to the default program, where one point is worth one ruble
+ALTER TABLE balances ADD COLUMN program TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE balances DROP CONSTRAINT balances_pkey;
+ALTER TABLE balances ADD PRIMARY KEY (user_id, program);
+
+ALTER TABLE orders ADD COLUMN program TEXT NOT NULL DEFAULT 'default';
+
+ALTER TABLE withdrawals ADD COLUMN program TEXT NOT NULL DEFAULT 'default';
+
+ALTER TABLE ledger ADD COLUMN program TEXT NOT NULL DEFAULT 'default';
+DROP INDEX ledger_user_id_created_at_idx;
+CREATE INDEX ledger_user_id_program_created_at_idx ON ledger (user_id, program, created_at, id);
+
+ALTER TABLE point_lots ADD COLUMN program TEXT NOT NULL DEFAULT 'default';
+DROP INDEX point_lots_user_id_idx;
+CREATE INDEX point_lots_user_id_program_idx ON point_lots (user_id, program) WHERE remaining > 0;
+
+ALTER TABLE pending_accruals ADD COLUMN program TEXT NOT NULL DEFAULT 'default';
+
+ALTER TABLE balance_adjustments ADD COLUMN program TEXT NOT NULL DEFAULT 'default';
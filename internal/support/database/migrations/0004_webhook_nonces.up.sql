@@ -0,0 +1,7 @@
+-- nonces of signed webhook requests, kept until requests with them are stale anyway
+CREATE TABLE webhook_nonces (
+	nonce TEXT PRIMARY KEY,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhook_nonces_expires_at_idx ON webhook_nonces (expires_at);
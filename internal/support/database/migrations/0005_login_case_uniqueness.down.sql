@@ -0,0 +1,2 @@
+-- renamed logins are kept, their owners use them already
+DROP INDEX users_login_lower_idx;
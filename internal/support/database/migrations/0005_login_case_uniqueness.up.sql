@@ -0,0 +1,9 @@
+-- logins registered before normalization may differ only in case from normalized ones registered after it.
+-- The oldest account keeps its login, later ones get a suffix of their id, which support tells their owners
+UPDATE users u SET login = left(u.login, 240) || '#' || left(u.id::text, 8)
+WHERE EXISTS (
+	SELECT 1 FROM users o
+	WHERE lower(o.login) = lower(u.login) AND (o.created_at, o.id::text) < (u.created_at, u.id::text)
+);
+
+CREATE UNIQUE INDEX users_login_lower_idx ON users (lower(login));
@@ -1,9 +1,146 @@
 package money
 
-func IntToFloat(sum int64) float64 {
-	return float64(sum) / 100
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amount of money in minor units, i.e. kopecks. In JSON it is a decimal number of major units, e.g. 100.93,
+// that is parsed and formatted exactly, without float rounding
+type Amount int64
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+const minorUnits = 100
+
+// Parse decimal number of major units. More than two fractional digits are accepted only if they are zeros
+func Parse(s string) (Amount, error) {
+	digits, negative := strings.CutPrefix(s, "-")
+	major, minor, _ := strings.Cut(digits, ".")
+
+	if major == "" || !isDigits(major) || !isDigits(minor) || (strings.Contains(digits, ".") && minor == "") {
+		return 0, fmt.Errorf("%w: %q is not a decimal number", ErrInvalidAmount, s)
+	}
+
+	trimmed := strings.TrimRight(minor, "0")
+	if len(trimmed) > 2 {
+		return 0, fmt.Errorf("%w: %q has more than two fractional digits", ErrInvalidAmount, s)
+	}
+
+	// kopecks are parsed together with major units, so the sum can't overflow unnoticed
+	value, err := strconv.ParseInt(major+(trimmed + "00")[:2], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is too large", ErrInvalidAmount, s)
+	}
+
+	if negative {
+		value = -value
+	}
+
+	return Amount(value), nil
 }
 
-func FloatToInt(sum float64) int64 {
-	return int64(sum * 100)
+// decimalNumber as in JSON. Exponent is limited, so a huge one can't make parsing slow
+var decimalNumber = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][+-]?\d{1,3})?$`)
+
+// ParseRounded decimal number of major units, possibly in exponent notation, rounded half away from zero to minor units.
+// It is for amounts computed by other systems, amounts entered by users are parsed exactly with Parse
+func ParseRounded(s string) (Amount, error) {
+	if !decimalNumber.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q is not a decimal number", ErrInvalidAmount, s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q is not a decimal number", ErrInvalidAmount, s)
+	}
+
+	r.Mul(r, big.NewRat(minorUnits, 1))
+
+	// remainder has the sign of the numerator, so doubling it and dividing again rounds half away from zero
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	half := new(big.Int).Quo(remainder.Mul(remainder, big.NewInt(2)), r.Denom())
+	quotient.Add(quotient, half)
+
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is too large", ErrInvalidAmount, s)
+	}
+
+	return Amount(quotient.Int64()), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String of major units with up to two fractional digits and no trailing zeros
+func (a Amount) String() string {
+	sign := ""
+	// int64 is converted before negation, so the minimal value does not overflow
+	abs := uint64(a)
+	if a < 0 {
+		sign = "-"
+		abs = -abs
+	}
+
+	major := strconv.FormatUint(abs/minorUnits, 10)
+	minor := abs % minorUnits
+	if minor == 0 {
+		return sign + major
+	}
+
+	return sign + major + "." + strings.TrimRight(fmt.Sprintf("%02d", minor), "0")
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*a = parsed
+
+	return nil
+}
+
+// Rounded amount is parsed from JSON with ParseRounded
+type Rounded Amount
+
+func (r Rounded) MarshalJSON() ([]byte, error) {
+	return Amount(r).MarshalJSON()
+}
+
+func (r *Rounded) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	parsed, err := ParseRounded(s)
+	if err != nil {
+		return err
+	}
+
+	*r = Rounded(parsed)
+
+	return nil
 }
@@ -0,0 +1,122 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Amount
+		wantErr bool
+	}{
+		{input: "0", want: 0},
+		{input: "100", want: 10000},
+		{input: "0.29", want: 29},
+		{input: "100.93", want: 10093},
+		{input: "1.5", want: 150},
+		{input: "1.500", want: 150},
+		{input: "-1.5", want: -150},
+		{input: "12.345", wantErr: true},
+		{input: "1e2", wantErr: true},
+		{input: ".5", wantErr: true},
+		{input: "5.", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "-", wantErr: true},
+		{input: "1,5", wantErr: true},
+		{input: "99999999999999999999", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRounded(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Amount
+		wantErr bool
+	}{
+		{input: "0", want: 0},
+		{input: "0.29", want: 29},
+		{input: "12.345", want: 1235},
+		{input: "12.344", want: 1234},
+		{input: "-12.345", want: -1235},
+		{input: "0.004", want: 0},
+		{input: "1e2", want: 10000},
+		{input: "1.5E-1", want: 15},
+		{input: "-2.5e0", want: -250},
+		{input: ".5", wantErr: true},
+		{input: "0x10", wantErr: true},
+		{input: "1/3", wantErr: true},
+		{input: "1e1000", wantErr: true},
+		{input: "1e100", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRounded(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{amount: 0, want: "0"},
+		{amount: 29, want: "0.29"},
+		{amount: 150, want: "1.5"},
+		{amount: 10000, want: "100"},
+		{amount: 10093, want: "100.93"},
+		{amount: -5, want: "-0.05"},
+		{amount: -150, want: "-1.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.amount.String())
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var exact struct {
+		Sum Amount `json:"sum"`
+	}
+	assert.Error(t, json.Unmarshal([]byte(`{"sum":12.345}`), &exact), "user input is not rounded")
+
+	var rounded struct {
+		Accrual Rounded `json:"accrual"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"accrual":12.345}`), &rounded))
+	assert.Equal(t, Rounded(1235), rounded.Accrual)
+
+	data, err := json.Marshal(rounded)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"accrual":12.35}`, string(data))
+}
@@ -0,0 +1,83 @@
+// Package notifier has notifiers for local development, that don't deliver messages to users
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+// NewLogNotifier writes messages to the log
+func NewLogNotifier() user.Notifier {
+	return &logNotifier{logger: log.Logger().Named("notifier")}
+}
+
+type logNotifier struct {
+	logger *zap.SugaredLogger
+}
+
+func (l *logNotifier) SendPasswordReset(_ context.Context, userID string, login string, token string) error {
+	l.logger.Infow("password reset", "userID", userID, "login", login, "token", token)
+
+	return nil
+}
+
+// NewFileNotifier appends messages to the file as JSON lines
+func NewFileNotifier(path string) user.Notifier {
+	return &fileNotifier{path: path}
+}
+
+type fileNotifier struct {
+	mutex sync.Mutex
+	path  string
+}
+
+type message struct {
+	Type   string    `json:"type"`
+	UserID string    `json:"user_id"`
+	Login  string    `json:"login"`
+	Token  string    `json:"token"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+func (f *fileNotifier) SendPasswordReset(_ context.Context, userID string, login string, token string) error {
+	return f.write(&message{
+		Type:   "password_reset",
+		UserID: userID,
+		Login:  login,
+		Token:  token,
+		SentAt: time.Now(),
+	})
+}
+
+func (f *fileNotifier) write(m *message) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("cant marshal message: %w", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("cant open file: %w", err)
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		_ = file.Close()
+
+		return fmt.Errorf("cant write message: %w", err)
+	}
+
+	return file.Close()
+}
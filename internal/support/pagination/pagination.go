@@ -0,0 +1,71 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultLimit = 100
+const MaxLimit = 1000
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points to the last item of a page, that is sorted by time and then by key, both descending
+type Cursor struct {
+	Time time.Time
+	Key  string
+}
+
+// String representation of the cursor, opaque for clients
+func (c *Cursor) String() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + ":" + c.Key
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, key, found := strings.Cut(string(raw), ":")
+	if !found || key == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// timestamps are stored without time zone as UTC wall clock
+	return &Cursor{Time: time.Unix(0, n).UTC(), Key: key}, nil
+}
+
+// After reports whether an item with the given time and key goes after the cursor in descending order
+func (c *Cursor) After(t time.Time, key string) bool {
+	if t.Equal(c.Time) {
+		return key < c.Key
+	}
+
+	return t.Before(c.Time)
+}
+
+var ErrInvalidLimit = errors.New("invalid limit")
+
+// Limit of a page, DefaultLimit if zero is requested
+func Limit(requested int) (int, error) {
+	if requested == 0 {
+		return DefaultLimit, nil
+	}
+
+	if requested < 0 || requested > MaxLimit {
+		return 0, ErrInvalidLimit
+	}
+
+	return requested, nil
+}
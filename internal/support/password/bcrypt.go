@@ -0,0 +1,39 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hashBcrypt(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// verifyBcrypt returns cost of the hash along with the result
+func verifyBcrypt(password string, hash string) (bool, int, error) {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, 0, ErrUnknownFormat
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, cost, nil
+	} else if err != nil {
+		return false, cost, fmt.Errorf("failed to compare hash: %w", err)
+	}
+
+	return true, cost, nil
+}
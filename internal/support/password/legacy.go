@@ -0,0 +1,20 @@
+package password
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// legacy hashes are hex encoded SHA-256 of the password with the global salt appended
+func isLegacy(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+
+	return err == nil && len(decoded) == sha256.Size
+}
+
+func verifyLegacy(password string, hash string, salt string) bool {
+	actual := sha256.Sum256([]byte(password + salt))
+
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(actual[:])), []byte(hash)) == 1
+}
@@ -0,0 +1,84 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Algorithm string
+
+func (a Algorithm) String() string {
+	return string(a)
+}
+
+const Argon2id = Algorithm("argon2id")
+const Bcrypt = Algorithm("bcrypt")
+
+var ErrUnknownAlgorithm = errors.New("unknown hashing algorithm")
+var ErrUnknownFormat = errors.New("unknown hash format")
+
+type Options struct {
+	// Algorithm of new hashes, hashes of other algorithms are still verified
+	Algorithm Algorithm
+	// Argon2 parameters, DefaultArgon2Params if zero
+	Argon2 Argon2Params
+	// BcryptCost, bcrypt.DefaultCost if zero
+	BcryptCost int
+	// LegacySalt is the global salt of legacy SHA-256 hashes, they are not verified if the salt is empty
+	LegacySalt string
+}
+
+// Hasher makes PHC formatted hashes with per-hash salts
+type Hasher struct {
+	options *Options
+}
+
+func NewHasher(options *Options) (*Hasher, error) {
+	if options == nil {
+		return nil, errors.New("no options provided")
+	}
+
+	if options.Algorithm != Argon2id && options.Algorithm != Bcrypt {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, options.Algorithm)
+	}
+
+	o := *options
+	if o.Argon2 == (Argon2Params{}) {
+		o.Argon2 = DefaultArgon2Params
+	}
+
+	if o.BcryptCost == 0 {
+		o.BcryptCost = bcrypt.DefaultCost
+	}
+
+	return &Hasher{options: &o}, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.options.Algorithm == Bcrypt {
+		return hashBcrypt(password, h.options.BcryptCost)
+	}
+
+	return hashArgon2(password, h.options.Argon2)
+}
+
+// Verify the password against the hash. Rehash is needed if the hash is made with another algorithm or parameters
+func (h *Hasher) Verify(password string, hash string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, argon2Prefix):
+		matches, params, err := verifyArgon2(password, hash)
+
+		return matches, h.options.Algorithm != Argon2id || params != h.options.Argon2, err
+	case isBcrypt(hash):
+		matches, cost, err := verifyBcrypt(password, hash)
+
+		return matches, h.options.Algorithm != Bcrypt || cost != h.options.BcryptCost, err
+	case isLegacy(hash) && h.options.LegacySalt != "":
+		return verifyLegacy(password, hash, h.options.LegacySalt), true, nil
+	default:
+		return false, false, ErrUnknownFormat
+	}
+}
@@ -0,0 +1,204 @@
+package policy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+const (
+	CodeRequired          = "required"
+	CodeTooShort          = "too_short"
+	CodeTooLong           = "too_long"
+	CodeInvalidCharacters = "invalid_characters"
+	CodeCharacterClasses  = "character_classes"
+	CodeContainsLogin     = "contains_login"
+	CodeBreached          = "breached"
+)
+
+// DefaultLoginPattern allows letters, digits and a few separators, so logins can be emails
+const DefaultLoginPattern = `^[\p{L}\p{N}._@+-]+$`
+
+// minContainedLoginLength, shorter logins are too likely to be found in any password
+const minContainedLoginLength = 4
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+type Options struct {
+	MinLoginLength int
+	MaxLoginLength int
+	// LoginPattern of a normalized login, DefaultLoginPattern if empty
+	LoginPattern string
+	// FoldLoginCase, so logins are case-insensitive
+	FoldLoginCase     bool
+	MinPasswordLength int
+	MaxPasswordLength int
+	// MinCharacterClasses of lower and upper case letters, digits and symbols in a password
+	MinCharacterClasses int
+	// BreachedPasswordsFile has one password per line. Embedded list of common passwords is used if empty
+	BreachedPasswordsFile string
+}
+
+// Policy of credentials. Lengths are counted in runes, zero max length means no limit
+type Policy struct {
+	options      *Options
+	loginPattern *regexp.Regexp
+	// breached passwords in lower case
+	breached map[string]struct{}
+}
+
+func NewPolicy(options *Options) (*Policy, error) {
+	pattern := options.LoginPattern
+	if pattern == "" {
+		pattern = DefaultLoginPattern
+	}
+
+	loginPattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid login pattern: %w", err)
+	}
+
+	var list io.Reader = strings.NewReader(commonPasswords)
+	if options.BreachedPasswordsFile != "" {
+		file, err := os.Open(options.BreachedPasswordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("cant open breached passwords file: %w", err)
+		}
+		defer file.Close()
+
+		list = file
+	}
+
+	breached, err := readPasswords(list)
+	if err != nil {
+		return nil, fmt.Errorf("cant read breached passwords: %w", err)
+	}
+
+	return &Policy{
+		options:      options,
+		loginPattern: loginPattern,
+		breached:     breached,
+	}, nil
+}
+
+func readPasswords(r io.Reader) (map[string]struct{}, error) {
+	passwords := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		password := strings.TrimSpace(scanner.Text())
+		if password != "" {
+			passwords[strings.ToLower(password)] = struct{}{}
+		}
+	}
+
+	return passwords, scanner.Err()
+}
+
+func (p *Policy) NormalizeLogin(login string) string {
+	login = norm.NFKC.String(strings.TrimSpace(login))
+
+	if p.options.FoldLoginCase {
+		login = cases.Fold().String(login)
+	}
+
+	return login
+}
+
+func (p *Policy) ValidateLogin(login string) []user.Violation {
+	if login == "" {
+		return []user.Violation{loginViolation(CodeRequired, "login is required")}
+	}
+
+	violations := make([]user.Violation, 0)
+
+	length := utf8.RuneCountInString(login)
+	if length < p.options.MinLoginLength {
+		violations = append(violations, loginViolation(CodeTooShort, fmt.Sprintf("login must be at least %d characters long", p.options.MinLoginLength)))
+	}
+
+	if p.options.MaxLoginLength > 0 && length > p.options.MaxLoginLength {
+		violations = append(violations, loginViolation(CodeTooLong, fmt.Sprintf("login must be at most %d characters long", p.options.MaxLoginLength)))
+	}
+
+	if !p.loginPattern.MatchString(login) {
+		violations = append(violations, loginViolation(CodeInvalidCharacters, "login contains characters that are not allowed"))
+	}
+
+	return violations
+}
+
+func (p *Policy) ValidatePassword(password string, login string) []user.Violation {
+	if password == "" {
+		return []user.Violation{passwordViolation(CodeRequired, "password is required")}
+	}
+
+	violations := make([]user.Violation, 0)
+
+	length := utf8.RuneCountInString(password)
+	if length < p.options.MinPasswordLength {
+		violations = append(violations, passwordViolation(CodeTooShort, fmt.Sprintf("password must be at least %d characters long", p.options.MinPasswordLength)))
+	}
+
+	if p.options.MaxPasswordLength > 0 && length > p.options.MaxPasswordLength {
+		violations = append(violations, passwordViolation(CodeTooLong, fmt.Sprintf("password must be at most %d characters long", p.options.MaxPasswordLength)))
+	}
+
+	if characterClasses(password) < p.options.MinCharacterClasses {
+		violations = append(violations, passwordViolation(
+			CodeCharacterClasses,
+			fmt.Sprintf("password must contain at least %d of lower case letters, upper case letters, digits and symbols", p.options.MinCharacterClasses),
+		))
+	}
+
+	lowerPassword := strings.ToLower(password)
+	lowerLogin := strings.ToLower(login)
+	if login != "" && (lowerPassword == lowerLogin ||
+		utf8.RuneCountInString(login) >= minContainedLoginLength && strings.Contains(lowerPassword, lowerLogin)) {
+		violations = append(violations, passwordViolation(CodeContainsLogin, "password must not contain the login"))
+	}
+
+	if _, ok := p.breached[lowerPassword]; ok {
+		violations = append(violations, passwordViolation(CodeBreached, "password is too common"))
+	}
+
+	return violations
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
+
+func loginViolation(code string, message string) user.Violation {
+	return user.Violation{Field: user.FieldLogin, Code: code, Message: message}
+}
+
+func passwordViolation(code string, message string) user.Violation {
+	return user.Violation{Field: user.FieldPassword, Code: code, Message: message}
+}
@@ -46,6 +46,11 @@ func (p *Pool) Tune(newMaxWorkers int) {
 	p.pool.Tune(newMaxWorkers)
 }
 
+// Free returns the number of idle workers
+func (p *Pool) Free() int {
+	return p.pool.Free()
+}
+
 func (p *Pool) Submit(task func()) error {
 	return p.pool.Submit(task)
 }
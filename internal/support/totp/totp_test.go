@@ -0,0 +1,78 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 key of RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, codes are truncated to 6 digits, i.e. the last 6 of the 8 digits in the RFC
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, code)
+		})
+	}
+
+	t.Run("lower case secret", func(t *testing.T) {
+		code, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(59, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, "287082", code)
+	})
+
+	t.Run("invalid secret", func(t *testing.T) {
+		_, err := Code("not base32!", 1)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	step := Step(at)
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", code: "081804", skew: 1, wantStep: step, wantOK: true},
+		{name: "next step within skew", code: "050471", skew: 1, wantStep: step + 1, wantOK: true},
+		{name: "next step without skew", code: "050471", skew: 0},
+		{name: "wrong code", code: "000000", skew: 1},
+		{name: "wrong length", code: "81804", skew: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok, err := Validate(rfcSecret, tt.code, at, tt.skew)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOK, ok)
+
+			if tt.wantOK {
+				assert.Equal(t, tt.wantStep, matched)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	balanceStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/adjustments"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/idempotency"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/ledger"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/lots"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/pending"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/withdrawals"
+)
+
+// NewBalanceService over in-memory repositories
+func NewBalanceService(options *balance.Options) balance.Service {
+	return NewBalanceServices(options)[0]
+}
+
+// NewBalanceServices with different options over the same in-memory repositories
+func NewBalanceServices(options ...*balance.Options) []balance.Service {
+	repo := balanceStorage.NewInMemoryRepository()
+	withdrawalsRepo := withdrawals.NewMemoryRepository()
+	ledgerRepo := ledger.NewMemoryRepository()
+	idempotencyRepo := idempotency.NewMemoryRepository()
+	lotsRepo := lots.NewMemoryRepository()
+	pendingRepo := pending.NewMemoryRepository()
+	adjustmentsRepo := adjustments.NewMemoryRepository()
+
+	services := make([]balance.Service, 0, len(options))
+	for _, o := range options {
+		services = append(services, balance.NewService(
+			repo,
+			withdrawalsRepo,
+			ledgerRepo,
+			idempotencyRepo,
+			lotsRepo,
+			pendingRepo,
+			adjustmentsRepo,
+			NewDummyTxProvider(),
+			o,
+		))
+	}
+
+	return services
+}
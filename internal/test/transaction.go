@@ -1,4 +1,4 @@
-package handlerstest
+package test
 
 import (
 	"context"
@@ -6,7 +6,8 @@ import (
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
 )
 
-func newDummyTxProvider() transaction.Provider {
+// NewDummyTxProvider for in-memory repositories, which ignore transactions
+func NewDummyTxProvider() transaction.Provider {
 	return &dummyTxProvider{}
 }
 
@@ -0,0 +1,146 @@
+package accrual
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/webhook"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/test"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/handlerstest"
+)
+
+const webhookPath = "/api/accrual/webhook"
+
+func TestWebhook(t *testing.T) {
+	log.InitTestLogger(t)
+
+	server, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer server.Close()
+
+	client := resty.New().SetBaseURL(server.URL)
+
+	number := test.NewOrderNumber()
+	response, err := client.R().
+		SetAuthToken(token).
+		SetHeader("Content-Type", "text/plain").
+		SetBody(number).
+		Post("/api/user/orders")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, response.StatusCode())
+
+	body := fmt.Sprintf(`{"order":"%s","status":"PROCESSED","accrual":100.93}`, number)
+
+	t.Run("signed", func(t *testing.T) {
+		response, err := push(client, signedRequest(time.Now(), uuid.NewString(), body))
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode())
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		request := signedRequest(time.Now(), uuid.NewString(), body)
+
+		response, err := push(client, request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+
+		response, err = push(client, request)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		response, err := push(client, signedRequest(time.Now().Add(-time.Hour), uuid.NewString(), body))
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+	})
+
+	t.Run("signed with another secret", func(t *testing.T) {
+		request := signedRequest(time.Now(), uuid.NewString(), body)
+		request.signature = webhook.Sign([]byte("another"), request.timestamp, request.nonce, []byte(body))
+
+		response, err := push(client, request)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+	})
+
+	t.Run("body changed after signing", func(t *testing.T) {
+		request := signedRequest(time.Now(), uuid.NewString(), body)
+		request.body = fmt.Sprintf(`{"order":"%s","status":"PROCESSED","accrual":1000}`, number)
+
+		response, err := push(client, request)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		response, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Post(webhookPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+	})
+
+	t.Run("unknown order", func(t *testing.T) {
+		unknown := fmt.Sprintf(`{"order":"%s","status":"PROCESSED","accrual":1}`, test.NewOrderNumber())
+
+		response, err := push(client, signedRequest(time.Now(), uuid.NewString(), unknown))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode())
+	})
+
+	t.Run("unknown status", func(t *testing.T) {
+		unknown := fmt.Sprintf(`{"order":"%s","status":"DONE"}`, number)
+
+		response, err := push(client, signedRequest(time.Now(), uuid.NewString(), unknown))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode())
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		response, err := push(client, signedRequest(time.Now(), uuid.NewString(), "hi"))
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode())
+	})
+}
+
+type request struct {
+	timestamp string
+	nonce     string
+	signature string
+	body      string
+}
+
+func signedRequest(at time.Time, nonce string, body string) *request {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	return &request{
+		timestamp: timestamp,
+		nonce:     nonce,
+		signature: webhook.Sign([]byte(handlerstest.WebhookSecret), timestamp, nonce, []byte(body)),
+		body:      body,
+	}
+}
+
+func push(client *resty.Client, r *request) (*resty.Response, error) {
+	return client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("X-Accrual-Timestamp", r.timestamp).
+		SetHeader("X-Accrual-Nonce", r.nonce).
+		SetHeader("X-Accrual-Signature", r.signature).
+		SetBody(r.body).
+		Post(webhookPath)
+}
@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order/accrual"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/webhook"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
+
+const (
+	timestampHeader = "X-Accrual-Timestamp"
+	nonceHeader     = "X-Accrual-Nonce"
+	signatureHeader = "X-Accrual-Signature"
+)
+
+type Handler struct {
+	webhookService webhook.Service
+	orderService   order.Service
+}
+
+func New(webhookService webhook.Service, orderService order.Service) *Handler {
+	return &Handler{
+		webhookService: webhookService,
+		orderService:   orderService,
+	}
+}
+
+// payload is the same as the accrual system responds with when it is polled
+type payload struct {
+	Order   string        `json:"order"`
+	Status  string        `json:"status"`
+	Accrual money.Rounded `json:"accrual"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	body := ctx.Body()
+	timestamp := ctx.Get(timestampHeader)
+	nonce := ctx.Get(nonceHeader)
+
+	err := h.webhookService.Verify(ctx.Context(), timestamp, nonce, ctx.Get(signatureHeader), body)
+	if err != nil {
+		return sendVerificationError(ctx, err)
+	}
+
+	p := new(payload)
+	if err := json.Unmarshal(body, p); err != nil || p.Order == "" {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	status, err := accrual.ParseStatus(p.Status)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
+	}
+
+	result := order.AccrualResult{
+		Number: p.Order,
+		Status: status,
+	}
+
+	// accrual system omits accrual of an order, that is still processing, until it is known
+	accrualAmount := int64(p.Accrual)
+	if status == order.StatusProcessed || (!status.IsFinal() && accrualAmount > 0) {
+		result.Accrual = &accrualAmount
+	}
+
+	// the nonce is used only if the result is applied, so a request failed on our side can be retried as is
+	err = h.orderService.ApplyAccrualResult(ctx.Context(), result, func(tx transaction.Transaction) error {
+		return h.webhookService.UseNonce(ctx.Context(), timestamp, nonce, tx)
+	})
+	if errors.Is(err, order.ErrOrderNotFound) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	} else if err != nil {
+		return sendVerificationError(ctx, err)
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func sendVerificationError(ctx *fiber.Ctx, err error) error {
+	if errors.Is(err, webhook.ErrInvalidSignature) ||
+		errors.Is(err, webhook.ErrStale) ||
+		errors.Is(err, webhook.ErrReplayed) {
+		ctx.Status(fiber.StatusUnauthorized)
+
+		return ctx.SendString(err.Error())
+	}
+
+	return ctx.SendStatus(fiber.StatusInternalServerError)
+}
@@ -0,0 +1,73 @@
+package add
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/adjustments/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/userid"
+)
+
+type Handler struct {
+	service balance.Service
+}
+
+func New(service balance.Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type payload struct {
+	// Program is the default one if empty
+	Program string `json:"program"`
+	// Amount is negative to take points away
+	Amount money.Amount `json:"amount"`
+	Reason string       `json:"reason"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	userID, found := userid.Param(ctx)
+	if !found {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+
+	if p.Program == "" {
+		p.Program = balance.DefaultProgram
+	}
+
+	// operator is the staff user making the request
+	operatorID, _ := userid.Authenticated(ctx)
+
+	adjustment, err := h.service.Adjust(ctx.Context(), userID, p.Program, operatorID, int64(p.Amount), p.Reason)
+
+	if errors.Is(err, balance.ErrInvalidAdjustmentAmount) ||
+		errors.Is(err, balance.ErrEmptyAdjustmentReason) ||
+		errors.Is(err, balance.ErrUnknownProgram) {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
+	} else if errors.Is(err, balance.ErrNotEnoughBalance) {
+		ctx.Status(fiber.StatusConflict)
+
+		return ctx.SendString(err.Error())
+	} else if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	ctx.Status(fiber.StatusCreated)
+
+	return ctx.JSON(internal.MapAdjustmentToJSON(adjustment))
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+)
+
+type AdjustmentJSON struct {
+	ID         int64        `json:"id"`
+	Program    string       `json:"program"`
+	OperatorID string       `json:"operator_id"`
+	Amount     money.Amount `json:"amount"`
+	Reason     string       `json:"reason"`
+	CreatedAt  string       `json:"created_at"`
+}
+
+func MapAdjustmentToJSON(a *balance.Adjustment) *AdjustmentJSON {
+	return &AdjustmentJSON{
+		ID:         a.ID,
+		Program:    a.Program,
+		OperatorID: a.OperatorID,
+		Amount:     money.Amount(a.Amount),
+		Reason:     a.Reason,
+		CreatedAt:  a.CreatedAt.Format(time.RFC3339),
+	}
+}
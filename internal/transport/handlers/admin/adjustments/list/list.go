@@ -0,0 +1,44 @@
+package list
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/adjustments/internal"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/userid"
+)
+
+type Handler struct {
+	service balance.Service
+}
+
+func New(service balance.Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	userID, found := userid.Param(ctx)
+	if !found {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+
+	adjustments, err := h.service.Adjustments(ctx.Context(), userID)
+	if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	if len(adjustments) == 0 {
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+
+	result := make([]*internal.AdjustmentJSON, 0, len(adjustments))
+	for _, a := range adjustments {
+		result = append(result, internal.MapAdjustmentToJSON(a))
+	}
+
+	ctx.Status(fiber.StatusOK)
+
+	return ctx.JSON(result)
+}
@@ -0,0 +1,257 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/handlerstest"
+)
+
+const users = "/api/admin/users"
+
+func TestAdmin(t *testing.T) {
+	log.InitTestLogger(t)
+
+	t.Run("access", testAccess)
+	t.Run("lookup", testLookup)
+	t.Run("role", testRole)
+	t.Run("adjustments", testAdjustments)
+}
+
+func testAccess(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL)
+	userID := handlerstest.UserID(t, token)
+
+	get := func(token string) int {
+		response, err := client.R().SetAuthToken(token).Get(users + "/" + userID)
+		require.NoError(t, err)
+
+		return response.StatusCode()
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get(""))
+	assert.Equal(t, http.StatusForbidden, get(token))
+	assert.Equal(t, http.StatusOK, get(handlerstest.LoginSupport(t, testServer)))
+	assert.Equal(t, http.StatusOK, get(handlerstest.LoginAdmin(t, testServer)))
+}
+
+func testLookup(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL).SetAuthToken(handlerstest.LoginSupport(t, testServer))
+	userID := handlerstest.UserID(t, token)
+	increment := handlerstest.IncreaseBalance(t, testServer, token)
+
+	type userJSON struct {
+		ID         string `json:"id"`
+		Login      string `json:"login"`
+		Role       string `json:"role"`
+		MFAEnabled bool   `json:"mfa_enabled"`
+	}
+
+	t.Run("by id", func(t *testing.T) {
+		result := new(userJSON)
+
+		response, err := client.R().SetResult(result).Get(users + "/" + userID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+
+		assert.Equal(t, userID, result.ID)
+		assert.Equal(t, "user", result.Role)
+		assert.False(t, result.MFAEnabled)
+
+		t.Run("by login", func(t *testing.T) {
+			found := new(userJSON)
+
+			response, err := client.R().SetResult(found).SetQueryParam("login", result.Login).Get(users)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, response.StatusCode())
+
+			assert.Equal(t, result, found)
+		})
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		for _, path := range []string{"", "/orders", "/balance", "/withdrawals"} {
+			response, err := client.R().Get(users + "/00000000-0000-0000-0000-000000000000" + path)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusNotFound, response.StatusCode(), path)
+
+			response, err = client.R().Get(users + "/not-uuid" + path)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusNotFound, response.StatusCode(), path)
+		}
+
+		response, err := client.R().SetQueryParam("login", "nobody").Get(users)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode())
+
+		response, err = client.R().Get(users)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode())
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		var orders []struct {
+			Number string `json:"number"`
+		}
+
+		response, err := client.R().SetResult(&orders).Get(users + "/" + userID + "/orders")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		assert.Len(t, orders, 1)
+	})
+
+	t.Run("balance", func(t *testing.T) {
+		result := new(struct {
+			Current money.Amount `json:"current"`
+		})
+
+		response, err := client.R().SetResult(result).Get(users + "/" + userID + "/balance")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		assert.Equal(t, money.Amount(increment), result.Current)
+	})
+
+	t.Run("withdrawals", func(t *testing.T) {
+		response, err := client.R().Get(users + "/" + userID + "/withdrawals")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode())
+	})
+}
+
+func testRole(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL)
+	userID := handlerstest.UserID(t, token)
+	adminToken := handlerstest.LoginAdmin(t, testServer)
+
+	setRole := func(token string, userID string, role string) int {
+		response, err := client.R().
+			SetAuthToken(token).
+			SetBody(map[string]string{"role": role}).
+			Put(users + "/" + userID + "/role")
+		require.NoError(t, err)
+
+		return response.StatusCode()
+	}
+
+	t.Run("only admins can set roles", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, setRole(token, userID, "admin"))
+		assert.Equal(t, http.StatusForbidden, setRole(handlerstest.LoginSupport(t, testServer), userID, "admin"))
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, setRole(adminToken, userID, "root"))
+		assert.Equal(t, http.StatusNotFound, setRole(adminToken, "00000000-0000-0000-0000-000000000000", "support"))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, setRole(adminToken, userID, "support"))
+
+		result := new(struct {
+			Role string `json:"role"`
+		})
+
+		response, err := client.R().SetAuthToken(adminToken).SetResult(result).Get(users + "/" + userID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		assert.Equal(t, "support", result.Role)
+	})
+}
+
+func testAdjustments(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL)
+	userID := handlerstest.UserID(t, token)
+	adminToken := handlerstest.LoginAdmin(t, testServer)
+	adjustments := users + "/" + userID + "/adjustments"
+
+	adjust := func(token string, amount money.Amount, reason string) int {
+		response, err := client.R().
+			SetAuthToken(token).
+			SetBody(map[string]any{"amount": amount, "reason": reason}).
+			Post(adjustments)
+		require.NoError(t, err)
+
+		return response.StatusCode()
+	}
+
+	t.Run("only admins can adjust", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, adjust(token, 1000, "goodwill"))
+		assert.Equal(t, http.StatusForbidden, adjust(handlerstest.LoginSupport(t, testServer), 1000, "goodwill"))
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, adjust(adminToken, 0, "goodwill"))
+		assert.Equal(t, http.StatusBadRequest, adjust(adminToken, 1000, ""))
+		assert.Equal(t, http.StatusConflict, adjust(adminToken, -1000, "mistake"))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		// 10.29 and 0.29 are not exact in float
+		require.Equal(t, http.StatusCreated, adjust(adminToken, 1029, "goodwill"))
+		require.Equal(t, http.StatusCreated, adjust(adminToken, -29, "mistake"))
+
+		result := new(struct {
+			Current money.Amount `json:"current"`
+		})
+
+		response, err := client.R().SetAuthToken(token).SetResult(result).Get("/api/user/balance")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		assert.Equal(t, money.Amount(1000), result.Current)
+	})
+
+	t.Run("audit log", func(t *testing.T) {
+		var list []struct {
+			OperatorID string       `json:"operator_id"`
+			Amount     money.Amount `json:"amount"`
+			Reason     string       `json:"reason"`
+		}
+
+		response, err := client.R().SetAuthToken(handlerstest.LoginSupport(t, testServer)).SetResult(&list).Get(adjustments)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		require.Len(t, list, 2)
+
+		assert.Equal(t, handlerstest.UserID(t, adminToken), list[0].OperatorID)
+		assert.Equal(t, money.Amount(-29), list[0].Amount)
+		assert.Equal(t, "mistake", list[0].Reason)
+	})
+
+	t.Run("user history", func(t *testing.T) {
+		var entries []struct {
+			Type        string       `json:"type"`
+			Order       string       `json:"order"`
+			Reference   string       `json:"reference"`
+			Amount      money.Amount `json:"amount"`
+			Description string       `json:"description"`
+		}
+
+		response, err := client.R().SetAuthToken(token).SetResult(&entries).Get("/api/user/statement")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		require.Len(t, entries, 2)
+
+		assert.Equal(t, "MANUAL_ADJUSTMENT", entries[1].Type)
+		assert.Equal(t, money.Amount(1029), entries[1].Amount)
+		assert.Equal(t, "goodwill", entries[1].Description)
+		assert.Empty(t, entries[1].Order, "manual adjustment is not made for an order")
+		assert.Regexp(t, `^adjustment/\d+$`, entries[1].Reference)
+	})
+}
@@ -0,0 +1,38 @@
+package find
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/users/internal"
+)
+
+type Handler struct {
+	userService user.Service
+}
+
+func New(userService user.Service) *Handler {
+	return &Handler{
+		userService: userService,
+	}
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	login := ctx.Query("login")
+	if login == "" {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString("login is required")
+	}
+
+	u, err := h.userService.FindUser(ctx.Context(), login)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	} else if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return internal.SendUser(ctx, u)
+}
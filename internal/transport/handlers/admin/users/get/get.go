@@ -0,0 +1,31 @@
+package get
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/users/internal"
+)
+
+type Handler struct {
+	userService user.Service
+}
+
+func New(userService user.Service) *Handler {
+	return &Handler{
+		userService: userService,
+	}
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	u, err := h.userService.GetUser(ctx.Context(), ctx.Params("userid"))
+	if errors.Is(err, user.ErrUserNotFound) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	} else if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return internal.SendUser(ctx, u)
+}
@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+type userJSON struct {
+	ID           string `json:"id"`
+	Login        string `json:"login"`
+	Role         string `json:"role"`
+	MFAEnabled   bool   `json:"mfa_enabled"`
+	RegisteredAt string `json:"registered_at"`
+}
+
+func SendUser(ctx *fiber.Ctx, u *user.User) error {
+	ctx.Status(fiber.StatusOK)
+
+	return ctx.JSON(userJSON{
+		ID:           u.ID,
+		Login:        u.Login,
+		Role:         string(u.Role),
+		MFAEnabled:   u.MFAEnabled,
+		RegisteredAt: u.CreatedAt.Format(time.RFC3339),
+	})
+}
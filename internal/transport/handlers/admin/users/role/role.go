@@ -0,0 +1,47 @@
+package role
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+type Handler struct {
+	userService user.Service
+}
+
+func New(userService user.Service) *Handler {
+	return &Handler{
+		userService: userService,
+	}
+}
+
+type payload struct {
+	Role string `json:"role"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	err := h.userService.SetRole(ctx.Context(), ctx.Params("userid"), user.Role(p.Role))
+	if errors.Is(err, user.ErrInvalidRole) {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
+	} else if errors.Is(err, user.ErrUserNotFound) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	} else if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
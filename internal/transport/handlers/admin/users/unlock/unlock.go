@@ -0,0 +1,45 @@
+package unlock
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+type Handler struct {
+	userService     user.Service
+	throttleService throttle.Service
+}
+
+func New(userService user.Service, throttleService throttle.Service) *Handler {
+	return &Handler{
+		userService:     userService,
+		throttleService: throttleService,
+	}
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	userID := ctx.Params("userid")
+	if uuid.Validate(userID) != nil {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+
+	u, err := h.userService.GetUser(ctx.Context(), userID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	} else if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// logins are throttled normalized, logins registered before normalization are stored as is
+	err = h.throttleService.Unlock(ctx.Context(), h.userService.NormalizeLogin(u.Login))
+	if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
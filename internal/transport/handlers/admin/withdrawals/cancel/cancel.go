@@ -0,0 +1,58 @@
+package cancel
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+type Handler struct {
+	service balance.Service
+}
+
+func New(service balance.Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type payload struct {
+	Reason string `json:"reason"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	userID := ctx.Params("userid")
+	if uuid.Validate(userID) != nil {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+
+	err := h.service.CancelWithdrawal(ctx.Context(), userID, ctx.Params("order"), p.Reason)
+
+	if errors.Is(err, balance.ErrWithdrawalNotFound) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	} else if errors.Is(err, balance.ErrAlreadyCancelled) {
+		ctx.Status(fiber.StatusConflict)
+
+		return ctx.SendString(err.Error())
+	} else if errors.Is(err, balance.ErrEmptyCancellationReason) {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
+	} else if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
@@ -1,19 +1,36 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/totp"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/handlerstest"
 )
 
 const register = "/api/user/register"
 const login = "/api/user/login"
+const refresh = "/api/user/token/refresh"
+const logout = "/api/user/logout"
+const balance = "/api/user/balance"
+const changePassword = "/api/user/password"
+const forgotPassword = "/api/user/password/forgot"
+const loginMFA = "/api/user/login/mfa"
+const totpEnroll = "/api/user/mfa/totp"
+const totpConfirm = "/api/user/mfa/totp/confirm"
+const totpDisable = "/api/user/mfa/totp/disable"
 
 func TestAuth(t *testing.T) {
 	log.InitTestLogger(t)
@@ -27,6 +44,13 @@ func TestAuth(t *testing.T) {
 	})
 
 	t.Run("flow", testFlow)
+	t.Run("refresh and logout", testRefreshAndLogout)
+	t.Run("token is verified with published keys", testJWKS)
+	t.Run("failed logins are throttled", testLoginThrottling)
+	t.Run("parallel logins can't pass the lockout", testParallelLoginThrottling)
+	t.Run("password change", testPasswordChange)
+	t.Run("password change and reset are throttled", testPasswordThrottling)
+	t.Run("MFA", testMFA)
 }
 
 func testRegisterPayloadValidation(t *testing.T) {
@@ -71,8 +95,8 @@ func testRegisterPayloadValidation(t *testing.T) {
 			},
 			Want: handlerstest.Want{
 				Status:      400,
-				Body:        "invalid login",
-				ContentType: "text/plain; charset=utf-8",
+				Body:        `{"error":"login is required","violations":[{"field":"login","code":"required","message":"login is required"}]}`,
+				ContentType: "application/json",
 			},
 		},
 		{
@@ -84,8 +108,50 @@ func testRegisterPayloadValidation(t *testing.T) {
 			},
 			Want: handlerstest.Want{
 				Status:      400,
-				Body:        "password is too short",
-				ContentType: "text/plain; charset=utf-8",
+				Body:        `{"error":"password is required","violations":[{"field":"password","code":"required","message":"password is required"}]}`,
+				ContentType: "application/json",
+			},
+		},
+		{
+			Name:        "every violation is reported",
+			ContentType: "application/json",
+			Body: map[string]string{
+				"login":    "h i",
+				"password": "short",
+			},
+			Want: handlerstest.Want{
+				Status: 400,
+				Body: `{"error":"login contains characters that are not allowed; password must be at least 12 characters long","violations":[
+					{"field":"login","code":"invalid_characters","message":"login contains characters that are not allowed"},
+					{"field":"password","code":"too_short","message":"password must be at least 12 characters long"}
+				]}`,
+				ContentType: "application/json",
+			},
+		},
+		{
+			Name:        "common password",
+			ContentType: "application/json",
+			Body: map[string]string{
+				"login":    "test",
+				"password": "Password1234",
+			},
+			Want: handlerstest.Want{
+				Status:      400,
+				Body:        `{"error":"password is too common","violations":[{"field":"password","code":"breached","message":"password is too common"}]}`,
+				ContentType: "application/json",
+			},
+		},
+		{
+			Name:        "password contains login",
+			ContentType: "application/json",
+			Body: map[string]string{
+				"login":    "Gopher",
+				"password": "mygopherpassword",
+			},
+			Want: handlerstest.Want{
+				Status:      400,
+				Body:        `{"error":"password must not contain the login","violations":[{"field":"password","code":"contains_login","message":"password must not contain the login"}]}`,
+				ContentType: "application/json",
 			},
 		},
 	}
@@ -218,3 +284,481 @@ func testLoginPayloadValidation(t *testing.T) {
 
 	handlerstest.TestEndpoint(t, server, tests, http.MethodPost, login)
 }
+
+func testRefreshAndLogout(t *testing.T) {
+	testServer := handlerstest.NewTestServer(t)
+	defer testServer.Close()
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+
+	refreshTokens := func(t *testing.T, refreshToken string) (*tokens, int) {
+		r := new(tokens)
+
+		response, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(map[string]string{"refresh_token": refreshToken}).
+			SetResult(r).
+			Post(refresh)
+		require.NoError(t, err)
+
+		return r, response.StatusCode()
+	}
+
+	balanceStatus := func(t *testing.T, token string) int {
+		response, err := client.R().SetAuthToken(token).Get(balance)
+		require.NoError(t, err)
+
+		return response.StatusCode()
+	}
+
+	loggedIn := new(tokens)
+	response, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]string{
+			"login":    "hi",
+			"password": "longmegapassword",
+		}).
+		SetResult(loggedIn).
+		Post(register)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	require.NotEmpty(t, loggedIn.RefreshToken)
+	assert.Equal(t, int64(3600), loggedIn.ExpiresIn)
+
+	t.Run("invalid refresh token", func(t *testing.T) {
+		_, status := refreshTokens(t, "invalid")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	rotated, status := refreshTokens(t, loggedIn.RefreshToken)
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEqual(t, loggedIn.RefreshToken, rotated.RefreshToken)
+	assert.Equal(t, http.StatusOK, balanceStatus(t, rotated.Token))
+
+	t.Run("reuse of rotated token revokes session", func(t *testing.T) {
+		_, status := refreshTokens(t, loggedIn.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, status)
+
+		_, status = refreshTokens(t, rotated.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, status)
+
+		assert.Equal(t, http.StatusUnauthorized, balanceStatus(t, rotated.Token))
+		assert.Equal(t, http.StatusUnauthorized, balanceStatus(t, loggedIn.Token))
+	})
+
+	t.Run("logout revokes session", func(t *testing.T) {
+		token := handlerstest.LoginNewUser(t, testServer)
+		require.Equal(t, http.StatusOK, balanceStatus(t, token))
+
+		response, err := client.R().SetAuthToken(token).Post(logout)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode())
+
+		assert.Equal(t, http.StatusUnauthorized, balanceStatus(t, token))
+
+		response, err = client.R().SetAuthToken(token).Post(logout)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+	})
+}
+
+func testJWKS(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	type jwks struct {
+		Keys []struct {
+			KeyType   string `json:"kty"`
+			KeyID     string `json:"kid"`
+			Algorithm string `json:"alg"`
+			Use       string `json:"use"`
+			Curve     string `json:"crv"`
+			X         string `json:"x"`
+		} `json:"keys"`
+	}
+
+	r := new(jwks)
+	response, err := resty.New().SetBaseURL(testServer.URL).R().SetResult(r).Get("/.well-known/jwks.json")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	assert.Equal(t, "public, max-age=60", response.Header().Get("Cache-Control"))
+	require.Len(t, r.Keys, 1)
+
+	key := r.Keys[0]
+	assert.Equal(t, "OKP", key.KeyType)
+	assert.Equal(t, "Ed25519", key.Curve)
+	assert.Equal(t, "EdDSA", key.Algorithm)
+	assert.Equal(t, "sig", key.Use)
+
+	publicKey, err := base64.RawURLEncoding.DecodeString(key.X)
+	require.NoError(t, err)
+
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if t.Header["kid"] != key.KeyID {
+			return nil, errors.New("unknown key")
+		}
+
+		return ed25519.PublicKey(publicKey), nil
+	}, jwt.WithValidMethods([]string{"EdDSA"}))
+	require.NoError(t, err)
+	assert.True(t, parsed.Valid)
+}
+
+func testLoginThrottling(t *testing.T) {
+	testServer := handlerstest.NewTestServer(t)
+	defer testServer.Close()
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	type result struct {
+		Token string `json:"token"`
+	}
+
+	credentials := map[string]string{
+		"login":    "hi",
+		"password": "longmegapassword",
+	}
+	registered := new(result)
+	response, err := client.R().SetBody(credentials).SetResult(registered).Post(register)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	loginWith := func(t *testing.T, password string) *resty.Response {
+		response, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(map[string]string{
+				"login":    credentials["login"],
+				"password": password,
+			}).
+			Post(login)
+		require.NoError(t, err)
+
+		return response
+	}
+
+	// three failures are free, the fourth one delays the next attempt
+	for range 4 {
+		assert.Equal(t, http.StatusUnauthorized, loginWith(t, "wrongpassword").StatusCode())
+	}
+
+	response = loginWith(t, credentials["password"])
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode())
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+
+	// the fifth failure locks the account for an hour
+	time.Sleep(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, loginWith(t, "wrongpassword").StatusCode())
+
+	response = loginWith(t, credentials["password"])
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode())
+	assert.Equal(t, "3600", response.Header().Get("Retry-After"))
+
+	unlock := func(t *testing.T, adminToken string, userID string) int {
+		response, err := client.R().SetAuthToken(adminToken).Post("/api/admin/users/" + userID + "/unlock")
+		require.NoError(t, err)
+
+		return response.StatusCode()
+	}
+
+	userID := handlerstest.UserID(t, registered.Token)
+	// support staff is allowed to unlock accounts
+	supportToken := handlerstest.LoginSupport(t, testServer)
+
+	assert.Equal(t, http.StatusUnauthorized, unlock(t, "", userID))
+	assert.Equal(t, http.StatusForbidden, unlock(t, registered.Token, userID))
+	assert.Equal(t, http.StatusNotFound, unlock(t, supportToken, "not-uuid"))
+	assert.Equal(t, http.StatusNotFound, unlock(t, supportToken, "00000000-0000-0000-0000-000000000000"))
+	require.Equal(t, http.StatusOK, unlock(t, supportToken, userID))
+
+	assert.Equal(t, http.StatusOK, loginWith(t, credentials["password"]).StatusCode())
+}
+
+func testParallelLoginThrottling(t *testing.T) {
+	testServer := handlerstest.NewTestServer(t)
+	defer testServer.Close()
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	credentials := map[string]string{
+		"login":    "hi",
+		"password": "longmegapassword",
+	}
+	response, err := client.R().SetBody(credentials).Post(register)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	var rejected atomic.Int32
+	wg := &sync.WaitGroup{}
+
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			response, err := client.R().
+				SetBody(map[string]string{
+					"login":    credentials["login"],
+					"password": "wrongpassword",
+				}).
+				Post(login)
+			if err == nil && response.StatusCode() == http.StatusUnauthorized {
+				rejected.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// only attempts up to the lockout threshold get the password checked
+	assert.LessOrEqual(t, rejected.Load(), int32(5))
+
+	response, err = client.R().SetBody(credentials).Post(login)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode())
+}
+
+func testPasswordChange(t *testing.T) {
+	testServer := handlerstest.NewTestServer(t)
+	defer testServer.Close()
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	type result struct {
+		Token string `json:"token"`
+	}
+
+	registered := new(result)
+	response, err := client.R().
+		SetBody(map[string]string{
+			"login":    "hi",
+			"password": "longmegapassword",
+		}).
+		SetResult(registered).
+		Post(register)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	change := func(t *testing.T, token string, oldPassword string, newPassword string) (*result, int) {
+		r := new(result)
+
+		response, err := client.R().
+			SetAuthToken(token).
+			SetBody(map[string]string{
+				"old_password": oldPassword,
+				"new_password": newPassword,
+			}).
+			SetResult(r).
+			Post(changePassword)
+		require.NoError(t, err)
+
+		return r, response.StatusCode()
+	}
+
+	_, status := change(t, "", "longmegapassword", "evenlongermegapassword")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	_, status = change(t, registered.Token, "wrongpassword", "evenlongermegapassword")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	_, status = change(t, registered.Token, "longmegapassword", "short")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	changed, status := change(t, registered.Token, "longmegapassword", "evenlongermegapassword")
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, changed.Token)
+
+	response, err = client.R().SetAuthToken(registered.Token).Get(balance)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode(), "old session is revoked")
+
+	response, err = client.R().SetAuthToken(changed.Token).Get(balance)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode())
+
+	response, err = client.R().
+		SetBody(map[string]string{
+			"login":    "hi",
+			"password": "evenlongermegapassword",
+		}).
+		Post(login)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode())
+
+	t.Run("reset is requested for any login", func(t *testing.T) {
+		for _, login := range []string{"hi", "unknown"} {
+			response, err := client.R().SetBody(map[string]string{"login": login}).Post(forgotPassword)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusAccepted, response.StatusCode())
+		}
+	})
+}
+
+func testPasswordThrottling(t *testing.T) {
+	testServer := handlerstest.NewTestServer(t)
+	defer testServer.Close()
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	type result struct {
+		Token string `json:"token"`
+	}
+
+	registered := new(result)
+	response, err := client.R().
+		SetBody(map[string]string{
+			"login":    "hi",
+			"password": "longmegapassword",
+		}).
+		SetResult(registered).
+		Post(register)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	changeWith := func(t *testing.T, oldPassword string) *resty.Response {
+		response, err := client.R().
+			SetAuthToken(registered.Token).
+			SetBody(map[string]string{
+				"old_password": oldPassword,
+				"new_password": "evenlongermegapassword",
+			}).
+			Post(changePassword)
+		require.NoError(t, err)
+
+		return response
+	}
+
+	// guesses of the old password share the lockout with logins
+	for range 4 {
+		assert.Equal(t, http.StatusForbidden, changeWith(t, "wrongpassword").StatusCode())
+	}
+
+	response = changeWith(t, "longmegapassword")
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode())
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+
+	requestReset := func(t *testing.T, login string) *resty.Response {
+		response, err := client.R().SetBody(map[string]string{"login": login}).Post(forgotPassword)
+		require.NoError(t, err)
+
+		return response
+	}
+
+	// every reset request is counted, whether the login exists or not
+	for range 4 {
+		assert.Equal(t, http.StatusAccepted, requestReset(t, "unknown").StatusCode())
+	}
+
+	response = requestReset(t, " UNKNOWN ")
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode())
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+}
+
+func testMFA(t *testing.T) {
+	testServer := handlerstest.NewTestServer(t)
+	defer testServer.Close()
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	credentials := map[string]string{
+		"login":    "hi",
+		"password": "longmegapassword",
+	}
+
+	type result struct {
+		Token string `json:"token"`
+	}
+
+	registered := new(result)
+	response, err := client.R().SetBody(credentials).SetResult(registered).Post(register)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	type enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	enrolled := new(enrollment)
+	response, err = client.R().SetAuthToken(registered.Token).SetResult(enrolled).Post(totpEnroll)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	assert.Equal(t, "no-store", response.Header().Get("Cache-Control"))
+	require.NotEmpty(t, enrolled.Secret)
+
+	code, err := totp.Code(enrolled.Secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+
+	confirmed := new(struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	})
+	response, err = client.R().
+		SetAuthToken(registered.Token).
+		SetBody(map[string]string{"code": code}).
+		SetResult(confirmed).
+		Post(totpConfirm)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	require.NotEmpty(t, confirmed.RecoveryCodes)
+
+	response, err = client.R().SetAuthToken(registered.Token).Post(totpEnroll)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode())
+
+	challenged := new(struct {
+		MFARequired bool   `json:"mfa_required"`
+		Challenge   string `json:"challenge"`
+		ExpiresIn   int64  `json:"expires_in"`
+	})
+	response, err = client.R().SetBody(credentials).SetResult(challenged).Post(login)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, response.StatusCode())
+	assert.Empty(t, response.Header().Get("Authorization"))
+	assert.True(t, challenged.MFARequired)
+	assert.Equal(t, int64(60), challenged.ExpiresIn)
+	require.NotEmpty(t, challenged.Challenge)
+
+	complete := func(t *testing.T, code string) (*result, int) {
+		r := new(result)
+
+		response, err := client.R().
+			SetBody(map[string]string{
+				"challenge": challenged.Challenge,
+				"code":      code,
+			}).
+			SetResult(r).
+			Post(loginMFA)
+		require.NoError(t, err)
+
+		return r, response.StatusCode()
+	}
+
+	_, status := complete(t, "000000")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	completed, status := complete(t, confirmed.RecoveryCodes[0])
+	require.Equal(t, http.StatusOK, status)
+
+	response, err = client.R().SetAuthToken(completed.Token).Get(balance)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode())
+
+	disableWith := func(t *testing.T, code string) *resty.Response {
+		response, err := client.R().SetAuthToken(completed.Token).SetBody(map[string]string{"code": code}).Post(totpDisable)
+		require.NoError(t, err)
+
+		return response
+	}
+
+	// guesses of the code share the lockout with logins, three failures are free, the fourth one delays the next attempt
+	for range 4 {
+		assert.Equal(t, http.StatusForbidden, disableWith(t, "000000").StatusCode())
+	}
+
+	response = disableWith(t, confirmed.RecoveryCodes[1])
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode())
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+
+	response, err = client.R().SetBody(credentials).Post(login)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode(), "logins are delayed too")
+}
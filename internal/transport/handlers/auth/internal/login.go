@@ -9,25 +9,66 @@ import (
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
 )
 
+type tokensJSON struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the access token lifetime in seconds
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type challengeJSON struct {
+	MFARequired bool   `json:"mfa_required"`
+	Challenge   string `json:"challenge"`
+	// ExpiresIn is the challenge lifetime in seconds
+	ExpiresIn int64 `json:"expires_in"`
+}
+
 func Login(ctx *fiber.Ctx, userService user.Service, login string, password string) error {
-	token, err := userService.Login(ctx.Context(), login, password)
+	result, err := userService.Login(ctx.Context(), login, password)
 	if err != nil {
-		if errors.Is(err, user.ErrInvalidPair) {
-			return ctx.SendStatus(fiber.StatusUnauthorized)
-		}
+		return SendLoginError(ctx, err)
+	}
+
+	return SendLoginResult(ctx, result)
+}
 
-		if errors.Is(err, user.ErrInternal) {
-			return ctx.SendStatus(fiber.StatusInternalServerError)
-		}
+// SendLoginResult with tokens, or with the challenge, which has to be completed with a MFA code to get tokens
+func SendLoginResult(ctx *fiber.Ctx, result *user.LoginResult) error {
+	if result.MFAChallenge == nil {
+		return SendTokens(ctx, result.Tokens)
+	}
 
-		ctx.Status(fiber.StatusBadRequest)
+	ctx.Status(fiber.StatusAccepted)
 
-		return ctx.SendString(err.Error())
+	return ctx.JSON(challengeJSON{
+		MFARequired: true,
+		Challenge:   result.MFAChallenge.Token,
+		ExpiresIn:   int64(result.MFAChallenge.ExpiresIn.Seconds()),
+	})
+}
+
+func SendLoginError(ctx *fiber.Ctx, err error) error {
+	if errors.Is(err, user.ErrInvalidPair) {
+		return ctx.SendStatus(fiber.StatusUnauthorized)
+	}
+
+	if errors.Is(err, user.ErrInternal) {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
 	}
 
-	ctx.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	ctx.Status(fiber.StatusBadRequest)
+
+	return ctx.SendString(err.Error())
+}
+
+func SendTokens(ctx *fiber.Ctx, tokens *user.Tokens) error {
+	ctx.Set("Authorization", fmt.Sprintf("Bearer %s", tokens.Access))
 
 	ctx.Status(fiber.StatusOK)
 
-	return ctx.JSON(fiber.Map{"token": token})
+	return ctx.JSON(tokensJSON{
+		Token:        tokens.Access,
+		RefreshToken: tokens.Refresh,
+		ExpiresIn:    int64(tokens.AccessExpiresIn.Seconds()),
+	})
 }
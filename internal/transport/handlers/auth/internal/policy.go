@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+type violationJSON struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type policyErrorJSON struct {
+	Error      string          `json:"error"`
+	Violations []violationJSON `json:"violations"`
+}
+
+// SendInvalidCredentials with every policy violation, so clients can show them next to the fields
+func SendInvalidCredentials(ctx *fiber.Ctx, err error) error {
+	ctx.Status(fiber.StatusBadRequest)
+
+	var policyErr *user.PolicyError
+	if !errors.As(err, &policyErr) {
+		return ctx.SendString(err.Error())
+	}
+
+	result := policyErrorJSON{
+		Error:      err.Error(),
+		Violations: make([]violationJSON, 0, len(policyErr.Violations)),
+	}
+	for _, v := range policyErr.Violations {
+		result.Violations = append(result.Violations, violationJSON{
+			Field:   v.Field,
+			Code:    v.Code,
+			Message: v.Message,
+		})
+	}
+
+	return ctx.JSON(result)
+}
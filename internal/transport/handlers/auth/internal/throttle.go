@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+// ThrottledError is returned instead of running an attempt, that is not allowed yet
+type ThrottledError struct {
+	Wait time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many attempts, retry after %s", e.Wait)
+}
+
+// Throttle the attempt within the same lockout as logins, so secrets can't be guessed through other endpoints instead.
+// The attempt is counted as failed if failed reports so for its error, otherwise it is released.
+// Errors of the attempt are returned as is
+func Throttle(ctx *fiber.Ctx, throttleService throttle.Service, login string, attempt func() error, failed func(err error) bool) error {
+	reservation, wait, err := throttleService.Reserve(ctx.Context(), login, ctx.IP())
+	if err != nil {
+		return err
+	}
+
+	if wait > 0 {
+		return &ThrottledError{Wait: wait}
+	}
+
+	err = attempt()
+
+	// the attempt is over anyway, so a failure to update the throttle is not reported to the client
+	if failed(err) {
+		_ = throttleService.Fail(ctx.Context(), reservation)
+	} else {
+		_ = throttleService.Cancel(ctx.Context(), reservation)
+	}
+
+	return err
+}
+
+// ThrottledLogin of the user. Logins are throttled normalized, logins registered before normalization are stored as is
+func ThrottledLogin(ctx *fiber.Ctx, userService user.Service, userID string) (string, error) {
+	u, err := userService.GetUser(ctx.Context(), userID)
+	if err != nil {
+		return "", err
+	}
+
+	return userService.NormalizeLogin(u.Login), nil
+}
+
+func SendTooManyRequests(ctx *fiber.Ctx, wait time.Duration) error {
+	ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	return ctx.SendStatus(fiber.StatusTooManyRequests)
+}
@@ -1,20 +1,25 @@
 package login
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/internal"
 )
 
 type Handler struct {
-	userService user.Service
+	userService     user.Service
+	throttleService throttle.Service
 }
 
-func New(userService user.Service) *Handler {
+func New(userService user.Service, throttleService throttle.Service) *Handler {
 	return &Handler{
-		userService: userService,
+		userService:     userService,
+		throttleService: throttleService,
 	}
 }
 
@@ -32,5 +37,32 @@ func (h *Handler) Handle(ctx *fiber.Ctx) error {
 		return ctx.SendStatus(fiber.StatusBadRequest)
 	}
 
-	return internal.Login(ctx, h.userService, p.Login, p.Password)
+	// spellings of the same login share failures
+	throttledLogin := h.userService.NormalizeLogin(p.Login)
+
+	reservation, wait, err := h.throttleService.Reserve(ctx.Context(), throttledLogin, ctx.IP())
+	if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	if wait > 0 {
+		return internal.SendTooManyRequests(ctx, wait)
+	}
+
+	result, err := h.userService.Login(ctx.Context(), p.Login, p.Password)
+	if err != nil {
+		// the attempt is rejected anyway, so a failure to update the throttle is not reported to the client
+		if errors.Is(err, user.ErrInvalidPair) {
+			_ = h.throttleService.Fail(ctx.Context(), reservation)
+		} else {
+			_ = h.throttleService.Cancel(ctx.Context(), reservation)
+		}
+
+		return internal.SendLoginError(ctx, err)
+	}
+
+	// the password is correct, MFA challenge limits its own attempts
+	_ = h.throttleService.Succeed(ctx.Context(), reservation)
+
+	return internal.SendLoginResult(ctx, result)
 }
@@ -0,0 +1,34 @@
+package logout
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+type Handler struct {
+	userService user.Service
+}
+
+func New(userService user.Service) *Handler {
+	return &Handler{
+		userService: userService,
+	}
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	sessionIDRaw := ctx.Locals("sessionid")
+	sessionID, ok := sessionIDRaw.(string)
+	if !ok {
+		log.Logger().Fatalw("no session id", "sessionIDRaw", sessionIDRaw)
+		panic("no session id")
+	}
+
+	err := h.userService.Logout(ctx.Context(), sessionID)
+	if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
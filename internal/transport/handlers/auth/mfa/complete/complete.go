@@ -0,0 +1,50 @@
+package complete
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/internal"
+)
+
+type Handler struct {
+	userService user.Service
+}
+
+func New(userService user.Service) *Handler {
+	return &Handler{
+		userService: userService,
+	}
+}
+
+type payload struct {
+	Challenge string `json:"challenge"`
+	// Code is a TOTP or a recovery code
+	Code string `json:"code"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil || p.Challenge == "" || p.Code == "" {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	tokens, err := h.userService.CompleteMFA(ctx.Context(), p.Challenge, p.Code)
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidChallenge) || errors.Is(err, user.ErrInvalidMFACode) {
+			ctx.Status(fiber.StatusUnauthorized)
+
+			return ctx.SendString(err.Error())
+		}
+
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return internal.SendTokens(ctx, tokens)
+}
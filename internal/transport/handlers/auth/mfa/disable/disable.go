@@ -0,0 +1,82 @@
+package disable
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/internal"
+)
+
+type Handler struct {
+	userService     user.Service
+	throttleService throttle.Service
+}
+
+func New(userService user.Service, throttleService throttle.Service) *Handler {
+	return &Handler{
+		userService:     userService,
+		throttleService: throttleService,
+	}
+}
+
+type payload struct {
+	// Code is a TOTP or a recovery code
+	Code string `json:"code"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil || p.Code == "" {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	userIDRaw := ctx.Locals("userid")
+	userID, ok := userIDRaw.(string)
+	if !ok {
+		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
+		panic("no user id")
+	}
+
+	login, err := internal.ThrottledLogin(ctx, h.userService, userID)
+	if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// a stolen access token must not be enough to guess the code and disable MFA
+	err = internal.Throttle(ctx, h.throttleService, login, func() error {
+		return h.userService.DisableTOTP(ctx.Context(), userID, p.Code)
+	}, isInvalidCode)
+	if err != nil {
+		var throttled *internal.ThrottledError
+		if errors.As(err, &throttled) {
+			return internal.SendTooManyRequests(ctx, throttled.Wait)
+		}
+
+		if errors.Is(err, user.ErrInvalidMFACode) {
+			ctx.Status(fiber.StatusForbidden)
+
+			return ctx.SendString(err.Error())
+		}
+
+		if errors.Is(err, user.ErrMFANotEnabled) {
+			ctx.Status(fiber.StatusConflict)
+
+			return ctx.SendString(err.Error())
+		}
+
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func isInvalidCode(err error) bool {
+	return errors.Is(err, user.ErrInvalidMFACode)
+}
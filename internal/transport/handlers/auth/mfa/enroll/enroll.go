@@ -0,0 +1,53 @@
+package enroll
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+type Handler struct {
+	userService user.Service
+}
+
+func New(userService user.Service) *Handler {
+	return &Handler{
+		userService: userService,
+	}
+}
+
+type enrollmentJSON struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	userIDRaw := ctx.Locals("userid")
+	userID, ok := userIDRaw.(string)
+	if !ok {
+		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
+		panic("no user id")
+	}
+
+	enrollment, err := h.userService.EnrollTOTP(ctx.Context(), userID)
+	if err != nil {
+		if errors.Is(err, user.ErrMFAAlreadyEnabled) {
+			ctx.Status(fiber.StatusConflict)
+
+			return ctx.SendString(err.Error())
+		}
+
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// the secret must not be cached anywhere on the way
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+
+	return ctx.JSON(enrollmentJSON{
+		Secret: enrollment.Secret,
+		URI:    enrollment.URI,
+	})
+}
@@ -0,0 +1,83 @@
+package change
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/internal"
+)
+
+type Handler struct {
+	userService     user.Service
+	throttleService throttle.Service
+}
+
+func New(userService user.Service, throttleService throttle.Service) *Handler {
+	return &Handler{
+		userService:     userService,
+		throttleService: throttleService,
+	}
+}
+
+type payload struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	userIDRaw := ctx.Locals("userid")
+	userID, ok := userIDRaw.(string)
+	if !ok {
+		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
+		panic("no user id")
+	}
+
+	login, err := internal.ThrottledLogin(ctx, h.userService, userID)
+	if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// a stolen access token must not be enough to guess the old password
+	var tokens *user.Tokens
+	err = internal.Throttle(ctx, h.throttleService, login, func() error {
+		var err error
+		// all sessions are revoked, so the client gets tokens of a new one
+		tokens, err = h.userService.ChangePassword(ctx.Context(), userID, p.OldPassword, p.NewPassword)
+
+		return err
+	}, isWrongPassword)
+	if err != nil {
+		var throttled *internal.ThrottledError
+		if errors.As(err, &throttled) {
+			return internal.SendTooManyRequests(ctx, throttled.Wait)
+		}
+
+		if errors.Is(err, user.ErrWrongPassword) {
+			return ctx.SendStatus(fiber.StatusForbidden)
+		}
+
+		if errors.Is(err, user.ErrInvalidPassword) {
+			return internal.SendInvalidCredentials(ctx, err)
+		}
+
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return internal.SendTokens(ctx, tokens)
+}
+
+func isWrongPassword(err error) bool {
+	return errors.Is(err, user.ErrWrongPassword)
+}
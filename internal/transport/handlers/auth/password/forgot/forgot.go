@@ -0,0 +1,58 @@
+package forgot
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/internal"
+)
+
+type Handler struct {
+	userService     user.Service
+	throttleService throttle.Service
+}
+
+func New(userService user.Service, throttleService throttle.Service) *Handler {
+	return &Handler{
+		userService:     userService,
+		throttleService: throttleService,
+	}
+}
+
+type payload struct {
+	Login string `json:"login"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil || p.Login == "" {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	// every request is counted, so resets can't be used to flood the user or to enumerate logins
+	err := internal.Throttle(ctx, h.throttleService, h.userService.NormalizeLogin(p.Login), func() error {
+		return h.userService.RequestPasswordReset(ctx.Context(), p.Login)
+	}, isRequested)
+	if err != nil {
+		var throttled *internal.ThrottledError
+		if errors.As(err, &throttled) {
+			return internal.SendTooManyRequests(ctx, throttled.Wait)
+		}
+
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// accepted whether the login exists or not
+	return ctx.SendStatus(fiber.StatusAccepted)
+}
+
+func isRequested(err error) bool {
+	return err == nil
+}
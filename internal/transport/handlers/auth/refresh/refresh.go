@@ -0,0 +1,46 @@
+package refresh
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/internal"
+)
+
+type Handler struct {
+	userService user.Service
+}
+
+func New(userService user.Service) *Handler {
+	return &Handler{
+		userService: userService,
+	}
+}
+
+type payload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	p := new(payload)
+
+	if err := ctx.BodyParser(p); err != nil || p.RefreshToken == "" {
+		log.Logger().Debugw("invalid payload", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	tokens, err := h.userService.Refresh(ctx.Context(), p.RefreshToken)
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidToken) {
+			return ctx.SendStatus(fiber.StatusUnauthorized)
+		}
+
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return internal.SendTokens(ctx, tokens)
+}
@@ -46,9 +46,7 @@ func (h *Handler) Handle(ctx *fiber.Ctx) error {
 			return ctx.SendStatus(fiber.StatusInternalServerError)
 		}
 
-		ctx.Status(fiber.StatusBadRequest)
-
-		return ctx.SendString(err.Error())
+		return internal.SendInvalidCredentials(ctx, err)
 	}
 
 	return internal.Login(ctx, h.userService, p.Login, p.Password)
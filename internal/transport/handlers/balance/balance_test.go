@@ -12,6 +12,7 @@ import (
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/pagination"
 	"github.com/kuvalkin/gophermart-loyalty/internal/test"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/handlerstest"
 )
@@ -19,6 +20,7 @@ import (
 const balance = "/api/user/balance"
 const withdraw = "/api/user/balance/withdraw"
 const list = "/api/user/withdrawals"
+const statement = "/api/user/statement"
 
 func TestOrders(t *testing.T) {
 	log.InitTestLogger(t)
@@ -30,6 +32,9 @@ func TestOrders(t *testing.T) {
 	})
 
 	t.Run("flow", testBalanceFlow)
+	t.Run("idempotency", testWithdrawIdempotency)
+	t.Run("cancellation", testWithdrawalCancellation)
+	t.Run("programs", testPrograms)
 }
 
 func testAuth(t *testing.T) {
@@ -65,6 +70,14 @@ func testAuth(t *testing.T) {
 			require.NoError(t, err)
 			assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
 		})
+
+		t.Run("statement", func(t *testing.T) {
+			response, err := client.R().
+				Get(statement)
+
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+		})
 	})
 
 	t.Run("request with invalid token", func(t *testing.T) {
@@ -98,6 +111,15 @@ func testAuth(t *testing.T) {
 			require.NoError(t, err)
 			assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
 		})
+
+		t.Run("statement", func(t *testing.T) {
+			response, err := client.R().
+				SetAuthToken("hi").
+				Get(statement)
+
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode())
+		})
 	})
 }
 
@@ -163,6 +185,18 @@ func testWithdrawValidation(t *testing.T) {
 				Status: http.StatusBadRequest,
 			},
 		},
+		{
+			Name:        "sum with fractions of kopecks",
+			Token:       token,
+			ContentType: "application/json",
+			Body: map[string]any{
+				"order": test.NewOrderNumber(),
+				"sum":   0.125,
+			},
+			Want: handlerstest.Want{
+				Status: http.StatusBadRequest,
+			},
+		},
 		{
 			Name:        "zero sum",
 			Token:       token,
@@ -187,8 +221,8 @@ func testBalanceFlow(t *testing.T) {
 	client := resty.New().SetBaseURL(testServer.URL)
 
 	type balanceResponse struct {
-		Current   float64 `json:"current"`
-		Withdrawn float64 `json:"withdrawn"`
+		Current   money.Amount `json:"current"`
+		Withdrawn money.Amount `json:"withdrawn"`
 	}
 
 	t.Run("empty account", func(t *testing.T) {
@@ -199,8 +233,8 @@ func testBalanceFlow(t *testing.T) {
 
 			require.NoError(t, err)
 			assert.Equal(t, http.StatusOK, response.StatusCode())
-			assert.Equal(t, float64(0), p.Current)
-			assert.Equal(t, float64(0), p.Withdrawn)
+			assert.Equal(t, money.Amount(0), p.Current)
+			assert.Equal(t, money.Amount(0), p.Withdrawn)
 		})
 
 		t.Run("withdraw", func(t *testing.T) {
@@ -219,6 +253,13 @@ func testBalanceFlow(t *testing.T) {
 			require.NoError(t, err)
 			assert.Equal(t, http.StatusNoContent, response.StatusCode())
 		})
+
+		t.Run("statement", func(t *testing.T) {
+			response, err := client.R().SetAuthToken(token).Get(statement)
+
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusNoContent, response.StatusCode())
+		})
 	})
 
 	increment := handlerstest.IncreaseBalance(t, testServer, token)
@@ -231,8 +272,8 @@ func testBalanceFlow(t *testing.T) {
 
 			require.NoError(t, err)
 			assert.Equal(t, http.StatusOK, response.StatusCode())
-			assert.Equal(t, money.IntToFloat(increment), p.Current)
-			assert.Equal(t, float64(0), p.Withdrawn)
+			assert.Equal(t, money.Amount(increment), p.Current)
+			assert.Equal(t, money.Amount(0), p.Withdrawn)
 		})
 
 		successOrderNumber := test.NewOrderNumber()
@@ -241,7 +282,7 @@ func testBalanceFlow(t *testing.T) {
 			t.Run("sum too big", func(t *testing.T) {
 				response, err := client.R().SetAuthToken(token).SetBody(map[string]any{
 					"order": test.NewOrderNumber(),
-					"sum":   money.IntToFloat(increment) * 1000,
+					"sum":   money.Amount(increment * 1000),
 				}).Post(withdraw)
 
 				require.NoError(t, err)
@@ -251,7 +292,7 @@ func testBalanceFlow(t *testing.T) {
 			t.Run("success", func(t *testing.T) {
 				response, err := client.R().SetAuthToken(token).SetBody(map[string]any{
 					"order": successOrderNumber,
-					"sum":   money.IntToFloat(increment),
+					"sum":   money.Amount(increment),
 				}).Post(withdraw)
 
 				require.NoError(t, err)
@@ -267,14 +308,330 @@ func testBalanceFlow(t *testing.T) {
 			assert.JSONEq(
 				t,
 				fmt.Sprintf(
-					`[{ "order": "%v", "sum": %v, "processed_at": "%v" }]`,
+					`[{ "order": "%v", "program": "default", "sum": %v, "status": "COMPLETED", "processed_at": "%v" }]`,
 					successOrderNumber,
-					money.IntToFloat(increment),
+					money.Amount(increment),
 					time.Now().Format(time.RFC3339),
 				),
 				string(response.Body()),
 			)
 		})
+
+		t.Run("statement", func(t *testing.T) {
+			type statementEntry struct {
+				Type      string       `json:"type"`
+				Order     string       `json:"order"`
+				Reference string       `json:"reference"`
+				Amount    money.Amount `json:"amount"`
+				Balance   money.Amount `json:"balance"`
+			}
+
+			t.Run("full", func(t *testing.T) {
+				var p []statementEntry
+
+				response, err := client.R().SetAuthToken(token).SetResult(&p).Get(statement)
+
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, response.StatusCode())
+				require.Len(t, p, 2)
+				assert.Empty(t, response.Header().Get("X-Next-Cursor"))
+
+				assert.Equal(t, "WITHDRAWAL", p[0].Type)
+				assert.Equal(t, successOrderNumber, p[0].Order)
+				assert.Empty(t, p[0].Reference)
+				assert.Equal(t, -money.Amount(increment), p[0].Amount)
+				assert.Equal(t, money.Amount(0), p[0].Balance)
+
+				assert.Equal(t, "ACCRUAL", p[1].Type)
+				assert.Equal(t, money.Amount(increment), p[1].Amount)
+				assert.Equal(t, money.Amount(increment), p[1].Balance)
+			})
+
+			t.Run("paginated", func(t *testing.T) {
+				var first []statementEntry
+
+				response, err := client.R().SetAuthToken(token).SetResult(&first).SetQueryParam("limit", "1").Get(statement)
+
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, response.StatusCode())
+				require.Len(t, first, 1)
+				assert.Equal(t, "WITHDRAWAL", first[0].Type)
+
+				cursor := response.Header().Get("X-Next-Cursor")
+				require.NotEmpty(t, cursor)
+
+				var second []statementEntry
+
+				response, err = client.R().SetAuthToken(token).SetResult(&second).SetQueryParams(map[string]string{
+					"limit":  "1",
+					"cursor": cursor,
+				}).Get(statement)
+
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, response.StatusCode())
+				require.Len(t, second, 1)
+				assert.Equal(t, "ACCRUAL", second[0].Type)
+				assert.Empty(t, response.Header().Get("X-Next-Cursor"))
+			})
+
+			t.Run("date range", func(t *testing.T) {
+				response, err := client.R().SetAuthToken(token).
+					SetQueryParam("to", time.Now().Add(-time.Hour).Format(time.RFC3339)).
+					Get(statement)
+
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusNoContent, response.StatusCode())
+			})
+
+			t.Run("invalid filter", func(t *testing.T) {
+				for _, params := range []map[string]string{
+					{"limit": "-1"},
+					{"from": "yesterday"},
+					{"cursor": "abc"},
+					{"cursor": (&pagination.Cursor{Time: time.Now(), Key: "abc"}).String()},
+					{"from": time.Now().Format(time.RFC3339), "to": time.Now().Add(-time.Hour).Format(time.RFC3339)},
+				} {
+					response, err := client.R().SetAuthToken(token).SetQueryParams(params).Get(statement)
+
+					require.NoError(t, err)
+					assert.Equal(t, http.StatusBadRequest, response.StatusCode(), params)
+				}
+			})
+		})
+	})
+
+}
+
+func testWithdrawIdempotency(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	increment := handlerstest.IncreaseBalance(t, testServer, token)
+	sum := money.Amount(increment / 2)
+	number := test.NewOrderNumber()
+
+	withdrawWithKey := func(key string, number string) *resty.Response {
+		response, err := client.R().
+			SetAuthToken(token).
+			SetHeader("Idempotency-Key", key).
+			SetBody(map[string]any{
+				"order": number,
+				"sum":   sum,
+			}).
+			Post(withdraw)
+		require.NoError(t, err)
+
+		return response
+	}
+
+	t.Run("retry is replayed", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, withdrawWithKey("first", number).StatusCode())
+		assert.Equal(t, http.StatusOK, withdrawWithKey("first", number).StatusCode())
+
+		p := new(struct {
+			Withdrawn money.Amount `json:"withdrawn"`
+		})
+
+		response, err := client.R().SetAuthToken(token).SetResult(p).Get(balance)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		assert.Equal(t, sum, p.Withdrawn)
 	})
 
+	t.Run("key reused for another request", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, withdrawWithKey("first", test.NewOrderNumber()).StatusCode())
+	})
+
+	t.Run("same order with another key", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, withdrawWithKey("second", number).StatusCode())
+	})
+}
+
+func testWithdrawalCancellation(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL)
+	userID := handlerstest.UserID(t, token)
+
+	increment := handlerstest.IncreaseBalance(t, testServer, token)
+	number := test.NewOrderNumber()
+
+	response, err := client.R().SetAuthToken(token).SetBody(map[string]any{
+		"order": number,
+		"sum":   money.Amount(increment),
+	}).Post(withdraw)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	cancel := func(token string, userID string, number string, reason string) int {
+		response, err := client.R().
+			SetAuthToken(token).
+			SetBody(map[string]string{"reason": reason}).
+			Post(fmt.Sprintf("/api/admin/users/%s/withdrawals/%s/cancel", userID, number))
+		require.NoError(t, err)
+
+		return response.StatusCode()
+	}
+
+	adminToken := handlerstest.LoginAdmin(t, testServer)
+
+	t.Run("only admins can cancel", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, cancel("", userID, number, "mistake"))
+		assert.Equal(t, http.StatusForbidden, cancel(token, userID, number, "mistake"))
+		assert.Equal(t, http.StatusForbidden, cancel(handlerstest.LoginSupport(t, testServer), userID, number, "mistake"))
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, cancel(adminToken, userID, number, " "))
+		assert.Equal(t, http.StatusNotFound, cancel(adminToken, userID, test.NewOrderNumber(), "mistake"))
+		assert.Equal(t, http.StatusNotFound, cancel(adminToken, "not-uuid", number, "mistake"))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, cancel(adminToken, userID, number, "mistake"))
+
+		p := new(struct {
+			Current   money.Amount `json:"current"`
+			Withdrawn money.Amount `json:"withdrawn"`
+		})
+
+		response, err := client.R().SetAuthToken(token).SetResult(p).Get(balance)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		assert.Equal(t, money.Amount(increment), p.Current)
+		assert.Equal(t, money.Amount(0), p.Withdrawn)
+	})
+
+	t.Run("history shows status", func(t *testing.T) {
+		var entries []struct {
+			Order        string `json:"order"`
+			Status       string `json:"status"`
+			CancelReason string `json:"cancel_reason"`
+		}
+
+		response, err := client.R().SetAuthToken(token).SetResult(&entries).SetQueryParam("status", "CANCELLED").Get(list)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		require.Len(t, entries, 1)
+		assert.Equal(t, number, entries[0].Order)
+		assert.Equal(t, "CANCELLED", entries[0].Status)
+		assert.Equal(t, "mistake", entries[0].CancelReason)
+	})
+
+	t.Run("cancel twice", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, cancel(adminToken, userID, number, "mistake"))
+	})
+}
+
+func testPrograms(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL).SetAuthToken(token)
+
+	type programResponse struct {
+		Program   string       `json:"program"`
+		Current   money.Amount `json:"current"`
+		Withdrawn money.Amount `json:"withdrawn"`
+		Value     money.Amount `json:"value"`
+	}
+
+	type balanceResponse struct {
+		Current  money.Amount       `json:"current"`
+		Programs []*programResponse `json:"programs"`
+	}
+
+	getBalance := func(t *testing.T) *balanceResponse {
+		p := new(balanceResponse)
+
+		response, err := client.R().SetResult(p).Get(balance)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+
+		return p
+	}
+
+	response, err := client.R().
+		SetBody(test.NewOrderNumber()).
+		SetQueryParam("program", handlerstest.BrandProgram).
+		Post("/api/user/orders")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, response.StatusCode())
+
+	// brand point is worth half a ruble
+	points := money.Amount(2 * handlerstest.ProcessedOrderAccrual)
+
+	require.Eventually(t, func() bool {
+		b := getBalance(t)
+
+		return len(b.Programs) == 2 && b.Programs[1].Current == points
+	}, time.Second, time.Millisecond)
+
+	t.Run("balances", func(t *testing.T) {
+		b := getBalance(t)
+
+		assert.Equal(t, money.Amount(0), b.Current, "top level balance is of the default program")
+		assert.Equal(t, "default", b.Programs[0].Program)
+		assert.Equal(t, money.Amount(0), b.Programs[0].Current)
+		assert.Equal(t, handlerstest.BrandProgram, b.Programs[1].Program)
+		assert.Equal(t, money.Amount(handlerstest.ProcessedOrderAccrual), b.Programs[1].Value)
+	})
+
+	t.Run("withdraw", func(t *testing.T) {
+		withdrawFrom := func(program string, sum money.Amount) int {
+			response, err := client.R().SetBody(map[string]any{
+				"order":   test.NewOrderNumber(),
+				"sum":     sum,
+				"program": program,
+			}).Post(withdraw)
+			require.NoError(t, err)
+
+			return response.StatusCode()
+		}
+
+		assert.Equal(t, http.StatusBadRequest, withdrawFrom("unknown", 100))
+		assert.Equal(t, http.StatusPaymentRequired, withdrawFrom("", 100), "default program is empty")
+		assert.Equal(t, http.StatusPaymentRequired, withdrawFrom(handlerstest.BrandProgram, points+1))
+		require.Equal(t, http.StatusOK, withdrawFrom(handlerstest.BrandProgram, 186))
+
+		b := getBalance(t)
+		assert.Equal(t, points-186, b.Programs[1].Current)
+		assert.Equal(t, money.Amount(186), b.Programs[1].Withdrawn)
+
+		var entries []struct {
+			Program string `json:"program"`
+		}
+
+		response, err := client.R().SetResult(&entries).Get(list)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		require.Len(t, entries, 1)
+		assert.Equal(t, handlerstest.BrandProgram, entries[0].Program)
+	})
+
+	t.Run("statement", func(t *testing.T) {
+		var entries []struct {
+			Balance money.Amount `json:"balance"`
+		}
+
+		response, err := client.R().SetResult(&entries).SetQueryParam("program", handlerstest.BrandProgram).Get(statement)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		require.Len(t, entries, 2)
+		assert.Equal(t, points-186, entries[0].Balance)
+
+		response, err = client.R().Get(statement)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode(), "default program has no entries")
+
+		response, err = client.R().SetQueryParam("program", "unknown").Get(statement)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode())
+	})
 }
@@ -4,34 +4,48 @@ import (
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
-	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/userid"
 )
 
 type Handler struct {
 	service balance.Service
+	userID  userid.Source
 }
 
-func New(service balance.Service) *Handler {
+func New(service balance.Service, userID userid.Source) *Handler {
 	return &Handler{
 		service: service,
+		userID:  userID,
 	}
 }
 
+type programJSON struct {
+	Program      string       `json:"program"`
+	Current      money.Amount `json:"current"`
+	Withdrawn    money.Amount `json:"withdrawn"`
+	ExpiringSoon money.Amount `json:"expiring_soon"`
+	Pending      money.Amount `json:"pending"`
+	// Value of the current balance in rubles
+	Value money.Amount `json:"value"`
+}
+
+// balanceJSON of the default program at the top level, as clients unaware of programs expect it
 type balanceJSON struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current      money.Amount   `json:"current"`
+	Withdrawn    money.Amount   `json:"withdrawn"`
+	ExpiringSoon money.Amount   `json:"expiring_soon"`
+	Pending      money.Amount   `json:"pending"`
+	Programs     []*programJSON `json:"programs"`
 }
 
 func (h *Handler) Handle(ctx *fiber.Ctx) error {
-	userIDRaw := ctx.Locals("userid")
-	userID, ok := userIDRaw.(string)
+	userID, ok := h.userID(ctx)
 	if !ok {
-		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
-		panic("no user id")
+		return ctx.SendStatus(fiber.StatusNotFound)
 	}
 
-	b, err := h.service.Get(ctx.Context(), userID)
+	balances, err := h.service.Get(ctx.Context(), userID)
 
 	if err != nil {
 		return ctx.SendStatus(fiber.StatusInternalServerError)
@@ -39,8 +53,31 @@ func (h *Handler) Handle(ctx *fiber.Ctx) error {
 
 	ctx.Status(fiber.StatusOK)
 
-	return ctx.JSON(balanceJSON{
-		Current:   money.IntToFloat(b.Current),
-		Withdrawn: money.IntToFloat(b.Withdrawn),
-	})
+	return ctx.JSON(mapBalancesToJSON(balances))
+}
+
+func mapBalancesToJSON(balances []*balance.Balance) *balanceJSON {
+	result := &balanceJSON{
+		Programs: make([]*programJSON, 0, len(balances)),
+	}
+
+	for _, b := range balances {
+		if b.Program == balance.DefaultProgram {
+			result.Current = money.Amount(b.Current)
+			result.Withdrawn = money.Amount(b.Withdrawn)
+			result.ExpiringSoon = money.Amount(b.ExpiringSoon)
+			result.Pending = money.Amount(b.Pending)
+		}
+
+		result.Programs = append(result.Programs, &programJSON{
+			Program:      b.Program,
+			Current:      money.Amount(b.Current),
+			Withdrawn:    money.Amount(b.Withdrawn),
+			ExpiringSoon: money.Amount(b.ExpiringSoon),
+			Pending:      money.Amount(b.Pending),
+			Value:        money.Amount(b.Value),
+		})
+	}
+
+	return result
 }
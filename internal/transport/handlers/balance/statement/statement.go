@@ -0,0 +1,105 @@
+package statement
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/listquery"
+)
+
+type Handler struct {
+	service balance.Service
+}
+
+func New(service balance.Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type entryJSON struct {
+	Type string `json:"type"`
+	// Order of accruals, withdrawals and their reversals
+	Order string `json:"order,omitempty"`
+	// Reference of entries, that are not made for an order, e.g. expired lot or manual adjustment
+	Reference string       `json:"reference,omitempty"`
+	Amount    money.Amount `json:"amount"`
+	Balance   money.Amount `json:"balance"`
+	CreatedAt string       `json:"created_at"`
+	// Description of manual adjustments
+	Description string `json:"description,omitempty"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	userIDRaw := ctx.Locals("userid")
+	userID, ok := userIDRaw.(string)
+	if !ok {
+		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
+		panic("no user id")
+	}
+
+	q, err := listquery.Parse(ctx)
+	if err != nil {
+		log.Logger().Debugw("invalid query", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	filter := &balance.StatementFilter{
+		Program: ctx.Query("program"),
+		From:    q.From,
+		To:      q.To,
+		After:   q.After,
+		Limit:   q.Limit,
+	}
+
+	statement, err := h.service.Statement(ctx.Context(), userID, filter)
+
+	if errors.Is(err, balance.ErrInvalidStatementFilter) || errors.Is(err, balance.ErrUnknownProgram) {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
+	} else if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	if len(statement.Entries) == 0 {
+		ctx.Status(fiber.StatusNoContent)
+	} else {
+		ctx.Status(fiber.StatusOK)
+	}
+
+	listquery.SetNextCursor(ctx, statement.NextCursor)
+
+	json := mapEntriesToJSON(statement.Entries)
+
+	return ctx.JSON(json)
+}
+
+func mapEntriesToJSON(entries []*balance.StatementEntry) []*entryJSON {
+	result := make([]*entryJSON, 0, len(entries))
+
+	for _, e := range entries {
+		entry := &entryJSON{
+			Type:        e.Kind.String(),
+			Order:       e.OrderNumber(),
+			Amount:      money.Amount(e.Amount),
+			Balance:     money.Amount(e.Balance),
+			CreatedAt:   e.CreatedAt.Format(time.RFC3339),
+			Description: e.Description,
+		}
+
+		if entry.Order == "" {
+			entry.Reference = e.Reference
+		}
+
+		result = append(result, entry)
+	}
+
+	return result
+}
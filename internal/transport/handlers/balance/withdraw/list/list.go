@@ -1,6 +1,7 @@
 package list
 
 import (
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,45 +9,74 @@ import (
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/listquery"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/userid"
 )
 
 type Handler struct {
 	service balance.Service
+	userID  userid.Source
 }
 
-func New(service balance.Service) *Handler {
+func New(service balance.Service, userID userid.Source) *Handler {
 	return &Handler{
 		service: service,
+		userID:  userID,
 	}
 }
 
 type historyEntryJSON struct {
-	OrderNumber string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	OrderNumber  string       `json:"order"`
+	Program      string       `json:"program"`
+	Sum          money.Amount `json:"sum"`
+	Status       string       `json:"status"`
+	ProcessedAt  string       `json:"processed_at"`
+	CancelReason string       `json:"cancel_reason,omitempty"`
+	CancelledAt  string       `json:"cancelled_at,omitempty"`
 }
 
 func (h *Handler) Handle(ctx *fiber.Ctx) error {
-	userIDRaw := ctx.Locals("userid")
-	userID, ok := userIDRaw.(string)
+	userID, ok := h.userID(ctx)
 	if !ok {
-		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
-		panic("no user id")
+		return ctx.SendStatus(fiber.StatusNotFound)
 	}
 
-	list, err := h.service.WithdrawalHistory(ctx.Context(), userID)
-
+	q, err := listquery.Parse(ctx)
 	if err != nil {
+		log.Logger().Debugw("invalid query", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	filter := &balance.WithdrawalsFilter{
+		From:  q.From,
+		To:    q.To,
+		After: q.After,
+		Limit: q.Limit,
+	}
+	for _, status := range q.Statuses {
+		filter.Statuses = append(filter.Statuses, balance.WithdrawalStatus(status))
+	}
+
+	page, err := h.service.WithdrawalHistory(ctx.Context(), userID, filter)
+
+	if errors.Is(err, balance.ErrInvalidListFilter) {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
+	} else if err != nil {
 		return ctx.SendStatus(fiber.StatusInternalServerError)
 	}
 
-	if len(list) == 0 {
+	if len(page.Withdrawals) == 0 {
 		ctx.Status(fiber.StatusNoContent)
 	} else {
 		ctx.Status(fiber.StatusOK)
 	}
 
-	json := mapEntriesToJSON(list)
+	listquery.SetNextCursor(ctx, page.NextCursor)
+
+	json := mapEntriesToJSON(page.Withdrawals)
 
 	return ctx.JSON(json)
 }
@@ -57,10 +87,17 @@ func mapEntriesToJSON(entries []*balance.WithdrawalHistoryEntry) []*historyEntry
 	for _, e := range entries {
 		singleResult := &historyEntryJSON{
 			OrderNumber: e.OrderNumber,
-			Sum:         money.IntToFloat(e.Sum),
+			Program:     e.Program,
+			Sum:         money.Amount(e.Sum),
+			Status:      e.Status.String(),
 			ProcessedAt: e.ProcessedAt.Format(time.RFC3339),
 		}
 
+		if e.Cancellation != nil {
+			singleResult.CancelReason = e.Cancellation.Reason
+			singleResult.CancelledAt = e.Cancellation.CancelledAt.Format(time.RFC3339)
+		}
+
 		result = append(result, singleResult)
 	}
 
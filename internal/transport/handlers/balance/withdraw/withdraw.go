@@ -10,6 +10,8 @@ import (
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
 )
 
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 type Handler struct {
 	service balance.Service
 }
@@ -21,8 +23,10 @@ func New(service balance.Service) *Handler {
 }
 
 type payload struct {
-	OrderNumber string  `json:"order"`
-	Sum         float64 `json:"sum"`
+	OrderNumber string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	// Program to redeem points of, the default one if empty
+	Program string `json:"program"`
 }
 
 func (h *Handler) Handle(ctx *fiber.Ctx) error {
@@ -41,15 +45,25 @@ func (h *Handler) Handle(ctx *fiber.Ctx) error {
 		return ctx.SendStatus(fiber.StatusBadRequest)
 	}
 
-	err := h.service.Withdraw(ctx.Context(), userID, p.OrderNumber, money.FloatToInt(p.Sum))
+	if p.Program == "" {
+		p.Program = balance.DefaultProgram
+	}
+
+	err := h.service.Withdraw(ctx.Context(), userID, p.Program, p.OrderNumber, int64(p.Sum), ctx.Get(IdempotencyKeyHeader))
 
 	if errors.Is(err, balance.ErrNotEnoughBalance) {
 		return ctx.SendStatus(fiber.StatusPaymentRequired)
 	} else if errors.Is(err, balance.ErrInvalidOrderNumber) {
 		return ctx.SendStatus(fiber.StatusUnprocessableEntity)
-	} else if errors.Is(err, balance.ErrInvalidWithdrawalSum) {
+	} else if errors.Is(err, balance.ErrInvalidWithdrawalSum) ||
+		errors.Is(err, balance.ErrInvalidIdempotencyKey) ||
+		errors.Is(err, balance.ErrUnknownProgram) {
 		ctx.Status(fiber.StatusBadRequest)
 
+		return ctx.SendString(err.Error())
+	} else if errors.Is(err, balance.ErrIdempotencyKeyReused) || errors.Is(err, balance.ErrAlreadyWithdrawn) {
+		ctx.Status(fiber.StatusConflict)
+
 		return ctx.SendString(err.Error())
 	} else if err != nil {
 		return ctx.SendStatus(fiber.StatusInternalServerError)
@@ -1,33 +1,56 @@
 package handlerstest
 
-import "github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+import (
+	"context"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/transaction"
+)
 
 func newDummyPoller() order.AccrualPoller {
-	return &dummyPoller{}
+	return &dummyPoller{
+		results: make(chan order.AccrualResult, 100),
+	}
 }
 
-type dummyPoller struct{}
+type dummyPoller struct {
+	results chan order.AccrualResult
+}
 
 const ProcessedOrderAccrual int64 = 10093
-const ProcessedOrderAccrualFloat float64 = 100.93
 
-func (p *dummyPoller) Enqueue(_ string, _ order.Status) (<-chan order.AccrualResult, error) {
-	result := make(chan order.AccrualResult, 1)
+func (p *dummyPoller) Enqueue(_ context.Context, number string, _ order.Status, _ transaction.Transaction) error {
+	accrual := ProcessedOrderAccrual
+
+	p.results <- order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessed,
+		Accrual: &accrual,
+	}
+
+	return nil
+}
 
-	defer func() {
-		accrual := ProcessedOrderAccrual
+func (p *dummyPoller) Check(_ context.Context, number string) (*order.AccrualResult, error) {
+	accrual := ProcessedOrderAccrual
 
-		result <- order.AccrualResult{
-			Status:  order.StatusProcessed,
-			Accrual: &accrual,
-		}
+	return &order.AccrualResult{
+		Number:  number,
+		Status:  order.StatusProcessed,
+		Accrual: &accrual,
+	}, nil
+}
 
-		close(result)
-	}()
+func (p *dummyPoller) Complete(_ context.Context, _ *order.AccrualJob, _ transaction.Transaction) error {
+	return nil
+}
 
-	return result, nil
+func (p *dummyPoller) Results() <-chan order.AccrualResult {
+	return p.results
 }
 
 func (p *dummyPoller) Close() error {
+	close(p.results)
+
 	return nil
 }
@@ -1,6 +1,7 @@
 package handlerstest
 
 import (
+	"context"
 	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
@@ -9,25 +10,56 @@ import (
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/require"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
-	balanceStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/balance"
-	"github.com/kuvalkin/gophermart-loyalty/internal/storage/balance/withdrawals"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/webhook"
 	orderStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/order"
+	throttleStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/throttle"
 	userStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/keys"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/mfa"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/resets"
+	"github.com/kuvalkin/gophermart-loyalty/internal/storage/user/sessions"
+	webhookStorage "github.com/kuvalkin/gophermart-loyalty/internal/storage/webhook"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/config"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/notifier"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/password"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/policy"
 	"github.com/kuvalkin/gophermart-loyalty/internal/test"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport"
 )
 
+// staff users, that exist on every test server
+const (
+	AdminLogin    = "admin"
+	SupportLogin  = "support"
+	StaffPassword = "staff-password"
+)
+
+// WebhookSecret signs results pushed to every test server
+const WebhookSecret = "webhook-secret"
+
+// BrandProgram exists on every test server besides the default one, its point is worth half a ruble
+const BrandProgram = "brand"
+
 func NewTestServer(t *testing.T) *httptest.Server {
+	b := balanceService()
+	u := userService(t)
+
+	createStaffUser(t, u, AdminLogin, user.RoleAdmin)
+	createStaffUser(t, u, SupportLogin, user.RoleSupport)
+
 	server := transport.NewServer(defaultTestConfig(), &transport.Services{
-		User:    userService(t),
-		Order:   orderService(),
-		Balance: balanceService(t),
+		User:     u,
+		Order:    orderService(b),
+		Balance:  b,
+		Throttle: throttleService(),
+		Webhook:  webhookService(),
 	})
 
 	return server.NewTestServer()
@@ -58,6 +90,47 @@ func LoginNewUser(t *testing.T, server *httptest.Server) string {
 	return result.Token
 }
 
+// LoginAdmin and return the access token
+func LoginAdmin(t *testing.T, server *httptest.Server) string {
+	return loginStaffUser(t, server, AdminLogin)
+}
+
+// LoginSupport and return the access token
+func LoginSupport(t *testing.T, server *httptest.Server) string {
+	return loginStaffUser(t, server, SupportLogin)
+}
+
+func loginStaffUser(t *testing.T, server *httptest.Server, login string) string {
+	type payload struct {
+		Token string `json:"token"`
+	}
+	result := new(payload)
+
+	resp, err := resty.New().SetBaseURL(server.URL).R().
+		SetBody(map[string]string{
+			"login":    login,
+			"password": StaffPassword,
+		}).
+		SetResult(result).
+		Post("/api/user/login")
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.NotEmpty(t, result.Token)
+
+	return result.Token
+}
+
+func createStaffUser(t *testing.T, service user.Service, login string, role user.Role) {
+	ctx := context.Background()
+
+	require.NoError(t, service.Register(ctx, login, StaffPassword))
+
+	u, err := service.FindUser(ctx, login)
+	require.NoError(t, err)
+	require.NoError(t, service.SetRole(ctx, u.ID, role))
+}
+
 func NewTestServerWithLoggedInUser(t *testing.T) (*httptest.Server, string) {
 	server := NewTestServer(t)
 
@@ -66,6 +139,16 @@ func NewTestServerWithLoggedInUser(t *testing.T) (*httptest.Server, string) {
 	return server, token
 }
 
+// UserID from the token claims
+func UserID(t *testing.T, token string) string {
+	claims := new(jwt.RegisteredClaims)
+
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+
+	return claims.Subject
+}
+
 // IncreaseBalance and return the increment
 func IncreaseBalance(t *testing.T, server *httptest.Server, token string) int64 {
 	resp, err := resty.New().SetBaseURL(server.URL).R().
@@ -82,35 +165,90 @@ func IncreaseBalance(t *testing.T, server *httptest.Server, token string) int64
 func userService(t *testing.T) user.Service {
 	conf := defaultTestConfig()
 
-	service, err := user.NewService(userStorage.NewInMemoryRepository(), &user.Options{
-		TokenSecret:           []byte("test"),
-		PasswordSalt:          "test",
-		MinPasswordLength:     conf.MinPasswordLength,
-		TokenExpirationPeriod: conf.TokenExpirationPeriod,
+	// cheap parameters keep tests fast
+	hasher, err := password.NewHasher(&password.Options{
+		Algorithm: password.Argon2id,
+		Argon2: password.Argon2Params{
+			Memory:      1024,
+			Iterations:  1,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+	})
+	require.NoError(t, err)
+
+	credentialsPolicy, err := policy.NewPolicy(&policy.Options{
+		MinLoginLength:      conf.MinLoginLength,
+		MaxLoginLength:      conf.MaxLoginLength,
+		FoldLoginCase:       conf.FoldLoginCase,
+		MinPasswordLength:   conf.MinPasswordLength,
+		MaxPasswordLength:   conf.MaxPasswordLength,
+		MinCharacterClasses: conf.PasswordMinCharacterClasses,
+	})
+	require.NoError(t, err)
+
+	service, err := user.NewService(userStorage.NewInMemoryRepository(), sessions.NewMemoryRepository(), keys.NewMemoryRepository(), resets.NewMemoryRepository(), mfa.NewMemoryRepository(), notifier.NewLogNotifier(), &user.Options{
+		SigningAlgorithm:             user.SigningEdDSA,
+		KeyRotationPeriod:            conf.SigningKeyRotationPeriod,
+		KeyReloadInterval:            conf.SigningKeyReloadInterval,
+		PasswordHasher:               hasher,
+		Policy:                       credentialsPolicy,
+		TokenExpirationPeriod:        conf.TokenExpirationPeriod,
+		RefreshTokenExpirationPeriod: conf.RefreshTokenExpirationPeriod,
+		ResetTokenExpirationPeriod:   time.Hour,
+		MFAIssuer:                    "Gophermart",
+		MFAChallengeExpirationPeriod: time.Minute,
 	})
 	require.NoError(t, err)
 
 	return service
 }
 
-func orderService() order.Service {
-	return order.NewService(orderStorage.NewInMemoryRepository(), newDummyPoller())
+func throttleService() throttle.Service {
+	conf := defaultTestConfig()
+
+	options := throttle.DefaultOptions
+	options.Login.LockoutThreshold = conf.LoginLockoutThreshold
+	options.Login.LockoutDuration = conf.LoginLockoutDuration
+
+	return throttle.NewService(throttleStorage.NewMemoryRepository(), &options)
 }
 
-func balanceService(t *testing.T) balance.Service {
-	b, err := balance.NewService(balanceStorage.NewInMemoryRepository(), withdrawals.NewMemoryRepository(), newDummyTxProvider())
-	require.NoError(t, err)
+func orderService(creditor order.AccrualCreditor) order.Service {
+	return order.NewService(orderStorage.NewInMemoryRepository(), newDummyPoller(), creditor, test.NewDummyTxProvider())
+}
+
+func balanceService() balance.Service {
+	return test.NewBalanceService(&balance.Options{
+		Programs: []*balance.Program{{ID: BrandProgram, Rate: 50}},
+	})
+}
 
-	return b
+func webhookService() webhook.Service {
+	return webhook.NewService(webhookStorage.NewMemoryRepository(), &webhook.Options{
+		Secret:    []byte(WebhookSecret),
+		Tolerance: time.Minute,
+	})
 }
 
 func defaultTestConfig() *config.Config {
 	return &config.Config{
-		RunAddress:            "",
-		DatabaseDSN:           "",
-		DatabaseTimeout:       time.Second,
-		AccrualSystemAddress:  "",
-		MinPasswordLength:     12,
-		TokenExpirationPeriod: time.Hour,
+		RunAddress:                   "",
+		DatabaseDSN:                  "",
+		DatabaseTimeout:              time.Second,
+		AccrualSystemAddress:         "",
+		MinPasswordLength:            12,
+		TokenExpirationPeriod:        time.Hour,
+		RefreshTokenExpirationPeriod: 24 * time.Hour,
+		SigningKeyRotationPeriod:     24 * time.Hour,
+		SigningKeyReloadInterval:     time.Minute,
+		LoginLockoutThreshold:        5,
+		LoginLockoutDuration:         time.Hour,
+		MinLoginLength:               2,
+		MaxLoginLength:               64,
+		MaxPasswordLength:            128,
+		FoldLoginCase:                true,
+		PasswordMinCharacterClasses:  1,
 	}
 }
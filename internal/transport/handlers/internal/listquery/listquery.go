@@ -0,0 +1,73 @@
+package listquery
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/pagination"
+)
+
+// NextCursorHeader carries the cursor of the next page, lists themselves are returned as plain arrays
+const NextCursorHeader = "X-Next-Cursor"
+
+type Query struct {
+	// Statuses from comma separated list
+	Statuses []string
+	From     time.Time
+	To       time.Time
+	After    *pagination.Cursor
+	Limit    int
+}
+
+type raw struct {
+	Status string `query:"status"`
+	From   string `query:"from"`
+	To     string `query:"to"`
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+}
+
+func Parse(ctx *fiber.Ctx) (*Query, error) {
+	r := new(raw)
+	if err := ctx.QueryParser(r); err != nil {
+		return nil, err
+	}
+
+	q := &Query{Limit: r.Limit}
+
+	if r.Status != "" {
+		q.Statuses = strings.Split(r.Status, ",")
+	}
+
+	var err error
+	if r.From != "" {
+		q.From, err = time.Parse(time.RFC3339, r.From)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.To != "" {
+		q.To, err = time.Parse(time.RFC3339, r.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Cursor != "" {
+		q.After, err = pagination.ParseCursor(r.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+func SetNextCursor(ctx *fiber.Ctx, cursor *pagination.Cursor) {
+	if cursor != nil {
+		ctx.Set(NextCursorHeader, cursor.String())
+	}
+}
@@ -0,0 +1,90 @@
+package jwks
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+type Handler struct {
+	userService user.Service
+	maxAge      time.Duration
+}
+
+// New handler of the JSON Web Key Set. Relying parties may cache it for maxAge
+func New(userService user.Service, maxAge time.Duration) *Handler {
+	return &Handler{
+		userService: userService,
+		maxAge:      maxAge,
+	}
+}
+
+type jwkJSON struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type jwksJSON struct {
+	Keys []jwkJSON `json:"keys"`
+}
+
+func (h *Handler) Handle(ctx *fiber.Ctx) error {
+	keys, err := h.userService.PublicKeys(ctx.Context())
+	if err != nil {
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	result := jwksJSON{Keys: make([]jwkJSON, 0, len(keys))}
+	for _, key := range keys {
+		jwk, err := toJWK(key)
+		if err != nil {
+			log.Logger().Errorw("cant encode public key", "kid", key.ID, "error", err)
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		result.Keys = append(result.Keys, jwk)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(h.maxAge.Seconds())))
+	ctx.Status(fiber.StatusOK)
+
+	return ctx.JSON(result)
+}
+
+func toJWK(key *user.PublicKey) (jwkJSON, error) {
+	jwk := jwkJSON{
+		KeyID:     key.ID,
+		Algorithm: key.Algorithm.String(),
+		Use:       "sig",
+	}
+
+	switch k := key.Key.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	default:
+		return jwk, fmt.Errorf("unsupported key type %T", key.Key)
+	}
+
+	return jwk, nil
+}
@@ -1,6 +1,7 @@
 package list
 
 import (
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,46 +9,72 @@ import (
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/listquery"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/userid"
 )
 
 type Handler struct {
 	orderService order.Service
+	userID       userid.Source
 }
 
-func New(orderService order.Service) *Handler {
+func New(orderService order.Service, userID userid.Source) *Handler {
 	return &Handler{
 		orderService: orderService,
+		userID:       userID,
 	}
 }
 
 type orderJSON struct {
-	Number     string   `json:"number"`
-	Status     string   `json:"status"`
-	Accrual    *float64 `json:"accrual,omitempty"`
-	UploadedAt string   `json:"uploaded_at"`
+	Number     string        `json:"number"`
+	Program    string        `json:"program"`
+	Status     string        `json:"status"`
+	Accrual    *money.Amount `json:"accrual,omitempty"`
+	UploadedAt string        `json:"uploaded_at"`
 }
 
 func (h *Handler) Handle(ctx *fiber.Ctx) error {
-	userIDRaw := ctx.Locals("userid")
-	userID, ok := userIDRaw.(string)
+	userID, ok := h.userID(ctx)
 	if !ok {
-		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
-		panic("no user id")
+		return ctx.SendStatus(fiber.StatusNotFound)
 	}
 
-	list, err := h.orderService.List(ctx.Context(), userID)
-
+	q, err := listquery.Parse(ctx)
 	if err != nil {
+		log.Logger().Debugw("invalid query", "err", err, "requestid", ctx.Locals("requestid"))
+
+		return ctx.SendStatus(fiber.StatusBadRequest)
+	}
+
+	filter := &order.ListFilter{
+		From:  q.From,
+		To:    q.To,
+		After: q.After,
+		Limit: q.Limit,
+	}
+	for _, status := range q.Statuses {
+		filter.Statuses = append(filter.Statuses, order.Status(status))
+	}
+
+	page, err := h.orderService.List(ctx.Context(), userID, filter)
+
+	if errors.Is(err, order.ErrInvalidListFilter) {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
+	} else if err != nil {
 		return ctx.SendStatus(fiber.StatusInternalServerError)
 	}
 
-	if len(list) == 0 {
+	if len(page.Orders) == 0 {
 		ctx.Status(fiber.StatusNoContent)
 	} else {
 		ctx.Status(fiber.StatusOK)
 	}
 
-	json := mapOrdersToJSON(list)
+	listquery.SetNextCursor(ctx, page.NextCursor)
+
+	json := mapOrdersToJSON(page.Orders)
 
 	return ctx.JSON(json)
 }
@@ -58,14 +85,15 @@ func mapOrdersToJSON(orders []*order.Order) []*orderJSON {
 	for _, o := range orders {
 		singleResult := &orderJSON{
 			Number:     o.Number,
+			Program:    o.Program,
 			Status:     string(o.Status),
 			UploadedAt: o.UploadedAt.Format(time.RFC3339),
 		}
 
 		if o.Accrual != nil {
-			floatAccrual := money.IntToFloat(*o.Accrual)
+			accrual := money.Amount(*o.Accrual)
 
-			singleResult.Accrual = &floatAccrual
+			singleResult.Accrual = &accrual
 		}
 
 		result = append(result, singleResult)
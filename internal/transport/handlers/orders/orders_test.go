@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/money"
 	"github.com/kuvalkin/gophermart-loyalty/internal/test"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/internal/handlerstest"
 )
@@ -27,6 +28,7 @@ func TestOrders(t *testing.T) {
 	})
 
 	t.Run("flow", testOrdersFlow)
+	t.Run("pagination", testOrdersPagination)
 }
 
 func testAuth(t *testing.T) {
@@ -114,6 +116,34 @@ func testUploadValidation(t *testing.T) {
 	}
 
 	handlerstest.TestEndpoint(t, server, tests, http.MethodPost, orders)
+
+	programs := []handlerstest.TCase{
+		{
+			Name:        "brand program",
+			Token:       token,
+			ContentType: "text/plain",
+			Body:        test.NewOrderNumber(),
+			Want: handlerstest.Want{
+				Status: http.StatusAccepted,
+			},
+		},
+	}
+
+	handlerstest.TestEndpoint(t, server, programs, http.MethodPost, orders+"?program="+handlerstest.BrandProgram)
+
+	unknown := []handlerstest.TCase{
+		{
+			Name:        "unknown program",
+			Token:       token,
+			ContentType: "text/plain",
+			Body:        test.NewOrderNumber(),
+			Want: handlerstest.Want{
+				Status: http.StatusBadRequest,
+			},
+		},
+	}
+
+	handlerstest.TestEndpoint(t, server, unknown, http.MethodPost, orders+"?program=unknown")
 }
 
 func testOrdersFlow(t *testing.T) {
@@ -162,6 +192,7 @@ func testOrdersFlow(t *testing.T) {
 					`[
 						{
 							"number": "%v",
+							"program": "default",
 							"status": "PROCESSED",
 							"uploaded_at": "%v",
 							"accrual": %v
@@ -169,7 +200,7 @@ func testOrdersFlow(t *testing.T) {
 					]`,
 					number,
 					time.Now().Format(time.RFC3339),
-					handlerstest.ProcessedOrderAccrualFloat,
+					money.Amount(handlerstest.ProcessedOrderAccrual),
 				),
 				string(response.Body()),
 			)
@@ -236,6 +267,7 @@ func testOrdersFlow(t *testing.T) {
 					`[
 						{
 							"number": "%v",
+							"program": "default",
 							"status": "PROCESSED",
 							"uploaded_at": "%v",
 							"accrual": %v
@@ -243,10 +275,101 @@ func testOrdersFlow(t *testing.T) {
 					]`,
 					newOrderNumber,
 					time.Now().Format(time.RFC3339),
-					handlerstest.ProcessedOrderAccrualFloat,
+					money.Amount(handlerstest.ProcessedOrderAccrual),
 				),
 				string(response.Body()),
 			)
 		})
 	})
 }
+
+func testOrdersPagination(t *testing.T) {
+	testServer, token := handlerstest.NewTestServerWithLoggedInUser(t)
+	defer testServer.Close()
+
+	client := resty.New().SetBaseURL(testServer.URL)
+
+	numbers := make([]string, 0, 3)
+	for range 3 {
+		number := test.NewOrderNumber()
+
+		response, err := client.R().SetAuthToken(token).SetBody(number).Post(orders)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, response.StatusCode())
+
+		numbers = append(numbers, number)
+	}
+
+	type orderResponse struct {
+		Number string `json:"number"`
+	}
+
+	t.Run("pages are newest first", func(t *testing.T) {
+		var first []orderResponse
+
+		response, err := client.R().SetAuthToken(token).SetResult(&first).SetQueryParam("limit", "2").Get(orders)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		require.Len(t, first, 2)
+		assert.Equal(t, numbers[2], first[0].Number)
+		assert.Equal(t, numbers[1], first[1].Number)
+
+		cursor := response.Header().Get("X-Next-Cursor")
+		require.NotEmpty(t, cursor)
+
+		var second []orderResponse
+
+		response, err = client.R().SetAuthToken(token).SetResult(&second).SetQueryParams(map[string]string{
+			"limit":  "2",
+			"cursor": cursor,
+		}).Get(orders)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+		require.Len(t, second, 1)
+		assert.Equal(t, numbers[0], second[0].Number)
+		assert.Empty(t, response.Header().Get("X-Next-Cursor"))
+	})
+
+	t.Run("status filter", func(t *testing.T) {
+		// dummy accrual system processes every order
+		require.Eventually(t, func() bool {
+			var list []orderResponse
+
+			response, err := client.R().SetAuthToken(token).SetResult(&list).SetQueryParam("status", "PROCESSED,INVALID").Get(orders)
+			require.NoError(t, err)
+
+			return response.StatusCode() == http.StatusOK && len(list) == 3
+		}, time.Second, time.Millisecond*10)
+
+		response, err := client.R().SetAuthToken(token).SetQueryParam("status", "NEW").Get(orders)
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode())
+	})
+
+	t.Run("date filter", func(t *testing.T) {
+		response, err := client.R().SetAuthToken(token).
+			SetQueryParam("from", time.Now().Add(time.Hour).Format(time.RFC3339)).
+			Get(orders)
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode())
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		for _, params := range []map[string]string{
+			{"limit": "-1"},
+			{"limit": "100000"},
+			{"status": "DONE"},
+			{"cursor": "not a cursor"},
+			{"to": "tomorrow"},
+		} {
+			response, err := client.R().SetAuthToken(token).SetQueryParams(params).Get(orders)
+
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode(), params)
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 )
@@ -34,13 +35,17 @@ func (h *Handler) Handle(ctx *fiber.Ctx) error {
 
 	body := strings.TrimSpace(string(ctx.Body()))
 
-	err := h.orderService.Upload(ctx.Context(), userID, body)
+	err := h.orderService.Upload(ctx.Context(), userID, body, ctx.Query("program", balance.DefaultProgram))
 	if errors.Is(err, order.ErrAlreadyUploaded) {
 		return ctx.SendStatus(fiber.StatusOK)
 	} else if errors.Is(err, order.ErrUploadedByAnotherUser) {
 		return ctx.SendStatus(fiber.StatusConflict)
 	} else if errors.Is(err, order.ErrInvalidNumber) {
 		return ctx.SendStatus(fiber.StatusUnprocessableEntity)
+	} else if errors.Is(err, order.ErrUnknownProgram) {
+		ctx.Status(fiber.StatusBadRequest)
+
+		return ctx.SendString(err.Error())
 	} else if err != nil {
 		return ctx.SendStatus(fiber.StatusInternalServerError)
 	}
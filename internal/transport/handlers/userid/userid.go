@@ -0,0 +1,31 @@
+// Package userid tells handlers, that are shared by user and admin routes, whose data they work with
+package userid
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+// Source of the user ID. Returns false if there is no such user
+type Source func(ctx *fiber.Ctx) (string, bool)
+
+// Authenticated user works with own data
+func Authenticated(ctx *fiber.Ctx) (string, bool) {
+	userIDRaw := ctx.Locals("userid")
+	userID, ok := userIDRaw.(string)
+	if !ok {
+		log.Logger().Fatalw("no user id", "userIDRaw", userIDRaw)
+		panic("no user id")
+	}
+
+	return userID, true
+}
+
+// Param of the path, staff works with data of any user
+func Param(ctx *fiber.Ctx) (string, bool) {
+	userID := ctx.Params("userid")
+
+	return userID, uuid.Validate(userID) == nil
+}
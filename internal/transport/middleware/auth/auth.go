@@ -1,12 +1,13 @@
 package auth
 
 import (
-	"strings"
+	"errors"
 
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/middleware/internal"
 )
 
 func New(userService user.Service) func(ctx *fiber.Ctx) error {
@@ -15,36 +16,27 @@ func New(userService user.Service) func(ctx *fiber.Ctx) error {
 	return func(ctx *fiber.Ctx) error {
 		authRequestLogger := authLogger.WithLazy("requestId", ctx.Locals("requestid"))
 
-		authSlice, ok := ctx.GetReqHeaders()["Authorization"]
-		if !ok {
-			authRequestLogger.Debug("no Authorization header")
-
-			return ctx.SendStatus(fiber.StatusUnauthorized)
-		}
-
-		if len(authSlice) != 1 {
-			authRequestLogger.Debugw("invalid Authorization header", "header", authSlice)
-
-			return ctx.SendStatus(fiber.StatusUnauthorized)
-		}
-
-		bearer := authSlice[0]
-
-		token, found := strings.CutPrefix(bearer, "Bearer ")
-		if !found {
-			authRequestLogger.Debugw("Authorization header is not Bearer format", "header", bearer)
+		token, err := internal.BearerToken(ctx)
+		if err != nil {
+			authRequestLogger.Debugw("no token", "error", err)
 
 			return ctx.SendStatus(fiber.StatusUnauthorized)
 		}
 
-		userID, err := userService.ParseToken(ctx.Context(), token)
+		claims, err := userService.ParseToken(ctx.Context(), token)
 		if err != nil {
+			if errors.Is(err, user.ErrInternal) {
+				return ctx.SendStatus(fiber.StatusInternalServerError)
+			}
+
 			authRequestLogger.Debugw("token check failed", "error", err)
 
 			return ctx.SendStatus(fiber.StatusUnauthorized)
 		}
 
-		ctx.Locals("userid", userID)
+		ctx.Locals("userid", claims.UserID)
+		ctx.Locals("sessionid", claims.SessionID)
+		ctx.Locals("role", claims.Role)
 
 		return ctx.Next()
 	}
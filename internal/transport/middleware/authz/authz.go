@@ -0,0 +1,38 @@
+package authz
+
+import (
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
+)
+
+// New middleware, that allows only requests of users with one of the roles. Must be used after the auth middleware
+func New(roles ...user.Role) func(ctx *fiber.Ctx) error {
+	authzLogger := log.Logger().Named("authz")
+
+	return func(ctx *fiber.Ctx) error {
+		roleRaw := ctx.Locals("role")
+		role, ok := roleRaw.(user.Role)
+		if !ok {
+			authzLogger.Fatalw("no role", "roleRaw", roleRaw)
+			panic("no role")
+		}
+
+		if !slices.Contains(roles, role) {
+			authzLogger.Infow(
+				"access denied",
+				"requestId", ctx.Locals("requestid"),
+				"userid", ctx.Locals("userid"),
+				"role", role,
+				"path", ctx.Path(),
+			)
+
+			return ctx.SendStatus(fiber.StatusForbidden)
+		}
+
+		return ctx.Next()
+	}
+}
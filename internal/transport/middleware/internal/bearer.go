@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BearerToken from the Authorization header
+func BearerToken(ctx *fiber.Ctx) (string, error) {
+	authSlice, ok := ctx.GetReqHeaders()["Authorization"]
+	if !ok {
+		return "", errors.New("no Authorization header")
+	}
+
+	if len(authSlice) != 1 {
+		return "", errors.New("invalid Authorization header")
+	}
+
+	token, found := strings.CutPrefix(authSlice[0], "Bearer ")
+	if !found {
+		return "", errors.New("Authorization header is not Bearer format")
+	}
+
+	return token, nil
+}
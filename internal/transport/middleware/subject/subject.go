@@ -0,0 +1,29 @@
+package subject
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+)
+
+// New middleware, that responds with 404 unless the user from the userid path param exists
+func New(userService user.Service) func(ctx *fiber.Ctx) error {
+	return func(ctx *fiber.Ctx) error {
+		userID := ctx.Params("userid")
+		if uuid.Validate(userID) != nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		_, err := userService.GetUser(ctx.Context(), userID)
+		if errors.Is(err, user.ErrUserNotFound) {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		} else if err != nil {
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return ctx.Next()
+	}
+}
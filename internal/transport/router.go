@@ -8,25 +8,56 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/support/config"
+	accrualWebhook "github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/accrual/webhook"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/adjustments/add"
+	adjustmentsList "github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/adjustments/list"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/users/find"
+	adminUsersGet "github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/users/get"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/users/role"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/users/unlock"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/admin/withdrawals/cancel"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/login"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/logout"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/mfa/complete"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/mfa/confirm"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/mfa/disable"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/mfa/enroll"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/password/change"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/password/forgot"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/password/reset"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/refresh"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/auth/register"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/balance/get"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/balance/statement"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/balance/withdraw"
 	withdrawalsList "github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/balance/withdraw/list"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/jwks"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/orders/list"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/orders/upload"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/handlers/userid"
 	"github.com/kuvalkin/gophermart-loyalty/internal/transport/middleware/auth"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/middleware/authz"
+	"github.com/kuvalkin/gophermart-loyalty/internal/transport/middleware/subject"
 )
 
-func createAppWithRoutes(services *Services) *fiber.App {
+func createAppWithRoutes(conf *config.Config, services *Services) *fiber.App {
 	app := fiber.New(fiber.Config{
 		AppName:            "gophermart-loyalty",
 		EnableIPValidation: true,
 		Immutable:          true,
+		// client IPs are throttled, so they are taken from the proxy header only if the request came from a trusted proxy
+		ProxyHeader:             conf.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          conf.TrustedProxies,
 	})
 
 	globalMiddleware(app)
+	wellKnownRoutes(app, conf, services)
 	routes(app, services)
+	adminRoutes(app, services)
+	webhookRoutes(app, services)
 
 	return app
 }
@@ -41,20 +72,66 @@ func globalMiddleware(app *fiber.App) {
 	app.Use(healthcheck.New())
 }
 
+func wellKnownRoutes(app *fiber.App, conf *config.Config, services *Services) {
+	// new keys are published two reload intervals before they sign tokens, so caching for one interval is safe
+	app.Get("/.well-known/jwks.json", jwks.New(services.User, conf.SigningKeyReloadInterval).Handle)
+}
+
 func routes(app *fiber.App, services *Services) {
 	apiGroup := app.Group("/api")
 
 	userGroup := apiGroup.Group("/user")
 
 	userGroup.Post("/register", register.New(services.User).Handle)
-	userGroup.Post("/login", login.New(services.User).Handle)
+	userGroup.Post("/login", login.New(services.User, services.Throttle).Handle)
+	userGroup.Post("/login/mfa", complete.New(services.User).Handle)
+	userGroup.Post("/token/refresh", refresh.New(services.User).Handle)
+	userGroup.Post("/password/forgot", forgot.New(services.User, services.Throttle).Handle)
+	userGroup.Post("/password/reset", reset.New(services.User).Handle)
 
 	authMiddleware := auth.New(services.User)
 
+	userGroup.Post("/logout", authMiddleware, logout.New(services.User).Handle)
+	userGroup.Post("/password", authMiddleware, change.New(services.User, services.Throttle).Handle)
+	userGroup.Post("/mfa/totp", authMiddleware, enroll.New(services.User).Handle)
+	userGroup.Post("/mfa/totp/confirm", authMiddleware, confirm.New(services.User, services.Throttle).Handle)
+	userGroup.Post("/mfa/totp/disable", authMiddleware, disable.New(services.User, services.Throttle).Handle)
+
 	userGroup.Post("/orders", authMiddleware, upload.New(services.Order).Handle)
-	userGroup.Get("/orders", authMiddleware, list.New(services.Order).Handle)
+	userGroup.Get("/orders", authMiddleware, list.New(services.Order, userid.Authenticated).Handle)
 
-	userGroup.Get("/balance", authMiddleware, get.New(services.Balance).Handle)
+	userGroup.Get("/balance", authMiddleware, get.New(services.Balance, userid.Authenticated).Handle)
 	userGroup.Post("/balance/withdraw", authMiddleware, withdraw.New(services.Balance).Handle)
-	userGroup.Get("/withdrawals", authMiddleware, withdrawalsList.New(services.Balance).Handle)
+	userGroup.Get("/withdrawals", authMiddleware, withdrawalsList.New(services.Balance, userid.Authenticated).Handle)
+	userGroup.Get("/statement", authMiddleware, statement.New(services.Balance).Handle)
+}
+
+func adminRoutes(app *fiber.App, services *Services) {
+	adminGroup := app.Group("/api/admin", auth.New(services.User), authz.New(user.RoleSupport, user.RoleAdmin))
+
+	adminGroup.Get("/users", find.New(services.User).Handle)
+
+	userGroup := adminGroup.Group("/users/:userid", subject.New(services.User))
+
+	userGroup.Get("/", adminUsersGet.New(services.User).Handle)
+	userGroup.Get("/orders", list.New(services.Order, userid.Param).Handle)
+	userGroup.Get("/balance", get.New(services.Balance, userid.Param).Handle)
+	userGroup.Get("/withdrawals", withdrawalsList.New(services.Balance, userid.Param).Handle)
+	userGroup.Post("/unlock", unlock.New(services.User, services.Throttle).Handle)
+	userGroup.Get("/adjustments", adjustmentsList.New(services.Balance).Handle)
+
+	adminOnly := authz.New(user.RoleAdmin)
+
+	userGroup.Put("/role", adminOnly, role.New(services.User).Handle)
+	userGroup.Post("/adjustments", adminOnly, add.New(services.Balance).Handle)
+	userGroup.Post("/withdrawals/:order/cancel", adminOnly, cancel.New(services.Balance).Handle)
+}
+
+// webhookRoutes are authenticated with signatures of the senders instead of tokens
+func webhookRoutes(app *fiber.App, services *Services) {
+	if services.Webhook == nil {
+		return
+	}
+
+	app.Post("/api/accrual/webhook", accrualWebhook.New(services.Webhook, services.Order).Handle)
 }
@@ -9,7 +9,9 @@ import (
 
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/balance"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/order"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/throttle"
 	"github.com/kuvalkin/gophermart-loyalty/internal/service/user"
+	"github.com/kuvalkin/gophermart-loyalty/internal/service/webhook"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/config"
 	"github.com/kuvalkin/gophermart-loyalty/internal/support/log"
 )
@@ -20,13 +22,16 @@ type Server struct {
 }
 
 type Services struct {
-	User    user.Service
-	Order   order.Service
-	Balance balance.Service
+	User     user.Service
+	Order    order.Service
+	Balance  balance.Service
+	Throttle throttle.Service
+	// Webhook verifies results pushed by the accrual system, nil if pushing is disabled
+	Webhook webhook.Service
 }
 
 func NewServer(conf *config.Config, services *Services) *Server {
-	app := createAppWithRoutes(services)
+	app := createAppWithRoutes(conf, services)
 
 	return &Server{app: app, address: conf.RunAddress}
 }